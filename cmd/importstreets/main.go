@@ -0,0 +1,197 @@
+// Command importstreets loads a city's street network from an OpenStreetMap PBF extract or a
+// GeoJSON file into the streets and street_segments tables, and optionally the city's boundary
+// polygon, which routes visits off the imported streets to the city, and its district polygons,
+// which break coverage down below city level.
+//
+//	go run ./cmd/importstreets -city Sofia -country BG -file sofia.osm.pbf -boundary sofia.geojson
+//	go run ./cmd/importstreets -city Sofia -country BG -districts sofia-neighborhoods.geojson -district-level neighborhood
+//
+// Re-running it for the same city replaces that city's streets, or its districts of that level.
+// Each of -file, -boundary and -districts may be given on its own.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	city := flag.String("city", "", "city name (required)")
+	state := flag.String("state", "", "state or region, to tell apart cities sharing a name")
+	country := flag.String("country", "", "country code (required)")
+	file := flag.String("file", "", "OSM .pbf extract or GeoJSON file with the streets")
+	format := flag.String("format", "", "pbf or geojson; detected from the file extension when omitted")
+	boundary := flag.String("boundary", "", "GeoJSON file with the city's Polygon or MultiPolygon boundary")
+	districtsFile := flag.String("districts", "", "GeoJSON FeatureCollection of district polygons named by their \"name\" property")
+	districtLevel := flag.String("district-level", types.DistrictLevelDistrict, "district or neighborhood")
+	flag.Parse()
+
+	if *city == "" || *country == "" || (*file == "" && *boundary == "" && *districtsFile == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *districtLevel != types.DistrictLevelDistrict && *districtLevel != types.DistrictLevelNeighborhood {
+		log.Fatalf("unknown district level %q, expected district or neighborhood", *districtLevel)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	if os.Getenv("DATABASE_URL") == "" {
+		log.Fatal("DATABASE_URL environment variable is not set")
+	}
+
+	var streets []utils.Street
+	if *file != "" {
+		if *format == "" {
+			*format = detectFormat(*file)
+		}
+
+		started := time.Now()
+		var err error
+		streets, err = readStreets(*file, *format, utils.Slugify(strings.Join([]string{*country, *state, *city}, " ")))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Read %d streets from %s in %s", len(streets), *file, time.Since(started).Round(time.Millisecond))
+	}
+
+	var polygons []utils.Polygon
+	if *boundary != "" {
+		raw, err := os.ReadFile(*boundary)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *boundary, err)
+		}
+		polygons, err = utils.ParseBoundaryGeoJSON(raw)
+		if err != nil {
+			log.Fatalf("failed to read boundary: %v", err)
+		}
+	}
+
+	var districts []utils.NamedBoundary
+	if *districtsFile != "" {
+		raw, err := os.ReadFile(*districtsFile)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *districtsFile, err)
+		}
+		districts, err = utils.ParseDistrictsGeoJSON(raw)
+		if err != nil {
+			log.Fatalf("failed to read districts: %v", err)
+		}
+	}
+
+	client := db.NewClient()
+	if err := client.Prisma.Connect(); err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer func() {
+		if err := client.Prisma.Disconnect(); err != nil {
+			log.Printf("Failed to disconnect: %v", err)
+		}
+	}()
+
+	ctx := context.Background()
+	streetService := services.NewStreetService(client)
+	var cityID string
+	if *file != "" {
+		imported, err := streetService.ImportCity(ctx, *city, *state, *country, streets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cityID = imported.ID
+		log.Printf("Imported %d streets (%.1f km) into city %s", imported.StreetCount, imported.TotalLengthMeters/1000, imported.ID)
+	} else {
+		ensured, err := streetService.EnsureCity(ctx, *city, *state, *country)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cityID = ensured.ID
+	}
+
+	if len(polygons) > 0 {
+		routed, err := streetService.SetCityBoundary(ctx, cityID, polygons)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Stored boundary of city %s and routed %d visits to it", cityID, routed)
+	}
+
+	// district streets are rebuilt whenever the districts or the streets under them change
+	consentService := services.NewConsentService(client)
+	districtService := services.NewDistrictService(client, consentService)
+	if len(districts) > 0 {
+		assigned, err := districtService.ImportDistricts(ctx, cityID, *districtLevel, districts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Imported %d %ss of city %s covering %d district streets", len(districts), *districtLevel, cityID, assigned)
+	} else if *file != "" {
+		if _, err := districtService.AssignStreets(ctx, cityID); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *file == "" && *boundary == "" {
+		// district coverage is computed on request, so city stats are unaffected
+		return
+	}
+
+	// street lengths and visit cities changed, so every user's stats have to be recomputed
+	achievementService := services.NewAchievementService(client, consentService)
+	// levels are stored with the XP, so they follow the thresholds the server is configured with
+	levelPolicy, err := services.LevelPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	xpService := services.NewXpService(client, levelPolicy)
+	recomputed, err := services.NewStatsService(client, consentService, achievementService, xpService).RecomputeAll(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Recomputed stats for %d users", recomputed)
+}
+
+func detectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pbf":
+		return "pbf"
+	default:
+		return "geojson"
+	}
+}
+
+// readStreets parses the extract. OSM streets get ids namespaced by idPrefix; GeoJSON
+// features keep their own ids so they match a street graph file clients already use.
+func readStreets(path, format, idPrefix string) ([]utils.Street, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	switch format {
+	case "pbf":
+		ways, err := utils.ReadOSMWays(f, utils.IsWalkableStreet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OSM extract: %w", err)
+		}
+		return utils.StreetsFromOSMWays(ways, idPrefix), nil
+	case "geojson":
+		return utils.ParseStreetsGeoJSON(f)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected pbf or geojson", format)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+)
+
+type AchievementHandler struct {
+	achievementService *services.AchievementService
+}
+
+func NewAchievementHandler(achievementService *services.AchievementService) *AchievementHandler {
+	return &AchievementHandler{achievementService: achievementService}
+}
+
+// ListAchievements handles GET /api/user/achievements - every badge, earned or not, with the
+// user's progress towards it
+func (h *AchievementHandler) ListAchievements(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	achievements, err := h.achievementService.Achievements(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, achievements, http.StatusOK)
+}
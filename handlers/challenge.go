@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"github.com/gorilla/mux"
+)
+
+type ChallengeHandler struct {
+	challengeService *services.ChallengeService
+}
+
+func NewChallengeHandler(challengeService *services.ChallengeService) *ChallengeHandler {
+	return &ChallengeHandler{challengeService: challengeService}
+}
+
+// CreateChallenge handles POST /api/challenges - the creator joins it and their invited friends
+// join by accepting
+func (h *ChallengeHandler) CreateChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.CreateChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.challengeService.CreateChallenge(r.Context(), userID, req)
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenge, http.StatusCreated)
+}
+
+// ListChallenges handles GET /api/challenges - the caller's challenges, without standings
+func (h *ChallengeHandler) ListChallenges(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	challenges, err := h.challengeService.ListChallenges(r.Context(), userID)
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenges, http.StatusOK)
+}
+
+// GetChallenge handles GET /api/challenges/{challengeId} - the challenge with its standings
+func (h *ChallengeHandler) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := h.challengeService.GetChallenge(r.Context(), userID, mux.Vars(r)["challengeId"])
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenge, http.StatusOK)
+}
+
+// InviteToChallenge handles POST /api/challenges/{challengeId}/invite
+func (h *ChallengeHandler) InviteToChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.InviteToChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.challengeService.Invite(r.Context(), userID, mux.Vars(r)["challengeId"], req.UserIDs)
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenge, http.StatusOK)
+}
+
+// JoinChallenge handles POST /api/challenges/{challengeId}/join
+func (h *ChallengeHandler) JoinChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := h.challengeService.Join(r.Context(), userID, mux.Vars(r)["challengeId"])
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenge, http.StatusOK)
+}
+
+// DeclineChallenge handles POST /api/challenges/{challengeId}/decline - also leaves a joined challenge
+func (h *ChallengeHandler) DeclineChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := h.challengeService.Decline(r.Context(), userID, mux.Vars(r)["challengeId"])
+	if err != nil {
+		writeChallengeError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, challenge, http.StatusOK)
+}
+
+func writeChallengeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrConsentRequired):
+		middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	case strings.Contains(err.Error(), "challenge not found"):
+		middleware.ErrorResponse(w, "Challenge not found", http.StatusNotFound)
+	case strings.Contains(err.Error(), "invalid challenge"):
+		middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case strings.Contains(err.Error(), "has ended"):
+		middleware.ErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
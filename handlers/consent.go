@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+)
+
+const (
+	defaultConsentLedgerLimit = 50
+	maxConsentLedgerLimit     = 200
+)
+
+type ConsentHandler struct {
+	consentService *services.ConsentService
+}
+
+func NewConsentHandler(consentService *services.ConsentService) *ConsentHandler {
+	return &ConsentHandler{consentService: consentService}
+}
+
+// GetConsents handles GET /api/consent - what the caller's data may currently be used for.
+// Consents are changed through the settings flags.
+func (h *ConsentHandler) GetConsents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	consents, err := h.consentService.Consents(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, consents, http.StatusOK)
+}
+
+// GetLedger handles GET /api/consent/ledger?limit=&offset= - every recorded consent change, newest first
+func (h *ConsentHandler) GetLedger(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultConsentLedgerLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxConsentLedgerLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			middleware.ErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	events, err := h.consentService.Ledger(r.Context(), userID, limit, offset)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, types.ConsentLedgerResponse{Events: events}, http.StatusOK)
+}
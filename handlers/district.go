@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+type DistrictHandler struct {
+	districtService *services.DistrictService
+}
+
+func NewDistrictHandler(districtService *services.DistrictService) *DistrictHandler {
+	return &DistrictHandler{districtService: districtService}
+}
+
+// ListDistricts handles GET /api/stats/districts - the caller's coverage of every district of a city.
+// Query params: cityId (defaults to the current city), level, and lat/lng to find the nearest
+// incomplete district from the caller's position instead of their latest visit.
+func (h *DistrictHandler) ListDistricts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	level := query.Get("level")
+	if level != "" && level != types.DistrictLevelDistrict && level != types.DistrictLevelNeighborhood {
+		middleware.ErrorResponse(w, "level must be district or neighborhood", http.StatusBadRequest)
+		return
+	}
+
+	var ref *utils.LatLng
+	if query.Get("lat") != "" || query.Get("lng") != "" {
+		lat, errLat := strconv.ParseFloat(query.Get("lat"), 64)
+		lng, errLng := strconv.ParseFloat(query.Get("lng"), 64)
+		if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			middleware.ErrorResponse(w, "lat and lng must be valid coordinates", http.StatusBadRequest)
+			return
+		}
+		ref = &utils.LatLng{Lat: lat, Lng: lng}
+	}
+
+	coverage, err := h.districtService.Coverage(r.Context(), userID, query.Get("cityId"), level, ref)
+	if err != nil {
+		if strings.Contains(err.Error(), "no current city") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, coverage, http.StatusOK)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+
+	"github.com/gorilla/mux"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportService: exportService}
+}
+
+// ExportGeoJSON handles GET /api/export/geojson?from=&to=&sessionId=&cityId=
+// from and to accept unix milliseconds, RFC 3339 timestamps or YYYY-MM-DD dates.
+func (h *ExportHandler) ExportGeoJSON(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection, err := h.exportService.WalkedStreetsGeoJSON(r.Context(), userID, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	if r.URL.Query().Get("download") == "true" {
+		w.Header().Set("Content-Disposition", `attachment; filename="walked-streets.geojson"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// ExportSessionGPX handles GET /api/sessions/{sessionId}/gpx
+func (h *ExportHandler) ExportSessionGPX(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionId"]
+	gpx, err := h.exportService.SessionGPX(r.Context(), userID, sessionID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			middleware.ErrorResponse(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="walk-%s.gpx"`, utils.Slugify(sessionID)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(gpx)
+}
+
+func parseExportFilter(r *http.Request) (types.ExportFilter, error) {
+	query := r.URL.Query()
+	filter := types.ExportFilter{
+		SessionID: query.Get("sessionId"),
+		CityID:    query.Get("cityId"),
+	}
+
+	for _, param := range []struct {
+		name   string
+		target **int64
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTimeParam(raw, time.UTC)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %v", param.name, err)
+		}
+		millis := t.UnixMilli()
+		*param.target = &millis
+	}
+
+	if filter.From != nil && filter.To != nil && *filter.To <= *filter.From {
+		return filter, fmt.Errorf("to must be after from")
+	}
+	return filter, nil
+}
+
+// parseTimeParam reads a unix millisecond timestamp, an RFC 3339 timestamp or a date, taken as
+// midnight in loc
+func parseTimeParam(raw string, loc *time.Location) (time.Time, error) {
+	if millis, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected unix milliseconds, an RFC 3339 timestamp or YYYY-MM-DD")
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultLeaderboardLimit = 50
+	maxLeaderboardLimit     = 200
+)
+
+type LeaderboardHandler struct {
+	leaderboardService *services.LeaderboardService
+	userService        *services.UserService
+}
+
+func NewLeaderboardHandler(leaderboardService *services.LeaderboardService, userService *services.UserService) *LeaderboardHandler {
+	return &LeaderboardHandler{leaderboardService: leaderboardService, userService: userService}
+}
+
+// FriendLeaderboard handles GET /api/leaderboards/friends?metric=streets|kilometers|coverage|newStreets&period=week|month|all&cityId=
+// metric defaults to streets and period to week. Coverage defaults to the caller's current city.
+func (h *LeaderboardHandler) FriendLeaderboard(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = types.LeaderboardMetricStreets
+	}
+	period := query.Get("period")
+	if period == "" {
+		period = types.LeaderboardPeriodWeek
+	}
+	var cityID *string
+	if raw := query.Get("cityId"); raw != "" {
+		cityID = &raw
+	}
+
+	leaderboard, err := h.leaderboardService.FriendLeaderboard(r.Context(), userID, metric, period, cityID)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid leaderboard") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, leaderboard, http.StatusOK)
+}
+
+// GlobalLeaderboard handles GET /api/leaderboards/global?metric=streets|kilometers|newStreets&period=week|month|all&limit=&offset=
+func (h *LeaderboardHandler) GlobalLeaderboard(w http.ResponseWriter, r *http.Request) {
+	h.rankedLeaderboard(w, r, nil)
+}
+
+// CityLeaderboard handles GET /api/leaderboards/cities/{cityId}?metric=streets|kilometers|coverage|newStreets&period=week|month|all&limit=&offset=
+func (h *LeaderboardHandler) CityLeaderboard(w http.ResponseWriter, r *http.Request) {
+	cityID := mux.Vars(r)["cityId"]
+	h.rankedLeaderboard(w, r, &cityID)
+}
+
+// rankedLeaderboard serves a page of a ranked leaderboard with the caller's own rank. metric
+// defaults to streets and period to week.
+func (h *LeaderboardHandler) rankedLeaderboard(w http.ResponseWriter, r *http.Request, cityID *string) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = types.LeaderboardMetricStreets
+	}
+	period := query.Get("period")
+	if period == "" {
+		period = types.LeaderboardPeriodWeek
+	}
+
+	limit := defaultLeaderboardLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxLeaderboardLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			middleware.ErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	leaderboard, err := h.leaderboardService.RankedLeaderboard(r.Context(), userID, metric, period, cityID, limit, offset)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid leaderboard") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, leaderboard, http.StatusOK)
+}
+
+// RankLeaderboards handles POST /api/admin/leaderboards/rank - ranks the city and global
+// leaderboards now instead of waiting for their schedule
+func (h *LeaderboardHandler) RankLeaderboards(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, h.userService); !ok {
+		return
+	}
+
+	result, err := h.leaderboardService.RankLeaderboards(r.Context())
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, result, http.StatusOK)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/prisma/db"
+	"citystatAPI/services"
+	"citystatAPI/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultFlagsLimit = 50
+	maxFlagsLimit     = 200
+)
+
+type ModerationHandler struct {
+	moderationService *services.ModerationService
+	userService       *services.UserService
+}
+
+func NewModerationHandler(moderationService *services.ModerationService, userService *services.UserService) *ModerationHandler {
+	return &ModerationHandler{
+		moderationService: moderationService,
+		userService:       userService,
+	}
+}
+
+// ListFlags handles GET /api/moderation/flags?status=&limit=&offset= - status defaults to PENDING
+func (h *ModerationHandler) ListFlags(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := db.FlagStatusPending
+	if raw := query.Get("status"); raw != "" {
+		status = db.FlagStatus(strings.ToUpper(raw))
+		if status != db.FlagStatusPending && status != db.FlagStatusConfirmed && status != db.FlagStatusDismissed {
+			middleware.ErrorResponse(w, "status must be PENDING, CONFIRMED or DISMISSED", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultFlagsLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxFlagsLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			middleware.ErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	flags, err := h.moderationService.ListFlags(r.Context(), status, limit, offset)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, types.MovementFlagsListResponse{Flags: flags}, http.StatusOK)
+}
+
+// ResolveFlag handles POST /api/moderation/flags/{flagId}/resolve
+func (h *ModerationHandler) ResolveFlag(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.ResolveFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	flag, err := h.moderationService.ResolveFlag(r.Context(), userID, mux.Vars(r)["flagId"], req)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			middleware.ErrorResponse(w, "Flag not found", http.StatusNotFound)
+		case strings.Contains(err.Error(), "already resolved"):
+			middleware.ErrorResponse(w, err.Error(), http.StatusConflict)
+		case strings.Contains(err.Error(), "invalid action"):
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		default:
+			middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	middleware.JSONResponse(w, flag, http.StatusOK)
+}
+
+// requireModerator writes the error response itself and reports false unless the caller is a moderator or admin
+func (h *ModerationHandler) requireModerator(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return "", false
+	}
+
+	user, err := h.userService.GetOrCreateUser(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if user.Role != db.RoleAdmin && user.Role != db.RoleModerator {
+		middleware.ErrorResponse(w, "Moderator role required", http.StatusForbidden)
+		return "", false
+	}
+
+	return userID, true
+}
+
+// requireAdmin writes the error response itself and reports false unless the caller is an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request, userService *services.UserService) (string, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return "", false
+	}
+
+	user, err := userService.GetOrCreateUser(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if user.Role != db.RoleAdmin {
+		middleware.ErrorResponse(w, "Admin role required", http.StatusForbidden)
+		return "", false
+	}
+
+	return userID, true
+}
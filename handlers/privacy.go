@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+
+	"github.com/gorilla/mux"
+)
+
+type PrivacyHandler struct {
+	privacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{privacyService: privacyService}
+}
+
+// ListZones handles GET /api/privacy/zones
+func (h *PrivacyHandler) ListZones(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	zones, err := h.privacyService.ListZones(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, types.PrivacyZonesListResponse{Zones: zones}, http.StatusOK)
+}
+
+// CreateZone handles POST /api/privacy/zones - visit coordinates inside the zone are coarsened,
+// including the ones already stored
+func (h *PrivacyHandler) CreateZone(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.CreatePrivacyZoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.privacyService.CreateZone(r.Context(), userID, req)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid zone") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, created, http.StatusCreated)
+}
+
+// DeleteZone handles DELETE /api/privacy/zones/{zoneId} - visits already coarsened stay coarsened
+func (h *PrivacyHandler) DeleteZone(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.privacyService.DeleteZone(r.Context(), userID, mux.Vars(r)["zoneId"]); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			middleware.ErrorResponse(w, "Privacy zone not found", http.StatusNotFound)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, map[string]string{"message": "Privacy zone deleted successfully"}, http.StatusOK)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+)
+
+type RetentionHandler struct {
+	retentionService *services.RetentionService
+	userService      *services.UserService
+}
+
+func NewRetentionHandler(retentionService *services.RetentionService, userService *services.UserService) *RetentionHandler {
+	return &RetentionHandler{retentionService: retentionService, userService: userService}
+}
+
+// GetRetention handles GET /api/privacy/retention
+func (h *RetentionHandler) GetRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	retention, err := h.retentionService.GetRetention(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, retention, http.StatusOK)
+}
+
+// SetRetention handles PUT /api/privacy/retention - a shorter retention applies to the visits
+// already stored as well
+func (h *RetentionHandler) SetRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.RetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	retention, err := h.retentionService.SetRetention(r.Context(), userID, req.RetentionDays)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid retention") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, retention, http.StatusOK)
+}
+
+// RunRetention handles POST /api/admin/retention/run - runs the retention job now instead of
+// waiting for its schedule
+func (h *RetentionHandler) RunRetention(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, h.userService); !ok {
+		return
+	}
+
+	result, err := h.retentionService.Run(r.Context(), "")
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, result, http.StatusOK)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSessionsLimit = 20
+	maxSessionsLimit     = 100
+)
+
+type SessionHandler struct {
+	sessionService *services.SessionService
+}
+
+func NewSessionHandler(sessionService *services.SessionService) *SessionHandler {
+	return &SessionHandler{sessionService: sessionService}
+}
+
+// StartSession handles POST /api/sessions
+func (h *SessionHandler) StartSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.StartSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := h.sessionService.StartSession(r.Context(), userID, req)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, session, http.StatusCreated)
+}
+
+// StopSession handles POST /api/sessions/{sessionId}/stop
+func (h *SessionHandler) StopSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.StopSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := h.sessionService.StopSession(r.Context(), userID, mux.Vars(r)["sessionId"], req)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, session, http.StatusOK)
+}
+
+// ListSessions handles GET /api/sessions?limit=&offset=
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultSessionsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxSessionsLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			middleware.ErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	sessions, err := h.sessionService.ListSessions(r.Context(), userID, limit, offset)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, types.WalkSessionsListResponse{Sessions: sessions}, http.StatusOK)
+}
+
+// GetSession handles GET /api/sessions/{sessionId}
+func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := h.sessionService.GetSession(r.Context(), userID, mux.Vars(r)["sessionId"])
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, session, http.StatusOK)
+}
+
+// DeleteSession handles DELETE /api/sessions/{sessionId} - also removes the session's visited streets
+func (h *SessionHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.sessionService.DeleteSession(r.Context(), userID, mux.Vars(r)["sessionId"]); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	middleware.JSONResponse(w, map[string]string{"message": "Session deleted successfully"}, http.StatusOK)
+}
+
+func writeSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrConsentRequired):
+		middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrSessionNotFound):
+		middleware.ErrorResponse(w, "Session not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrSessionEndBeforeStart):
+		middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+	default:
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
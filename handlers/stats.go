@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+type StatsHandler struct {
+	statsService *services.StatsService
+	userService  *services.UserService
+}
+
+func NewStatsHandler(statsService *services.StatsService, userService *services.UserService) *StatsHandler {
+	return &StatsHandler{
+		statsService: statsService,
+		userService:  userService,
+	}
+}
+
+// GetCityStats handles GET /api/stats - the stats of the caller's current city
+func (h *StatsHandler) GetCityStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	cityStat, err := h.statsService.GetCityStat(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, cityStat, http.StatusOK)
+}
+
+// RecomputeCityStats handles POST /api/stats/recompute - rebuilds the caller's stats from their visits
+// and returns those of their current city
+func (h *StatsHandler) RecomputeCityStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.statsService.RefreshCityStats(r.Context(), userID); err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cityStat, err := h.statsService.GetCityStat(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, cityStat, http.StatusOK)
+}
+
+// ListCityStats handles GET /api/stats/cities - one stat per city the caller has walked in
+func (h *StatsHandler) ListCityStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	cityStats, err := h.statsService.ListCityStats(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, map[string]interface{}{"cities": cityStats}, http.StatusOK)
+}
+
+// RecomputeAllCityStats handles POST /api/admin/stats/recompute - rebuilds every user's stats
+func (h *StatsHandler) RecomputeAllCityStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, h.userService); !ok {
+		return
+	}
+
+	recomputed, err := h.statsService.RecomputeAll(r.Context())
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, map[string]int{"recomputedUsers": recomputed}, http.StatusOK)
+}
+
+// GetTimeseries handles GET /api/stats/timeseries?interval=day|week|month&tz=&from=&to=
+// tz is an IANA time zone name and defaults to the one in the user's profile. Without from, the last 30 days, 12 weeks or
+// 12 months up to to (default now) are returned.
+func (h *StatsHandler) GetTimeseries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = utils.IntervalDay
+	}
+	if !utils.ValidInterval(interval) {
+		middleware.ErrorResponse(w, "interval must be day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	loc, err := h.statsService.UserLocation(r.Context(), userID)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tz := query.Get("tz"); tz != "" {
+		parsed, err := time.LoadLocation(tz)
+		if err != nil {
+			middleware.ErrorResponse(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+		loc = parsed
+	}
+
+	to := time.Now()
+	if raw := query.Get("to"); raw != "" {
+		parsed, err := parseTimeParam(raw, loc)
+		if err != nil {
+			middleware.ErrorResponse(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+
+	var from time.Time
+	switch interval {
+	case utils.IntervalDay:
+		from = to.AddDate(0, 0, -29)
+	case utils.IntervalWeek:
+		from = to.AddDate(0, 0, -7*11)
+	case utils.IntervalMonth:
+		from = to.AddDate(0, -11, 0)
+	}
+	if raw := query.Get("from"); raw != "" {
+		parsed, err := parseTimeParam(raw, loc)
+		if err != nil {
+			middleware.ErrorResponse(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if to.Before(from) {
+		middleware.ErrorResponse(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.statsService.Timeseries(r.Context(), userID, interval, from, to, loc)
+	if err != nil {
+		if strings.Contains(err.Error(), "range spans") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, series, http.StatusOK)
+}
+
+// GetStreaks handles GET /api/stats/streaks - current and longest streaks in the user's time zone,
+// whether today already counts, and the streak freezes left
+func (h *StatsHandler) GetStreaks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	state, err := h.statsService.StreakState(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, state, http.StatusOK)
+}
+
+// SpendStreakFreeze handles POST /api/stats/streaks/freeze - the body's day defaults to yesterday
+func (h *StatsHandler) SpendStreakFreeze(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.SpendStreakFreezeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	state, err := h.statsService.SpendStreakFreeze(r.Context(), userID, req.Day)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrConsentRequired):
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+		case strings.Contains(err.Error(), "invalid day"):
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		case strings.Contains(err.Error(), "no streak freezes left"):
+			middleware.ErrorResponse(w, err.Error(), http.StatusConflict)
+		default:
+			middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	middleware.JSONResponse(w, state, http.StatusOK)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+)
+
+const (
+	defaultSyncPullLimit = 500
+	maxSyncPullLimit     = 2000
+	maxIdempotencyKeyLen = 128
+)
+
+type SyncHandler struct {
+	syncService *services.SyncService
+}
+
+func NewSyncHandler(syncService *services.SyncService) *SyncHandler {
+	return &SyncHandler{syncService: syncService}
+}
+
+// Push handles POST /api/sync/push - uploads a batch recorded offline, keyed by idempotencyKey
+func (h *SyncHandler) Push(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.SyncPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.IdempotencyKey == "" || len(req.IdempotencyKey) > maxIdempotencyKeyLen {
+		middleware.ErrorResponse(w, fmt.Sprintf("idempotencyKey is required and at most %d characters", maxIdempotencyKeyLen), http.StatusBadRequest)
+		return
+	}
+	if len(req.Sessions) > services.MaxSyncSessions {
+		middleware.ErrorResponse(w, fmt.Sprintf("At most %d sessions per batch", services.MaxSyncSessions), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, upload := range req.Sessions {
+		if upload.SessionID == "" {
+			middleware.ErrorResponse(w, "Session ID is required", http.StatusBadRequest)
+			return
+		}
+		if len(upload.VisitedStreets) > services.MaxVisitedStreetsBatch {
+			middleware.ErrorResponse(w, fmt.Sprintf("At most %d visited streets per session", services.MaxVisitedStreetsBatch), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	response, err := h.syncService.Push(r.Context(), userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrConsentRequired):
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, services.ErrSyncBatchInProgress):
+			middleware.ErrorResponse(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrSessionNotFound):
+			middleware.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		default:
+			middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	middleware.JSONResponse(w, response, http.StatusOK)
+}
+
+// Pull handles GET /api/sync/pull?cursor=&limit= - returns what changed after cursor and the next cursor
+func (h *SyncHandler) Pull(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultSyncPullLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxSyncPullLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 2000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	response, err := h.syncService.Pull(r.Context(), userID, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSyncCursor) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/utils"
+
+	"github.com/gorilla/mux"
+)
+
+type TileHandler struct {
+	tileService *services.TileService
+}
+
+func NewTileHandler(tileService *services.TileService) *TileHandler {
+	return &TileHandler{tileService: tileService}
+}
+
+// GetHeatmapTile handles GET /api/tiles/{z}/{x}/{y}.mvt - the caller's visit density as a Mapbox
+// Vector Tile with a "visits" point layer and a "streets" line layer. The ETag changes when the
+// caller's visits change, so clients revalidate cheaply with If-None-Match.
+func (h *TileHandler) GetHeatmapTile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	z, errZ := strconv.Atoi(vars["z"])
+	x, errX := strconv.Atoi(vars["x"])
+	y, errY := strconv.Atoi(vars["y"])
+	tile := utils.TileID{Z: z, X: x, Y: y}
+	if errZ != nil || errX != nil || errY != nil || !tile.Valid() {
+		middleware.ErrorResponse(w, "Invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	data, version, err := h.tileService.HeatmapTile(r.Context(), userID, tile)
+	if err != nil {
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + version + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
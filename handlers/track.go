@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// maxTrackFileBytes caps GPX and KML uploads
+const maxTrackFileBytes = 20 << 20
+
+type TrackHandler struct {
+	matchingService *services.MatchingService
+}
+
+func NewTrackHandler(matchingService *services.MatchingService) *TrackHandler {
+	return &TrackHandler{matchingService: matchingService}
+}
+
+// SaveTrack handles POST /api/visitor/track - raw GPS points are matched to streets on the server
+func (h *TrackHandler) SaveTrack(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req types.SaveTrackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID == "" {
+		middleware.ErrorResponse(w, "Session ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Points) > services.MaxTrackPoints {
+		middleware.ErrorResponse(w, fmt.Sprintf("At most %d points per request", services.MaxTrackPoints), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	response, err := h.matchingService.SaveTrack(r.Context(), userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrConsentRequired):
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, services.ErrStreetGraphNotLoaded):
+			middleware.ErrorResponse(w, "Street matching is not available", http.StatusServiceUnavailable)
+		case errors.Is(err, services.ErrSessionNotFound):
+			middleware.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		default:
+			middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	middleware.JSONResponse(w, response, http.StatusOK)
+}
+
+// ImportTrackFile handles POST /api/visitor/import?format=gpx|kml - the file is sent either as the
+// "file" field of a multipart form or as the raw request body. Without format it is detected from
+// the file name or contents.
+func (h *TrackHandler) ImportTrackFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackFileBytes)
+	data, filename, err := readTrackFile(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			middleware.ErrorResponse(w, fmt.Sprintf("Track files are limited to %d MB", maxTrackFileBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		middleware.ErrorResponse(w, "Invalid track file upload", http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = detectTrackFormat(filename, data)
+	}
+	if format != services.TrackFormatGPX && format != services.TrackFormatKML {
+		middleware.ErrorResponse(w, "Unsupported track format, expected gpx or kml", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.matchingService.ImportTrackFile(r.Context(), userID, format, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrConsentRequired):
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, services.ErrStreetGraphNotLoaded):
+			middleware.ErrorResponse(w, "Street matching is not available", http.StatusServiceUnavailable)
+		case errors.Is(err, services.ErrSessionNotFound):
+			middleware.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, utils.ErrNoTimestamps), errors.Is(err, utils.ErrInvalidTrackFile),
+			errors.Is(err, services.ErrEmptyTrack), errors.Is(err, services.ErrTrackTooLong):
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		default:
+			middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	middleware.JSONResponse(w, response, http.StatusOK)
+}
+
+func readTrackFile(r *http.Request) ([]byte, string, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err := io.ReadAll(r.Body)
+		return data, "", err
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	return data, header.Filename, err
+}
+
+func detectTrackFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return services.TrackFormatGPX
+	case ".kml":
+		return services.TrackFormatKML
+	}
+
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	switch {
+	case bytes.Contains(head, []byte("<gpx")):
+		return services.TrackFormatGPX
+	case bytes.Contains(head, []byte("<kml")):
+		return services.TrackFormatKML
+	}
+	return ""
+}
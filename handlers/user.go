@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"citystatAPI/middleware"
+	"citystatAPI/prisma/db"
 	"citystatAPI/services"
 	"citystatAPI/types"
 	"citystatAPI/utils"
@@ -14,11 +15,20 @@ import (
 
 type UserHandler struct {
 	userService *services.UserService
+	xpService   *services.XpService
 }
 
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(userService *services.UserService, xpService *services.XpService) *UserHandler {
+	return &UserHandler{userService: userService, xpService: xpService}
+}
+
+// profileResponse is the user with their level
+type profileResponse struct {
+	*db.UserModel
+	Level types.LevelResult `json:"level"`
+	// RewardsEnabled is false when the user turned off in-app rewards; no XP is awarded then
+	RewardsEnabled bool `json:"rewardsEnabled"`
 }
 
 func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
@@ -34,7 +44,13 @@ func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	middleware.JSONResponse(w, user, http.StatusOK)
+	level, rewardsEnabled, err := h.xpService.OwnLevel(r.Context(), user)
+	if err != nil {
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, profileResponse{UserModel: user, Level: level, RewardsEnabled: rewardsEnabled}, http.StatusOK)
 }
 
 func (h *UserHandler) UpdateUserDetails(w http.ResponseWriter, r *http.Request) {
@@ -55,6 +71,10 @@ func (h *UserHandler) UpdateUserDetails(w http.ResponseWriter, r *http.Request)
 	// Update user with the provided data
 	user, err := h.userService.UpdateUserDetails(r.Context(), userID, updateReq)
 	if err != nil {
+		if strings.Contains(err.Error(), "invalid time zone") {
+			middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -179,6 +199,10 @@ func (h *UserHandler) UpdateUserSettings(w http.ResponseWriter, r *http.Request)
 
     user, err := h.userService.UpdateUserSettings(r.Context(), userID, settingsReq)
     if err != nil {
+        if strings.Contains(err.Error(), "invalid profileVisibility") {
+            middleware.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+            return
+        }
         middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
         return
     }
@@ -5,6 +5,8 @@ import (
 	"citystatAPI/services"
 	"citystatAPI/types"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 )
 
@@ -77,12 +79,28 @@ func (h *VisitorHandler) SaveVisitedStreets(w http.ResponseWriter, r *http.Reque
         return
     }
 
-    
-    err := h.visitorService.SaveVisitedStreets(r.Context(), userID, req)
+	if req.SessionID == "" {
+		middleware.ErrorResponse(w, "Session ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.VisitedStreets) > services.MaxVisitedStreetsBatch {
+		middleware.ErrorResponse(w, fmt.Sprintf("At most %d visited streets per request", services.MaxVisitedStreetsBatch), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+    response, err := h.visitorService.SaveVisitedStreets(r.Context(), userID, req)
     if err != nil {
+		if errors.Is(err, services.ErrConsentRequired) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, services.ErrSessionNotFound) {
+			middleware.ErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
         middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
-    middleware.JSONResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+    middleware.JSONResponse(w, response, http.StatusOK)
 }
\ No newline at end of file
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"citystatAPI/middleware"
+	"citystatAPI/services"
+)
+
+const (
+	defaultXpHistoryLimit = 50
+	maxXpHistoryLimit     = 200
+)
+
+type XpHandler struct {
+	xpService *services.XpService
+}
+
+func NewXpHandler(xpService *services.XpService) *XpHandler {
+	return &XpHandler{xpService: xpService}
+}
+
+// GetXp handles GET /api/user/xp?limit=&offset= - the caller's level and XP history, newest first
+func (h *XpHandler) GetXp(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.ErrorResponse(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultXpHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxXpHistoryLimit {
+			middleware.ErrorResponse(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			middleware.ErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	xp, err := h.xpService.Xp(r.Context(), userID, limit, offset)
+	if err != nil {
+		if strings.Contains(err.Error(), "user not found") {
+			middleware.ErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		middleware.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	middleware.JSONResponse(w, xp, http.StatusOK)
+}
@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
+	_ "time/tzdata" // time zone names must resolve on images without a zone database
 
 	appHandlers "citystatAPI/handlers"
 	appMiddleware "citystatAPI/middleware"
 	"citystatAPI/prisma/db"
 	"citystatAPI/services"
+	"citystatAPI/utils"
 	"github.com/clerk/clerk-sdk-go/v2"
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/gorilla/handlers"
@@ -21,11 +24,27 @@ import (
 )
 
 var (
-	client          *db.PrismaClient
-	userService     *services.UserService
-	settingsService *services.SettingsService
-	friendService   *services.FriendService
-	visitorService  *services.VisitorService
+	client             *db.PrismaClient
+	userService        *services.UserService
+	settingsService    *services.SettingsService
+	friendService      *services.FriendService
+	visitorService     *services.VisitorService
+	statsService       *services.StatsService
+	sessionService     *services.SessionService
+	matchingService    *services.MatchingService
+	streetService      *services.StreetService
+	exportService      *services.ExportService
+	moderationService  *services.ModerationService
+	syncService        *services.SyncService
+	tileService        *services.TileService
+	districtService    *services.DistrictService
+	privacyService     *services.PrivacyService
+	consentService     *services.ConsentService
+	retentionService   *services.RetentionService
+	achievementService *services.AchievementService
+	leaderboardService *services.LeaderboardService
+	challengeService   *services.ChallengeService
+	xpService          *services.XpService
 )
 
 func init() {
@@ -48,11 +67,81 @@ func init() {
 
 	userService = services.NewUserService(client)
 	settingsService = services.NewSettingsService(client)
-	friendService = services.NewFriendService(client)
-	visitorService = services.NewVisitorService(client)
+	consentService = services.NewConsentService(client)
+	achievementService = services.NewAchievementService(client, consentService)
+	// an invalid XP_LEVEL_THRESHOLDS stops the server rather than storing levels from other thresholds
+	levelPolicy, err := services.LevelPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Using %d XP level thresholds", len(levelPolicy.Thresholds))
+	xpService = services.NewXpService(client, levelPolicy)
+	friendService = services.NewFriendService(client, achievementService, xpService)
+	statsService = services.NewStatsService(client, consentService, achievementService, xpService)
+	sessionService = services.NewSessionService(client, statsService, consentService)
+	streetService = services.NewStreetService(client)
+	privacyService = services.NewPrivacyService(client, statsService, sessionService)
+	visitorService = services.NewVisitorService(client, statsService, sessionService, streetService, privacyService, consentService)
+	matchingService = services.NewMatchingService(visitorService, sessionService)
+	exportService = services.NewExportService(client, consentService)
+	moderationService = services.NewModerationService(client, statsService, sessionService)
+	syncService = services.NewSyncService(client, visitorService, sessionService, statsService, settingsService, consentService)
+	tileService = services.NewTileService(client, consentService)
+	districtService = services.NewDistrictService(client, consentService)
+	leaderboardService = services.NewLeaderboardService(client)
+	challengeService = services.NewChallengeService(client, consentService)
+	retentionService = services.NewRetentionService(client, statsService, retentionPolicyFromEnv())
+
+	// The street graph comes from STREET_GRAPH_PATH when set, otherwise from the imported streets
+	if graphPath := os.Getenv("STREET_GRAPH_PATH"); graphPath != "" {
+		streets, err := matchingService.LoadGraphFile(graphPath)
+		if err != nil {
+			log.Printf("Street matching disabled: %v", err)
+		} else {
+			log.Printf("Loaded street graph with %d streets", streets)
+		}
+	} else {
+		graph, err := streetService.LoadGraph(context.Background())
+		if err != nil {
+			log.Printf("Street matching disabled: %v", err)
+		} else if graph.Len() > 0 {
+			matchingService.SetGraph(graph)
+			log.Printf("Loaded street graph with %d streets", graph.Len())
+		}
+	}
+
+	// City boundaries route visits off the imported streets to a city
+	if cities, err := streetService.LoadBoundaries(context.Background()); err != nil {
+		log.Printf("City boundaries not loaded: %v", err)
+	} else if cities > 0 {
+		log.Printf("Loaded boundaries of %d cities", cities)
+	}
 
 }
 
+// retentionPolicyFromEnv reads RETENTION_COARSEN_DAYS and RETENTION_PURGE_DAYS, falling back to
+// the defaults for unset or invalid values
+func retentionPolicyFromEnv() services.RetentionPolicy {
+	policy := services.RetentionPolicy{CoarsenDays: services.DefaultCoarsenDays, PurgeDays: services.DefaultPurgeDays}
+	for name, days := range map[string]*int{"RETENTION_COARSEN_DAYS": &policy.CoarsenDays, "RETENTION_PURGE_DAYS": &policy.PurgeDays} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Printf("Ignoring invalid %s %q", name, value)
+			continue
+		}
+		*days = parsed
+	}
+	if policy.CoarsenDays > policy.PurgeDays {
+		policy.CoarsenDays = policy.PurgeDays
+	}
+	log.Printf("Coarsening visit coordinates after %d days and purging them after %d days", policy.CoarsenDays, policy.PurgeDays)
+	return policy
+}
+
 func main() {
 	defer func() {
 		if err := client.Prisma.Disconnect(); err != nil {
@@ -62,9 +151,24 @@ func main() {
 
 	tempLogger := hclog.Default()
 
-	userHandler := appHandlers.NewUserHandler(userService)
+	userHandler := appHandlers.NewUserHandler(userService, xpService)
 	settingsHandler := appHandlers.NewSettingsHandler(settingsService)
 	visitorHandler := appHandlers.NewVisitorHandler(visitorService)
+	statsHandler := appHandlers.NewStatsHandler(statsService, userService)
+	sessionHandler := appHandlers.NewSessionHandler(sessionService)
+	trackHandler := appHandlers.NewTrackHandler(matchingService)
+	exportHandler := appHandlers.NewExportHandler(exportService)
+	moderationHandler := appHandlers.NewModerationHandler(moderationService, userService)
+	syncHandler := appHandlers.NewSyncHandler(syncService)
+	tileHandler := appHandlers.NewTileHandler(tileService)
+	districtHandler := appHandlers.NewDistrictHandler(districtService)
+	privacyHandler := appHandlers.NewPrivacyHandler(privacyService)
+	consentHandler := appHandlers.NewConsentHandler(consentService)
+	retentionHandler := appHandlers.NewRetentionHandler(retentionService, userService)
+	achievementHandler := appHandlers.NewAchievementHandler(achievementService)
+	xpHandler := appHandlers.NewXpHandler(xpService)
+	leaderboardHandler := appHandlers.NewLeaderboardHandler(leaderboardService, userService)
+	challengeHandler := appHandlers.NewChallengeHandler(challengeService)
 	friendHandler := appHandlers.NewFriendHandler(friendService)
 	inviteHandler := appHandlers.NewInviteHandler(userService, friendService)
 	uploadHandler := appHandlers.NewUploadHandler()
@@ -89,6 +193,8 @@ func main() {
 	protected.HandleFunc("/user/profile", userHandler.EditProfile).Methods("PUT")
 	protected.HandleFunc("/user/note", userHandler.EditNote).Methods("PUT")
 	protected.HandleFunc("/users/search", userHandler.SearchUsers).Methods("GET")
+	protected.HandleFunc("/user/achievements", achievementHandler.ListAchievements).Methods("GET")
+	protected.HandleFunc("/user/xp", xpHandler.GetXp).Methods("GET")
 
 	// Friend routes
 	protected.HandleFunc("/friends/profile", friendHandler.GetFriendProfile).Methods("POST")
@@ -96,6 +202,19 @@ func main() {
 	protected.HandleFunc("/friends/list", friendHandler.GetFriends).Methods("GET")
 	protected.HandleFunc("/friends/{friendId}", friendHandler.RemoveFriend).Methods("DELETE")
 
+	// Leaderboard routes
+	protected.HandleFunc("/leaderboards/friends", leaderboardHandler.FriendLeaderboard).Methods("GET")
+	protected.HandleFunc("/leaderboards/global", leaderboardHandler.GlobalLeaderboard).Methods("GET")
+	protected.HandleFunc("/leaderboards/cities/{cityId}", leaderboardHandler.CityLeaderboard).Methods("GET")
+
+	// Challenge routes
+	protected.HandleFunc("/challenges", challengeHandler.CreateChallenge).Methods("POST")
+	protected.HandleFunc("/challenges", challengeHandler.ListChallenges).Methods("GET")
+	protected.HandleFunc("/challenges/{challengeId}", challengeHandler.GetChallenge).Methods("GET")
+	protected.HandleFunc("/challenges/{challengeId}/invite", challengeHandler.InviteToChallenge).Methods("POST")
+	protected.HandleFunc("/challenges/{challengeId}/join", challengeHandler.JoinChallenge).Methods("POST")
+	protected.HandleFunc("/challenges/{challengeId}/decline", challengeHandler.DeclineChallenge).Methods("POST")
+
 	// Invite routes
 	protected.HandleFunc("/invite/accept", inviteHandler.AcceptInvite).Methods("POST")
 	protected.HandleFunc("/invite/link", inviteHandler.GetInviteLink).Methods("GET")
@@ -111,6 +230,53 @@ func main() {
 	protected.HandleFunc("/visitor/locationPermission", visitorHandler.GetLocationPermission).Methods("GET")
 	protected.HandleFunc("/visitor/locationPermission", visitorHandler.SaveLocationPermission).Methods("POST")
 	protected.HandleFunc("/visitor/streets", visitorHandler.SaveVisitedStreets).Methods("POST")
+	protected.HandleFunc("/visitor/track", trackHandler.SaveTrack).Methods("POST")
+	protected.HandleFunc("/visitor/import", trackHandler.ImportTrackFile).Methods("POST")
+
+	// Stats routes
+	protected.HandleFunc("/stats", statsHandler.GetCityStats).Methods("GET")
+	protected.HandleFunc("/stats/recompute", statsHandler.RecomputeCityStats).Methods("POST")
+	protected.HandleFunc("/stats/timeseries", statsHandler.GetTimeseries).Methods("GET")
+	protected.HandleFunc("/stats/cities", statsHandler.ListCityStats).Methods("GET")
+	protected.HandleFunc("/stats/districts", districtHandler.ListDistricts).Methods("GET")
+	protected.HandleFunc("/stats/streaks", statsHandler.GetStreaks).Methods("GET")
+	protected.HandleFunc("/stats/streaks/freeze", statsHandler.SpendStreakFreeze).Methods("POST")
+	protected.HandleFunc("/admin/stats/recompute", statsHandler.RecomputeAllCityStats).Methods("POST")
+
+	// Walk session routes
+	protected.HandleFunc("/sessions", sessionHandler.StartSession).Methods("POST")
+	protected.HandleFunc("/sessions", sessionHandler.ListSessions).Methods("GET")
+	protected.HandleFunc("/sessions/{sessionId}", sessionHandler.GetSession).Methods("GET")
+	protected.HandleFunc("/sessions/{sessionId}", sessionHandler.DeleteSession).Methods("DELETE")
+	protected.HandleFunc("/sessions/{sessionId}/stop", sessionHandler.StopSession).Methods("POST")
+
+	// Export routes
+	protected.HandleFunc("/export/geojson", exportHandler.ExportGeoJSON).Methods("GET")
+	protected.HandleFunc("/sessions/{sessionId}/gpx", exportHandler.ExportSessionGPX).Methods("GET")
+
+	// Heatmap tile routes
+	protected.HandleFunc("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", tileHandler.GetHeatmapTile).Methods("GET")
+
+	// Privacy zone routes
+	protected.HandleFunc("/privacy/zones", privacyHandler.ListZones).Methods("GET")
+	protected.HandleFunc("/privacy/zones", privacyHandler.CreateZone).Methods("POST")
+	protected.HandleFunc("/privacy/zones/{zoneId}", privacyHandler.DeleteZone).Methods("DELETE")
+	protected.HandleFunc("/privacy/retention", retentionHandler.GetRetention).Methods("GET")
+	protected.HandleFunc("/privacy/retention", retentionHandler.SetRetention).Methods("PUT")
+	protected.HandleFunc("/admin/retention/run", retentionHandler.RunRetention).Methods("POST")
+	protected.HandleFunc("/admin/leaderboards/rank", leaderboardHandler.RankLeaderboards).Methods("POST")
+
+	// Consent routes
+	protected.HandleFunc("/consent", consentHandler.GetConsents).Methods("GET")
+	protected.HandleFunc("/consent/ledger", consentHandler.GetLedger).Methods("GET")
+
+	// Moderation routes
+	protected.HandleFunc("/moderation/flags", moderationHandler.ListFlags).Methods("GET")
+	protected.HandleFunc("/moderation/flags/{flagId}/resolve", moderationHandler.ResolveFlag).Methods("POST")
+
+	// Offline sync routes
+	protected.HandleFunc("/sync/push", syncHandler.Push).Methods("POST")
+	protected.HandleFunc("/sync/pull", syncHandler.Pull).Methods("GET")
 
 	// Add UploadThing routes
 	protected.PathPrefix("/uploadthing").HandlerFunc(uploadHandler.UploadThingProxy)
@@ -147,6 +313,30 @@ func main() {
 		IdleTimeout:  120 * time.Second,                                         // max time for connections using TCP Keep-Alive
 	}
 
+	// precise coordinates are coarsened and purged in the background while the server runs
+	retentionContext, stopRetention := context.WithCancel(context.Background())
+	go retentionService.RunEvery(retentionContext, services.RetentionInterval)
+
+	// city and global leaderboards are ranked into a table rather than on every request
+	rankingContext, stopRanking := context.WithCancel(context.Background())
+	go leaderboardService.RunEvery(rankingContext, services.RankingInterval)
+
+	// results of ended challenges are frozen so visits synced later do not change them
+	challengeContext, stopChallenges := context.WithCancel(context.Background())
+	go challengeService.RunEvery(challengeContext, services.ChallengeFinalizeInterval)
+
+	// streets and boundaries imported by cmd/importstreets are picked up without a restart; a graph
+	// from STREET_GRAPH_PATH is kept as it is
+	var onGraph func(*utils.StreetGraph)
+	if os.Getenv("STREET_GRAPH_PATH") == "" {
+		onGraph = func(graph *utils.StreetGraph) {
+			matchingService.SetGraph(graph)
+			log.Printf("Reloaded street graph with %d streets", graph.Len())
+		}
+	}
+	networkContext, stopNetwork := context.WithCancel(context.Background())
+	go streetService.RunEvery(networkContext, services.NetworkReloadInterval, onGraph)
+
 	go func() {
 		tempLogger.Info("Starting server on port ")
 		tempLogger.Info(port)
@@ -164,6 +354,10 @@ func main() {
 
 	sig := <-sigChan
 	log.Println("Got signal:", sig)
+	stopRetention()
+	stopRanking()
+	stopChallenges()
+	stopNetwork()
 
 	timeoutContext, _ := context.WithTimeout(context.Background(), 30*time.Second)
 
@@ -0,0 +1,17 @@
+/*
+  Warnings:
+
+  - Duplicate rows on (`user_id`, `session_id`, `street_id`, `entry_timestamp`) are removed, keeping the earliest one.
+
+*/
+-- Deduplicate
+DELETE FROM "visited_streets" a
+USING "visited_streets" b
+WHERE a."user_id" = b."user_id"
+  AND a."session_id" = b."session_id"
+  AND a."street_id" = b."street_id"
+  AND a."entry_timestamp" = b."entry_timestamp"
+  AND (a."created_at", a."id") > (b."created_at", b."id");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "visited_streets_user_id_session_id_street_id_entry_timestamp_key" ON "visited_streets"("user_id", "session_id", "street_id", "entry_timestamp");
@@ -0,0 +1,49 @@
+/*
+  Warnings:
+
+  - Existing `session_id` values are backfilled as completed walk sessions. A session id that was reused by several users is attributed to one of them.
+
+*/
+-- CreateEnum
+CREATE TYPE "SessionStatus" AS ENUM ('ACTIVE', 'COMPLETED');
+
+-- CreateTable
+CREATE TABLE "walk_sessions" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "status" "SessionStatus" NOT NULL DEFAULT 'ACTIVE',
+    "device" TEXT,
+    "started_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "ended_at" TIMESTAMP(3),
+    "duration_seconds" INTEGER NOT NULL DEFAULT 0,
+    "distance_km" DOUBLE PRECISION NOT NULL DEFAULT 0,
+    "streets_visited" INTEGER NOT NULL DEFAULT 0,
+    "new_streets" INTEGER NOT NULL DEFAULT 0,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP(3) NOT NULL,
+
+    CONSTRAINT "walk_sessions_pkey" PRIMARY KEY ("id")
+);
+
+-- Backfill one completed session per free-form session id already in use
+INSERT INTO "walk_sessions" ("id", "user_id", "status", "started_at", "ended_at", "duration_seconds", "streets_visited", "updated_at")
+SELECT
+    "session_id",
+    MIN("user_id"),
+    'COMPLETED',
+    to_timestamp(MIN("entry_timestamp") / 1000.0),
+    to_timestamp(MAX(COALESCE("exit_timestamp", "entry_timestamp")) / 1000.0),
+    ((MAX(COALESCE("exit_timestamp", "entry_timestamp")) - MIN("entry_timestamp")) / 1000)::INTEGER,
+    COUNT(DISTINCT "street_id"),
+    CURRENT_TIMESTAMP
+FROM "visited_streets"
+GROUP BY "session_id";
+
+-- CreateIndex
+CREATE INDEX "walk_sessions_user_id_started_at_idx" ON "walk_sessions"("user_id", "started_at");
+
+-- AddForeignKey
+ALTER TABLE "walk_sessions" ADD CONSTRAINT "walk_sessions_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "visited_streets" ADD CONSTRAINT "visited_streets_session_id_fkey" FOREIGN KEY ("session_id") REFERENCES "walk_sessions"("id") ON DELETE CASCADE ON UPDATE CASCADE;
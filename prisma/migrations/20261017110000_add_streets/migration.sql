@@ -0,0 +1,56 @@
+-- CreateTable
+CREATE TABLE "cities" (
+    "id" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "state" TEXT NOT NULL DEFAULT '',
+    "country" TEXT NOT NULL,
+    "street_count" INTEGER NOT NULL DEFAULT 0,
+    "total_length_meters" DOUBLE PRECISION NOT NULL DEFAULT 0,
+    "imported_at" TIMESTAMP(3),
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP(3) NOT NULL,
+
+    CONSTRAINT "cities_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "streets" (
+    "id" TEXT NOT NULL,
+    "city_id" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "length_meters" DOUBLE PRECISION NOT NULL,
+    "geometry" JSONB NOT NULL,
+    "min_lat" DOUBLE PRECISION NOT NULL,
+    "min_lng" DOUBLE PRECISION NOT NULL,
+    "max_lat" DOUBLE PRECISION NOT NULL,
+    "max_lng" DOUBLE PRECISION NOT NULL,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "streets_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "street_segments" (
+    "id" TEXT NOT NULL,
+    "street_id" TEXT NOT NULL,
+    "position" INTEGER NOT NULL,
+    "length_meters" DOUBLE PRECISION NOT NULL,
+    "geometry" JSONB NOT NULL,
+
+    CONSTRAINT "street_segments_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "cities_name_state_country_key" ON "cities"("name", "state", "country");
+
+-- CreateIndex
+CREATE INDEX "streets_city_id_idx" ON "streets"("city_id");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "street_segments_street_id_position_key" ON "street_segments"("street_id", "position");
+
+-- AddForeignKey
+ALTER TABLE "streets" ADD CONSTRAINT "streets_city_id_fkey" FOREIGN KEY ("city_id") REFERENCES "cities"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "street_segments" ADD CONSTRAINT "street_segments_street_id_fkey" FOREIGN KEY ("street_id") REFERENCES "streets"("id") ON DELETE CASCADE ON UPDATE CASCADE;
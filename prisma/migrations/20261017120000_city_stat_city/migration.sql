@@ -0,0 +1,5 @@
+-- AlterTable
+ALTER TABLE "city_stats" ADD COLUMN "cityId" TEXT;
+
+-- AddForeignKey
+ALTER TABLE "city_stats" ADD CONSTRAINT "city_stats_cityId_fkey" FOREIGN KEY ("cityId") REFERENCES "cities"("id") ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1,5 @@
+-- AlterTable
+ALTER TABLE "street_walks" ADD COLUMN "streetId" TEXT;
+
+-- CreateIndex
+CREATE UNIQUE INDEX "street_walks_cityStatId_streetId_key" ON "street_walks"("cityStatId", "streetId");
@@ -0,0 +1,32 @@
+-- CreateEnum
+CREATE TYPE "FlagStatus" AS ENUM ('PENDING', 'CONFIRMED', 'DISMISSED');
+
+-- AlterTable
+ALTER TABLE "visited_streets" ADD COLUMN "flagged" BOOLEAN NOT NULL DEFAULT false;
+
+-- CreateTable
+CREATE TABLE "movement_flags" (
+    "id" TEXT NOT NULL,
+    "visited_street_id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "session_id" TEXT NOT NULL,
+    "reason" TEXT NOT NULL,
+    "speed_mps" DOUBLE PRECISION,
+    "distance_meters" DOUBLE PRECISION,
+    "status" "FlagStatus" NOT NULL DEFAULT 'PENDING',
+    "reviewed_by" TEXT,
+    "reviewed_at" TIMESTAMP(3),
+    "review_note" TEXT,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "movement_flags_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "movement_flags_status_created_at_idx" ON "movement_flags"("status", "created_at");
+
+-- CreateIndex
+CREATE INDEX "movement_flags_user_id_idx" ON "movement_flags"("user_id");
+
+-- AddForeignKey
+ALTER TABLE "movement_flags" ADD CONSTRAINT "movement_flags_visited_street_id_fkey" FOREIGN KEY ("visited_street_id") REFERENCES "visited_streets"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,79 @@
+-- CreateTable
+CREATE TABLE "sync_changes" (
+    "id" BIGSERIAL NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "entity" TEXT NOT NULL,
+    "entity_id" TEXT NOT NULL,
+    "deleted" BOOLEAN NOT NULL DEFAULT false,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "sync_changes_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "sync_batches" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "idempotency_key" TEXT NOT NULL,
+    "device_id" TEXT,
+    "response" JSONB,
+    "completed_at" TIMESTAMP(3),
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "sync_batches_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "sync_changes_user_id_id_idx" ON "sync_changes"("user_id", "id");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "sync_batches_user_id_idempotency_key_key" ON "sync_batches"("user_id", "idempotency_key");
+
+-- Change log trigger. TG_ARGV[0] is the entity name, TG_ARGV[1] the column holding the owning user.
+-- The advisory lock serialises a user's writing transactions, so a user's change ids become
+-- visible in increasing order and a reader never skips a change committed behind its cursor.
+CREATE FUNCTION record_sync_change() RETURNS trigger AS $$
+DECLARE
+    row_data JSONB;
+    owner_id TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_data := to_jsonb(OLD);
+    ELSE
+        row_data := to_jsonb(NEW);
+    END IF;
+    owner_id := row_data ->> TG_ARGV[1];
+
+    PERFORM pg_advisory_xact_lock(hashtext('sync:' || owner_id));
+    INSERT INTO "sync_changes" ("user_id", "entity", "entity_id", "deleted")
+    VALUES (owner_id, TG_ARGV[0], row_data ->> 'id', TG_OP = 'DELETE');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "visited_streets_sync" AFTER INSERT OR UPDATE OR DELETE ON "visited_streets"
+    FOR EACH ROW EXECUTE FUNCTION record_sync_change('visit', 'user_id');
+
+CREATE TRIGGER "walk_sessions_sync" AFTER INSERT OR UPDATE OR DELETE ON "walk_sessions"
+    FOR EACH ROW EXECUTE FUNCTION record_sync_change('session', 'user_id');
+
+CREATE TRIGGER "city_stats_sync" AFTER INSERT OR UPDATE OR DELETE ON "city_stats"
+    FOR EACH ROW EXECUTE FUNCTION record_sync_change('stats', 'userId');
+
+CREATE TRIGGER "settings_sync" AFTER INSERT OR UPDATE OR DELETE ON "settings"
+    FOR EACH ROW EXECUTE FUNCTION record_sync_change('settings', 'userId');
+
+CREATE TRIGGER "user_friends_sync" AFTER INSERT OR UPDATE OR DELETE ON "user_friends"
+    FOR EACH ROW EXECUTE FUNCTION record_sync_change('friend', 'user_id');
+
+-- Backfill so that pulling from the start returns everything that already exists
+INSERT INTO "sync_changes" ("user_id", "entity", "entity_id")
+SELECT "user_id", 'session', "id" FROM "walk_sessions"
+UNION ALL
+SELECT "user_id", 'visit', "id" FROM "visited_streets"
+UNION ALL
+SELECT "userId", 'stats', "id" FROM "city_stats"
+UNION ALL
+SELECT "userId", 'settings', "id" FROM "settings"
+UNION ALL
+SELECT "user_id", 'friend', "id" FROM "user_friends";
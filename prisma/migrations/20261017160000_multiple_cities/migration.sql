@@ -0,0 +1,30 @@
+-- DropIndex
+DROP INDEX "city_stats_userId_key";
+
+-- AlterTable
+ALTER TABLE "cities" ADD COLUMN "boundary" JSONB;
+
+-- AlterTable
+ALTER TABLE "users" ADD COLUMN "currentCityId" TEXT;
+
+-- AlterTable
+ALTER TABLE "visited_streets" ADD COLUMN "city_id" TEXT;
+
+-- CreateIndex
+CREATE UNIQUE INDEX "city_stats_userId_cityId_key" ON "city_stats"("userId", "cityId");
+
+-- The compound key treats NULL city ids as distinct, so the stat outside every city gets its own index
+CREATE UNIQUE INDEX "city_stats_userId_no_city_key" ON "city_stats"("userId") WHERE "cityId" IS NULL;
+
+-- CreateIndex
+CREATE INDEX "visited_streets_user_id_city_id_idx" ON "visited_streets"("user_id", "city_id");
+
+-- AddForeignKey
+ALTER TABLE "users" ADD CONSTRAINT "users_currentCityId_fkey" FOREIGN KEY ("currentCityId") REFERENCES "cities"("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "visited_streets" ADD CONSTRAINT "visited_streets_city_id_fkey" FOREIGN KEY ("city_id") REFERENCES "cities"("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- Route existing visits on imported streets. Existing stats still cover all of a user's visits
+-- until they are rebuilt with POST /api/admin/stats/recompute.
+UPDATE "visited_streets" v SET "city_id" = st."city_id" FROM "streets" st WHERE st."id" = v."street_id";
@@ -0,0 +1,42 @@
+-- CreateTable
+CREATE TABLE "districts" (
+    "id" TEXT NOT NULL,
+    "city_id" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "level" TEXT NOT NULL DEFAULT 'district',
+    "boundary" JSONB NOT NULL,
+    "street_count" INTEGER NOT NULL DEFAULT 0,
+    "total_length_meters" DOUBLE PRECISION NOT NULL DEFAULT 0,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP(3) NOT NULL,
+
+    CONSTRAINT "districts_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "district_streets" (
+    "id" TEXT NOT NULL,
+    "district_id" TEXT NOT NULL,
+    "street_id" TEXT NOT NULL,
+    "length_meters" DOUBLE PRECISION NOT NULL,
+
+    CONSTRAINT "district_streets_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "districts_city_id_level_name_key" ON "districts"("city_id", "level", "name");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "district_streets_district_id_street_id_key" ON "district_streets"("district_id", "street_id");
+
+-- CreateIndex
+CREATE INDEX "district_streets_street_id_idx" ON "district_streets"("street_id");
+
+-- AddForeignKey
+ALTER TABLE "districts" ADD CONSTRAINT "districts_city_id_fkey" FOREIGN KEY ("city_id") REFERENCES "cities"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "district_streets" ADD CONSTRAINT "district_streets_district_id_fkey" FOREIGN KEY ("district_id") REFERENCES "districts"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "district_streets" ADD CONSTRAINT "district_streets_street_id_fkey" FOREIGN KEY ("street_id") REFERENCES "streets"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,22 @@
+-- AlterTable
+ALTER TABLE "visited_streets" ADD COLUMN "redacted" BOOLEAN NOT NULL DEFAULT false;
+
+-- CreateTable
+CREATE TABLE "privacy_zones" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "name" TEXT NOT NULL DEFAULT '',
+    "center_lat" DOUBLE PRECISION,
+    "center_lng" DOUBLE PRECISION,
+    "radius_meters" DOUBLE PRECISION,
+    "polygon" JSONB,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "privacy_zones_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "privacy_zones_user_id_idx" ON "privacy_zones"("user_id");
+
+-- AddForeignKey
+ALTER TABLE "privacy_zones" ADD CONSTRAINT "privacy_zones_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,59 @@
+-- CreateTable
+CREATE TABLE "consent_events" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "purpose" TEXT NOT NULL,
+    "granted" BOOLEAN NOT NULL,
+    "source" TEXT NOT NULL,
+    "recorded_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "consent_events_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "consent_events_user_id_recorded_at_idx" ON "consent_events"("user_id", "recorded_at");
+
+-- AddForeignKey
+ALTER TABLE "consent_events" ADD CONSTRAINT "consent_events_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Consent ledger trigger. Every path that writes settings is covered, so the ledger cannot miss a
+-- change made outside the API either. Rows are never updated or deleted by the application.
+CREATE FUNCTION record_consent_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO "consent_events" ("id", "user_id", "purpose", "granted", "source")
+        SELECT gen_random_uuid()::text, NEW."userId", c.purpose, c.granted, 'initial'
+        FROM (VALUES
+            ('locationTracking', NEW."enabledLocationTracking"),
+            ('cityStatDataUsage', NEW."allowCityStatDataUsage"),
+            ('personalization', NEW."allowDataPersonalizationUsage"),
+            ('analytics', NEW."allowDataAnaliticsAndPerformance")
+        ) AS c(purpose, granted);
+    ELSE
+        INSERT INTO "consent_events" ("id", "user_id", "purpose", "granted", "source")
+        SELECT gen_random_uuid()::text, NEW."userId", c.purpose, c.granted, 'update'
+        FROM (VALUES
+            ('locationTracking', NEW."enabledLocationTracking", OLD."enabledLocationTracking"),
+            ('cityStatDataUsage', NEW."allowCityStatDataUsage", OLD."allowCityStatDataUsage"),
+            ('personalization', NEW."allowDataPersonalizationUsage", OLD."allowDataPersonalizationUsage"),
+            ('analytics', NEW."allowDataAnaliticsAndPerformance", OLD."allowDataAnaliticsAndPerformance")
+        ) AS c(purpose, granted, previous)
+        WHERE c.granted IS DISTINCT FROM c.previous;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "settings_consent" AFTER INSERT OR UPDATE ON "settings"
+    FOR EACH ROW EXECUTE FUNCTION record_consent_change();
+
+-- Backfill the consent in force at rollout
+INSERT INTO "consent_events" ("id", "user_id", "purpose", "granted", "source")
+SELECT gen_random_uuid()::text, s."userId", c.purpose, c.granted, 'backfill'
+FROM "settings" s
+CROSS JOIN LATERAL (VALUES
+    ('locationTracking', s."enabledLocationTracking"),
+    ('cityStatDataUsage', s."allowCityStatDataUsage"),
+    ('personalization', s."allowDataPersonalizationUsage"),
+    ('analytics', s."allowDataAnaliticsAndPerformance")
+) AS c(purpose, granted);
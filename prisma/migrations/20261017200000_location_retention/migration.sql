@@ -0,0 +1,7 @@
+-- AlterTable
+ALTER TABLE "visited_streets" ALTER COLUMN "entry_latitude" DROP NOT NULL,
+ALTER COLUMN "entry_longitude" DROP NOT NULL,
+ADD COLUMN "leg_meters" DOUBLE PRECISION;
+
+-- AlterTable
+ALTER TABLE "settings" ADD COLUMN "locationRetentionDays" INTEGER;
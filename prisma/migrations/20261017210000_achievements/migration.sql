@@ -0,0 +1,15 @@
+-- CreateTable
+CREATE TABLE "achievements" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "code" TEXT NOT NULL,
+    "awarded_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "achievements_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "achievements_user_id_code_key" ON "achievements"("user_id", "code");
+
+-- AddForeignKey
+ALTER TABLE "achievements" ADD CONSTRAINT "achievements_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
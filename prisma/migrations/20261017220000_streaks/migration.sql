@@ -0,0 +1,18 @@
+-- AlterTable
+ALTER TABLE "users" ADD COLUMN "timeZone" TEXT NOT NULL DEFAULT 'UTC';
+
+-- CreateTable
+CREATE TABLE "streak_freezes" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "day" TEXT NOT NULL,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "streak_freezes_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "streak_freezes_user_id_day_key" ON "streak_freezes"("user_id", "day");
+
+-- AddForeignKey
+ALTER TABLE "streak_freezes" ADD CONSTRAINT "streak_freezes_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
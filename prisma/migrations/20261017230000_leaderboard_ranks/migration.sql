@@ -0,0 +1,29 @@
+-- CreateEnum
+CREATE TYPE "ProfileVisibility" AS ENUM ('PUBLIC', 'FRIENDS', 'PRIVATE');
+
+-- AlterTable
+ALTER TABLE "settings" ADD COLUMN "profileVisibility" "ProfileVisibility" NOT NULL DEFAULT 'PUBLIC';
+
+-- CreateTable
+CREATE TABLE "leaderboard_ranks" (
+    "id" TEXT NOT NULL,
+    "scope" TEXT NOT NULL,
+    "metric" TEXT NOT NULL,
+    "period" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "rank" INTEGER NOT NULL,
+    "value" DOUBLE PRECISION NOT NULL,
+    "period_start" BIGINT NOT NULL,
+    "computed_at" TIMESTAMP(3) NOT NULL,
+
+    CONSTRAINT "leaderboard_ranks_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "leaderboard_ranks_scope_metric_period_user_id_key" ON "leaderboard_ranks"("scope", "metric", "period", "user_id");
+
+-- CreateIndex
+CREATE INDEX "leaderboard_ranks_scope_metric_period_rank_idx" ON "leaderboard_ranks"("scope", "metric", "period", "rank");
+
+-- AddForeignKey
+ALTER TABLE "leaderboard_ranks" ADD CONSTRAINT "leaderboard_ranks_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
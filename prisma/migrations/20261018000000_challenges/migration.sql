@@ -0,0 +1,54 @@
+-- CreateEnum
+CREATE TYPE "ChallengeParticipantStatus" AS ENUM ('INVITED', 'JOINED', 'DECLINED');
+
+-- CreateTable
+CREATE TABLE "challenges" (
+    "id" TEXT NOT NULL,
+    "creator_id" TEXT NOT NULL,
+    "title" TEXT NOT NULL,
+    "metric" TEXT NOT NULL,
+    "target" DOUBLE PRECISION,
+    "city_id" TEXT,
+    "starts_at" TIMESTAMP(3) NOT NULL,
+    "ends_at" TIMESTAMP(3) NOT NULL,
+    "finalized_at" TIMESTAMP(3),
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "challenges_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "challenge_participants" (
+    "id" TEXT NOT NULL,
+    "challenge_id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "status" "ChallengeParticipantStatus" NOT NULL DEFAULT 'INVITED',
+    "invited_by_id" TEXT,
+    "joined_at" TIMESTAMP(3),
+    "final_value" DOUBLE PRECISION,
+    "final_rank" INTEGER,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "challenge_participants_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "challenges_ends_at_finalized_at_idx" ON "challenges"("ends_at", "finalized_at");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "challenge_participants_challenge_id_user_id_key" ON "challenge_participants"("challenge_id", "user_id");
+
+-- CreateIndex
+CREATE INDEX "challenge_participants_user_id_idx" ON "challenge_participants"("user_id");
+
+-- AddForeignKey
+ALTER TABLE "challenges" ADD CONSTRAINT "challenges_creator_id_fkey" FOREIGN KEY ("creator_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "challenges" ADD CONSTRAINT "challenges_city_id_fkey" FOREIGN KEY ("city_id") REFERENCES "cities"("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "challenge_participants" ADD CONSTRAINT "challenge_participants_challenge_id_fkey" FOREIGN KEY ("challenge_id") REFERENCES "challenges"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "challenge_participants" ADD CONSTRAINT "challenge_participants_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
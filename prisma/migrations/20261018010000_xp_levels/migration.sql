@@ -0,0 +1,25 @@
+-- AlterTable
+ALTER TABLE "users" ADD COLUMN "xp" INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN "level" INTEGER NOT NULL DEFAULT 1;
+
+-- CreateTable
+CREATE TABLE "xp_events" (
+    "id" TEXT NOT NULL,
+    "user_id" TEXT NOT NULL,
+    "source" TEXT NOT NULL,
+    "ref" TEXT NOT NULL,
+    "quantity" INTEGER NOT NULL,
+    "amount" INTEGER NOT NULL,
+    "created_at" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "xp_events_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "xp_events_user_id_source_ref_key" ON "xp_events"("user_id", "source", "ref");
+
+-- CreateIndex
+CREATE INDEX "xp_events_user_id_created_at_idx" ON "xp_events"("user_id", "created_at");
+
+-- AddForeignKey
+ALTER TABLE "xp_events" ADD CONSTRAINT "xp_events_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// AchievementRule awards a badge once the user's value of Metric reaches Threshold
+type AchievementRule struct {
+	Code        string
+	Name        string
+	Description string
+	Metric      string
+	Threshold   int
+}
+
+// AchievementRules are all badges that can be earned. Codes are stored with awarded badges, so a
+// rule's code must never change; removing a rule hides its badges.
+var AchievementRules = []AchievementRule{
+	{Code: "first-street", Name: "First Steps", Description: "Walk your first street", Metric: types.AchievementMetricStreets, Threshold: 1},
+	{Code: "streets-100", Name: "Street Collector", Description: "Walk 100 streets", Metric: types.AchievementMetricStreets, Threshold: 100},
+	{Code: "streets-500", Name: "Street Hoarder", Description: "Walk 500 streets", Metric: types.AchievementMetricStreets, Threshold: 500},
+	{Code: "streets-1000", Name: "Cartographer", Description: "Walk 1000 streets", Metric: types.AchievementMetricStreets, Threshold: 1000},
+	{Code: "distance-10", Name: "Warming Up", Description: "Walk 10 km", Metric: types.AchievementMetricKilometers, Threshold: 10},
+	{Code: "distance-100", Name: "Long Haul", Description: "Walk 100 km", Metric: types.AchievementMetricKilometers, Threshold: 100},
+	{Code: "streak-7", Name: "Week Walker", Description: "Walk every day for 7 days", Metric: types.AchievementMetricStreakDays, Threshold: 7},
+	{Code: "streak-30", Name: "Habit Formed", Description: "Walk every day for 30 days", Metric: types.AchievementMetricStreakDays, Threshold: 30},
+	{Code: "districts-5", Name: "District Hopper", Description: "Visit 5 districts", Metric: types.AchievementMetricDistricts, Threshold: 5},
+	{Code: "cities-3", Name: "City Hopper", Description: "Walk in 3 cities", Metric: types.AchievementMetricCities, Threshold: 3},
+	{Code: "walks-10", Name: "Regular", Description: "Finish 10 walks", Metric: types.AchievementMetricWalks, Threshold: 10},
+}
+
+type AchievementService struct {
+	client         *db.PrismaClient
+	consentService *ConsentService
+}
+
+func NewAchievementService(client *db.PrismaClient, consentService *ConsentService) *AchievementService {
+	return &AchievementService{client: client, consentService: consentService}
+}
+
+// Evaluate awards every badge whose rule the user now meets and returns the new ones. Users who
+// turned off in-app rewards are skipped.
+func (s *AchievementService) Evaluate(ctx context.Context, clerkUserID string) ([]types.AchievementResult, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, clerkUserID)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	metrics, err := s.metrics(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	awarded, err := s.awarded(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	for _, rule := range AchievementRules {
+		if _, ok := awarded[rule.Code]; !ok && metrics[rule.Metric] >= rule.Threshold {
+			codes = append(codes, rule.Code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(codes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode achievements: %w", err)
+	}
+	// a concurrent evaluation may have awarded the same badge first; the earlier timestamp stays
+	var inserted []struct {
+		Code      db.RawString   `json:"code"`
+		AwardedAt db.RawDateTime `json:"awarded_at"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		INSERT INTO achievements (id, user_id, code)
+		SELECT gen_random_uuid()::text, $1, code FROM jsonb_array_elements_text($2::jsonb) AS code
+		ON CONFLICT (user_id, code) DO NOTHING
+		RETURNING code, awarded_at`,
+		clerkUserID, string(payload),
+	).Exec(ctx, &inserted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to award achievements: %w", err)
+	}
+
+	newlyAwarded := make(map[string]time.Time, len(inserted))
+	for _, row := range inserted {
+		newlyAwarded[string(row.Code)] = row.AwardedAt.Time
+	}
+	results := make([]types.AchievementResult, 0, len(inserted))
+	for _, rule := range AchievementRules {
+		if awardedAt, ok := newlyAwarded[rule.Code]; ok {
+			results = append(results, toAchievementResult(rule, &awardedAt))
+		}
+	}
+
+	return results, nil
+}
+
+// Achievements lists every rule with the user's badge for it, and their progress towards it
+func (s *AchievementService) Achievements(ctx context.Context, clerkUserID string) (*types.AchievementsResponse, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	response := &types.AchievementsResponse{Enabled: enabled, Achievements: []types.AchievementResult{}}
+	if !enabled {
+		return response, nil
+	}
+
+	awarded, err := s.awarded(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	// progress is derived from the user's visits, which needs the same consent as their stats
+	var metrics map[string]int
+	allowed, err := s.consentService.Allowed(ctx, clerkUserID, types.ConsentCityStatDataUsage)
+	if err != nil {
+		return nil, err
+	}
+	if allowed {
+		if metrics, err = s.metrics(ctx, clerkUserID); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rule := range AchievementRules {
+		var awardedAt *time.Time
+		if at, ok := awarded[rule.Code]; ok {
+			awardedAt = &at
+		}
+		result := toAchievementResult(rule, awardedAt)
+		if metrics != nil {
+			progress := min(metrics[rule.Metric], rule.Threshold)
+			result.Progress = &progress
+		}
+		response.Achievements = append(response.Achievements, result)
+	}
+
+	return response, nil
+}
+
+// Earned lists the badges the user has earned, as shown to other users
+func (s *AchievementService) Earned(ctx context.Context, clerkUserID string) (*types.AchievementsResponse, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	response := &types.AchievementsResponse{Enabled: enabled, Achievements: []types.AchievementResult{}}
+	if !enabled {
+		return response, nil
+	}
+
+	awarded, err := s.awarded(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range AchievementRules {
+		if at, ok := awarded[rule.Code]; ok {
+			response.Achievements = append(response.Achievements, toAchievementResult(rule, &at))
+		}
+	}
+
+	return response, nil
+}
+
+// rewardsEnabled reads allowInAppRewards, which is on for users without settings
+func rewardsEnabled(ctx context.Context, client *db.PrismaClient, clerkUserID string) (bool, error) {
+	settings, err := client.Settings.FindUnique(
+		db.Settings.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to load settings: %w", err)
+	}
+	return settings.AllowInAppRewards, nil
+}
+
+func (s *AchievementService) awarded(ctx context.Context, clerkUserID string) (map[string]time.Time, error) {
+	achievements, err := s.client.Achievement.FindMany(
+		db.Achievement.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load achievements: %w", err)
+	}
+
+	awarded := make(map[string]time.Time, len(achievements))
+	for _, achievement := range achievements {
+		awarded[achievement.Code] = achievement.AwardedAt
+	}
+	return awarded, nil
+}
+
+// metrics measures the user on every achievement metric, from visits that are not flagged
+func (s *AchievementService) metrics(ctx context.Context, clerkUserID string) (map[string]int, error) {
+	var rows []struct {
+		Streets    db.RawInt   `json:"streets"`
+		Kilometers db.RawFloat `json:"kilometers"`
+		Districts  db.RawInt   `json:"districts"`
+		Cities     db.RawInt   `json:"cities"`
+		Walks      db.RawInt   `json:"walks"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT
+			(SELECT COUNT(DISTINCT street_id) FROM visited_streets WHERE user_id = $1 AND NOT flagged)::int AS streets,
+			(SELECT COALESCE(SUM("totalKilometers"), 0) FROM city_stats WHERE "userId" = $1)::float8 AS kilometers,
+			(SELECT COUNT(DISTINCT ds.district_id)
+				FROM district_streets ds
+				JOIN districts d ON d.id = ds.district_id AND d.level = 'district'
+				JOIN visited_streets v ON v.street_id = ds.street_id
+				WHERE v.user_id = $1 AND NOT v.flagged)::int AS districts,
+			(SELECT COUNT(DISTINCT city_id) FROM visited_streets WHERE user_id = $1 AND NOT flagged)::int AS cities,
+			(SELECT COUNT(*) FROM walk_sessions WHERE user_id = $1 AND status = 'COMPLETED')::int AS walks`,
+		clerkUserID,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure achievements: %w", err)
+	}
+
+	loc, err := userLocation(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := activeDays(ctx, s.client, clerkUserID, loc, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	frozen, err := frozenDays(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]int{
+		types.AchievementMetricStreakDays: utils.Streaks(days, frozen, utils.LocalDay(time.Now(), loc)).Longest,
+	}
+	if len(rows) > 0 {
+		metrics[types.AchievementMetricStreets] = int(rows[0].Streets)
+		metrics[types.AchievementMetricKilometers] = int(math.Floor(float64(rows[0].Kilometers)))
+		metrics[types.AchievementMetricDistricts] = int(rows[0].Districts)
+		metrics[types.AchievementMetricCities] = int(rows[0].Cities)
+		metrics[types.AchievementMetricWalks] = int(rows[0].Walks)
+	}
+	return metrics, nil
+}
+
+func toAchievementResult(rule AchievementRule, awardedAt *time.Time) types.AchievementResult {
+	result := types.AchievementResult{
+		Code:        rule.Code,
+		Name:        rule.Name,
+		Description: rule.Description,
+		Metric:      rule.Metric,
+		Threshold:   rule.Threshold,
+	}
+	if awardedAt != nil {
+		formatted := awardedAt.Format(time.RFC3339)
+		result.AwardedAt = &formatted
+	}
+	return result
+}
@@ -0,0 +1,546 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+const (
+	// MaxChallengeDays is the longest window a challenge can have
+	MaxChallengeDays = 92
+	// ChallengeFinalizeInterval is how often ended challenges get their results frozen
+	ChallengeFinalizeInterval = 10 * time.Minute
+	// ChallengeFinalizeGrace is how long after a challenge ends its results stay live, so walks in
+	// the window that are synced late from offline clients still count
+	ChallengeFinalizeGrace  = 6 * time.Hour
+	maxChallengeTitleLength = 100
+)
+
+// challengeStatusOrder lists unranked participants joined first, then invited, then declined
+var challengeStatusOrder = map[string]int{
+	string(db.ChallengeParticipantStatusJoined):   0,
+	string(db.ChallengeParticipantStatusInvited):  1,
+	string(db.ChallengeParticipantStatusDeclined): 2,
+}
+
+type ChallengeService struct {
+	client         *db.PrismaClient
+	consentService *ConsentService
+}
+
+func NewChallengeService(client *db.PrismaClient, consentService *ConsentService) *ChallengeService {
+	return &ChallengeService{client: client, consentService: consentService}
+}
+
+// CreateChallenge creates a challenge the creator has joined and invites the given friends to it
+func (s *ChallengeService) CreateChallenge(ctx context.Context, clerkUserID string, req types.CreateChallengeRequest) (*types.ChallengeResponse, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentCityStatDataUsage); err != nil {
+		return nil, err
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" || len(title) > maxChallengeTitleLength {
+		return nil, fmt.Errorf("invalid challenge: title must be 1 to %d characters", maxChallengeTitleLength)
+	}
+	if !validLeaderboardMetric(req.Metric) {
+		return nil, fmt.Errorf("invalid challenge: metric must be streets, kilometers, coverage or newStreets")
+	}
+	if req.Metric == types.LeaderboardMetricCoverage && req.CityID == nil {
+		return nil, fmt.Errorf("invalid challenge: coverage needs a cityId")
+	}
+	if req.Target != nil && *req.Target <= 0 {
+		return nil, fmt.Errorf("invalid challenge: target must be positive")
+	}
+
+	startsAt, err := time.Parse(time.RFC3339, req.StartsAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: startsAt must be an RFC3339 time")
+	}
+	endsAt, err := time.Parse(time.RFC3339, req.EndsAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: endsAt must be an RFC3339 time")
+	}
+	if !endsAt.After(startsAt) || !endsAt.After(time.Now()) {
+		return nil, fmt.Errorf("invalid challenge: endsAt must be after startsAt and in the future")
+	}
+	if endsAt.Sub(startsAt) > MaxChallengeDays*24*time.Hour {
+		return nil, fmt.Errorf("invalid challenge: a challenge can last at most %d days", MaxChallengeDays)
+	}
+
+	if req.CityID != nil {
+		_, err := s.client.City.FindUnique(
+			db.City.ID.Equals(*req.CityID),
+		).Exec(ctx)
+		if err != nil {
+			if err == db.ErrNotFound {
+				return nil, fmt.Errorf("invalid challenge: unknown city %s", *req.CityID)
+			}
+			return nil, fmt.Errorf("failed to get city: %w", err)
+		}
+	}
+	invited := uniqueUserIDs(req.InviteUserIDs, clerkUserID)
+	if err := s.requireFriends(ctx, clerkUserID, invited); err != nil {
+		return nil, err
+	}
+
+	// the challenge, the creator's participation and the invitations are stored together, so a
+	// failure leaves no challenge behind
+	challengeID := utils.NewID()
+	txs := []db.PrismaTransaction{
+		s.client.Challenge.CreateOne(
+			db.Challenge.Title.Set(title),
+			db.Challenge.Metric.Set(req.Metric),
+			db.Challenge.StartsAt.Set(startsAt),
+			db.Challenge.EndsAt.Set(endsAt),
+			db.Challenge.Creator.Link(db.User.ID.Equals(clerkUserID)),
+			db.Challenge.ID.Set(challengeID),
+			db.Challenge.Target.SetIfPresent(req.Target),
+			db.Challenge.CityID.SetIfPresent(req.CityID),
+		).Tx(),
+		s.client.ChallengeParticipant.CreateOne(
+			db.ChallengeParticipant.Challenge.Link(db.Challenge.ID.Equals(challengeID)),
+			db.ChallengeParticipant.User.Link(db.User.ID.Equals(clerkUserID)),
+			db.ChallengeParticipant.Status.Set(db.ChallengeParticipantStatusJoined),
+			db.ChallengeParticipant.JoinedAt.Set(time.Now()),
+		).Tx(),
+	}
+	if len(invited) > 0 {
+		invite, err := s.inviteTx(challengeID, clerkUserID, invited)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, invite)
+	}
+	if err := s.client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create challenge: %w", err)
+	}
+
+	return s.GetChallenge(ctx, clerkUserID, challengeID)
+}
+
+// Invite invites friends of the caller, who must have joined the challenge, before it ends.
+// Users who declined earlier are invited again.
+func (s *ChallengeService) Invite(ctx context.Context, clerkUserID, challengeID string, userIDs []string) (*types.ChallengeResponse, error) {
+	challenge, participant, err := s.participation(ctx, clerkUserID, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if participant.Status != db.ChallengeParticipantStatusJoined {
+		return nil, fmt.Errorf("invalid challenge: only participants who joined can invite")
+	}
+	if !time.Now().Before(challenge.EndsAt) {
+		return nil, fmt.Errorf("challenge has ended")
+	}
+	userIDs = uniqueUserIDs(userIDs, clerkUserID)
+	if err := s.requireFriends(ctx, clerkUserID, userIDs); err != nil {
+		return nil, err
+	}
+	if err := s.invite(ctx, challengeID, clerkUserID, userIDs); err != nil {
+		return nil, err
+	}
+
+	return s.GetChallenge(ctx, clerkUserID, challengeID)
+}
+
+// Join accepts the caller's invitation to a challenge that has not ended
+func (s *ChallengeService) Join(ctx context.Context, clerkUserID, challengeID string) (*types.ChallengeResponse, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentCityStatDataUsage); err != nil {
+		return nil, err
+	}
+	return s.setStatus(ctx, clerkUserID, challengeID, db.ChallengeParticipantStatusJoined)
+}
+
+// Decline turns down the caller's invitation, or leaves a challenge they joined, before it ends
+func (s *ChallengeService) Decline(ctx context.Context, clerkUserID, challengeID string) (*types.ChallengeResponse, error) {
+	return s.setStatus(ctx, clerkUserID, challengeID, db.ChallengeParticipantStatusDeclined)
+}
+
+func (s *ChallengeService) setStatus(ctx context.Context, clerkUserID, challengeID string, status db.ChallengeParticipantStatus) (*types.ChallengeResponse, error) {
+	challenge, participant, err := s.participation(ctx, clerkUserID, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(challenge.EndsAt) {
+		return nil, fmt.Errorf("challenge has ended")
+	}
+
+	if participant.Status != status {
+		ops := []db.ChallengeParticipantSetParam{db.ChallengeParticipant.Status.Set(status)}
+		if status == db.ChallengeParticipantStatusJoined {
+			ops = append(ops, db.ChallengeParticipant.JoinedAt.Set(time.Now()))
+		}
+		_, err = s.client.ChallengeParticipant.FindMany(
+			db.ChallengeParticipant.ID.Equals(participant.ID),
+		).Update(ops...).Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update challenge participant: %w", err)
+		}
+	}
+
+	return s.GetChallenge(ctx, clerkUserID, challengeID)
+}
+
+// ListChallenges lists the challenges the caller was invited to or joined, latest ending first
+func (s *ChallengeService) ListChallenges(ctx context.Context, clerkUserID string) (*types.ChallengesResponse, error) {
+	participants, err := s.client.ChallengeParticipant.FindMany(
+		db.ChallengeParticipant.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge participants: %w", err)
+	}
+
+	statuses := make(map[string]db.ChallengeParticipantStatus, len(participants))
+	challengeIDs := make([]string, len(participants))
+	for i, participant := range participants {
+		statuses[participant.ChallengeID] = participant.Status
+		challengeIDs[i] = participant.ChallengeID
+	}
+	challenges, err := s.client.Challenge.FindMany(
+		db.Challenge.ID.In(challengeIDs),
+	).OrderBy(
+		db.Challenge.EndsAt.Order(db.DESC),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenges: %w", err)
+	}
+
+	response := &types.ChallengesResponse{Challenges: make([]types.ChallengeResponse, len(challenges))}
+	for i := range challenges {
+		response.Challenges[i] = toChallengeResponse(&challenges[i], statuses[challenges[i].ID])
+	}
+	return response, nil
+}
+
+// GetChallenge returns a challenge with its participants ranked: live while it runs and during the
+// grace period after it, frozen from then on. Only invited users see it.
+func (s *ChallengeService) GetChallenge(ctx context.Context, clerkUserID, challengeID string) (*types.ChallengeResponse, error) {
+	challenge, participant, err := s.participation(ctx, clerkUserID, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if _, finalized := challenge.FinalizedAt(); !finalized && !time.Now().Before(challenge.EndsAt.Add(ChallengeFinalizeGrace)) {
+		// the scheduled job has not frozen it yet
+		if err := s.finalize(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if challenge, participant, err = s.participation(ctx, clerkUserID, challengeID); err != nil {
+			return nil, err
+		}
+	}
+
+	participants, err := s.client.ChallengeParticipant.FindMany(
+		db.ChallengeParticipant.ChallengeID.Equals(challengeID),
+	).With(
+		db.ChallengeParticipant.User.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge participants: %w", err)
+	}
+
+	values := make(map[string]float64)
+	ranks := make(map[string]int)
+	if _, finalized := challenge.FinalizedAt(); finalized {
+		for _, p := range participants {
+			if value, ok := p.FinalValue(); ok {
+				values[p.UserID] = value
+			}
+			if rank, ok := p.FinalRank(); ok {
+				ranks[p.UserID] = rank
+			}
+		}
+	} else if !time.Now().Before(challenge.StartsAt) {
+		entries, err := s.standings(ctx, challenge, participants)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			values[entry.UserID] = entry.Value
+			ranks[entry.UserID] = entry.Rank
+		}
+	}
+
+	response := toChallengeResponse(challenge, participant.Status)
+	response.Participants = make([]types.ChallengeParticipantResult, 0, len(participants))
+	for _, p := range participants {
+		result := types.ChallengeParticipantResult{
+			UserID:   p.UserID,
+			Status:   string(p.Status),
+			IsCaller: p.UserID == clerkUserID,
+		}
+		if user := p.User(); user != nil {
+			entry := toLeaderboardEntry(user, 0, clerkUserID)
+			result.UserName, result.FirstName, result.LastName, result.ImageURL = entry.UserName, entry.FirstName, entry.LastName, entry.ImageURL
+		}
+		if value, ok := values[p.UserID]; ok {
+			result.Value = &value
+			if target, ok := challenge.Target(); ok {
+				result.ReachedTarget = value >= target
+			}
+		}
+		if rank, ok := ranks[p.UserID]; ok {
+			result.Rank = &rank
+		}
+		response.Participants = append(response.Participants, result)
+	}
+	// ranked participants first, then joined, invited and declined ones
+	sort.SliceStable(response.Participants, func(i, j int) bool {
+		a, b := response.Participants[i], response.Participants[j]
+		if (a.Rank == nil) != (b.Rank == nil) {
+			return a.Rank != nil
+		}
+		if a.Rank != nil && *a.Rank != *b.Rank {
+			return *a.Rank < *b.Rank
+		}
+		if a.Status != b.Status {
+			return challengeStatusOrder[a.Status] < challengeStatusOrder[b.Status]
+		}
+		return a.UserID < b.UserID
+	})
+
+	return &response, nil
+}
+
+// FinalizeEnded freezes the results of every challenge that ended more than ChallengeFinalizeGrace ago
+func (s *ChallengeService) FinalizeEnded(ctx context.Context) (*types.ChallengeFinalizeResult, error) {
+	challenges, err := s.client.Challenge.FindMany(
+		db.Challenge.EndsAt.Lte(time.Now().Add(-ChallengeFinalizeGrace)),
+		db.Challenge.FinalizedAt.IsNull(),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ended challenges: %w", err)
+	}
+
+	result := &types.ChallengeFinalizeResult{}
+	for i := range challenges {
+		if err := s.finalize(ctx, &challenges[i]); err != nil {
+			fmt.Printf("Warning: failed to finalize challenge %s: %v\n", challenges[i].ID, err)
+			continue
+		}
+		result.Finalized++
+	}
+	return result, nil
+}
+
+// RunEvery freezes the results of ended challenges every interval until ctx is done
+func (s *ChallengeService) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.FinalizeEnded(ctx); err != nil {
+			fmt.Printf("Warning: challenge finalization failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finalize stores the joined participants' final values and ranks and marks the challenge final.
+// Participants who turned off city stat data usage get no result.
+func (s *ChallengeService) finalize(ctx context.Context, challenge *db.ChallengeModel) error {
+	participants, err := s.client.ChallengeParticipant.FindMany(
+		db.ChallengeParticipant.ChallengeID.Equals(challenge.ID),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get challenge participants: %w", err)
+	}
+	entries, err := s.standings(ctx, challenge, participants)
+	if err != nil {
+		return err
+	}
+
+	type resultRow struct {
+		UserID string  `json:"user_id"`
+		Rank   int     `json:"rank"`
+		Value  float64 `json:"value"`
+	}
+	rows := make([]resultRow, len(entries))
+	for i, entry := range entries {
+		rows[i] = resultRow{UserID: entry.UserID, Rank: entry.Rank, Value: entry.Value}
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode challenge results: %w", err)
+	}
+
+	// the challenge is claimed and its results stored in one statement, so a concurrent finalization
+	// that lost the claim writes nothing
+	_, err = s.client.Prisma.ExecuteRaw(`
+		WITH claimed AS (
+			UPDATE challenges SET finalized_at = now() WHERE id = $1 AND finalized_at IS NULL
+			RETURNING id
+		)
+		UPDATE challenge_participants p SET final_value = x.value, final_rank = x.rank
+		FROM jsonb_to_recordset($2::jsonb) AS x(user_id text, rank int, value float8), claimed
+		WHERE p.challenge_id = claimed.id AND p.user_id = x.user_id`,
+		challenge.ID, string(payload),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to store challenge results: %w", err)
+	}
+	return nil
+}
+
+// standings ranks the joined participants on the challenge metric over the visits in its window.
+// Joining is consent to be shown to the other participants, so profile visibility does not apply.
+func (s *ChallengeService) standings(ctx context.Context, challenge *db.ChallengeModel, participants []db.ChallengeParticipantModel) ([]types.LeaderboardEntry, error) {
+	var joined []string
+	for _, p := range participants {
+		if p.Status == db.ChallengeParticipantStatusJoined {
+			joined = append(joined, p.UserID)
+		}
+	}
+	if len(joined) == 0 {
+		return nil, nil
+	}
+
+	filter := leaderboardFilter{
+		UserIDs:       joined,
+		Since:         challenge.StartsAt.UnixMilli(),
+		Until:         challenge.EndsAt.UnixMilli(),
+		AnyVisibility: true,
+	}
+	if cityID, ok := challenge.CityID(); ok {
+		filter.CityID = &cityID
+	}
+	values, err := leaderboardValues(ctx, s.client, challenge.Metric, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]types.LeaderboardEntry, 0, len(values))
+	for userID, value := range values {
+		entries = append(entries, types.LeaderboardEntry{UserID: userID, Value: value})
+	}
+	rankLeaderboard(entries)
+	return entries, nil
+}
+
+// participation returns the challenge and the caller's place in it; challenges the caller was not
+// invited to are not found
+func (s *ChallengeService) participation(ctx context.Context, clerkUserID, challengeID string) (*db.ChallengeModel, *db.ChallengeParticipantModel, error) {
+	participant, err := s.client.ChallengeParticipant.FindFirst(
+		db.ChallengeParticipant.ChallengeID.Equals(challengeID),
+		db.ChallengeParticipant.UserID.Equals(clerkUserID),
+	).With(
+		db.ChallengeParticipant.Challenge.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, nil, fmt.Errorf("challenge not found")
+		}
+		return nil, nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	return participant.Challenge(), participant, nil
+}
+
+// requireFriends checks that every user is a friend of the caller
+func (s *ChallengeService) requireFriends(ctx context.Context, clerkUserID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	friends, err := s.client.Friend.FindMany(
+		db.Friend.UserID.Equals(clerkUserID),
+		db.Friend.FriendID.In(userIDs),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get friends: %w", err)
+	}
+
+	isFriend := make(map[string]bool, len(friends))
+	for _, friend := range friends {
+		isFriend[friend.FriendID] = true
+	}
+	for _, userID := range userIDs {
+		if !isFriend[userID] {
+			return fmt.Errorf("invalid challenge: %s is not your friend", userID)
+		}
+	}
+	return nil
+}
+
+func (s *ChallengeService) invite(ctx context.Context, challengeID, invitedByID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	invite, err := s.inviteTx(challengeID, invitedByID, userIDs)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Prisma.Transaction(invite).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to invite to challenge: %w", err)
+	}
+	return nil
+}
+
+// inviteTx invites users who are not participants yet, and again those who declined
+func (s *ChallengeService) inviteTx(challengeID, invitedByID string, userIDs []string) (db.PrismaTransaction, error) {
+	payload, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode invitations: %w", err)
+	}
+	return s.client.Prisma.ExecuteRaw(`
+		INSERT INTO challenge_participants (id, challenge_id, user_id, invited_by_id)
+		SELECT gen_random_uuid()::text, $1, user_id, $2 FROM jsonb_array_elements_text($3::jsonb) AS user_id
+		ON CONFLICT (challenge_id, user_id) DO UPDATE SET status = 'INVITED', invited_by_id = EXCLUDED.invited_by_id
+		WHERE challenge_participants.status = 'DECLINED'`,
+		challengeID, invitedByID, string(payload),
+	).Tx(), nil
+}
+
+// uniqueUserIDs drops repeated ids and the caller's own from a list of users to invite
+func uniqueUserIDs(userIDs []string, clerkUserID string) []string {
+	seen := map[string]bool{clerkUserID: true}
+	unique := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			unique = append(unique, userID)
+		}
+	}
+	return unique
+}
+
+func toChallengeResponse(challenge *db.ChallengeModel, callerStatus db.ChallengeParticipantStatus) types.ChallengeResponse {
+	response := types.ChallengeResponse{
+		ID:           challenge.ID,
+		CreatorID:    challenge.CreatorID,
+		Title:        challenge.Title,
+		Metric:       challenge.Metric,
+		StartsAt:     challenge.StartsAt.UTC().Format(time.RFC3339),
+		EndsAt:       challenge.EndsAt.UTC().Format(time.RFC3339),
+		CallerStatus: string(callerStatus),
+	}
+	if target, ok := challenge.Target(); ok {
+		response.Target = &target
+	}
+	if cityID, ok := challenge.CityID(); ok {
+		response.CityID = &cityID
+	}
+
+	now := time.Now()
+	switch {
+	case now.Before(challenge.StartsAt):
+		response.State = types.ChallengeStateUpcoming
+	case now.Before(challenge.EndsAt):
+		response.State = types.ChallengeStateActive
+	default:
+		response.State = types.ChallengeStateEnded
+	}
+	if finalizedAt, ok := challenge.FinalizedAt(); ok {
+		formatted := finalizedAt.UTC().Format(time.RFC3339)
+		response.FinalizedAt = &formatted
+	}
+	return response
+}
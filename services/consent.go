@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+)
+
+// ErrConsentRequired is returned when the user has not consented to what a request needs
+var ErrConsentRequired = errors.New("consent required")
+
+// ConsentService is the one place that decides what a user's data may be used for. Services ask
+// it before ingesting, aggregating, personalizing or keeping analytics data; changes to the flags
+// are recorded in the consent ledger by a database trigger.
+type ConsentService struct {
+	client *db.PrismaClient
+}
+
+func NewConsentService(client *db.PrismaClient) *ConsentService {
+	return &ConsentService{client: client}
+}
+
+// Consents returns the user's current consents. Users without settings get the Settings defaults.
+func (s *ConsentService) Consents(ctx context.Context, clerkUserID string) (*types.ConsentsResponse, error) {
+	settings, err := s.client.Settings.FindUnique(
+		db.Settings.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return &types.ConsentsResponse{
+				LocationTracking:  false,
+				CityStatDataUsage: true,
+				Personalization:   true,
+				Analytics:         true,
+			}, nil
+		}
+		return nil, fmt.Errorf("failed to load consent: %w", err)
+	}
+
+	return &types.ConsentsResponse{
+		LocationTracking:  settings.EnabledLocationTracking,
+		CityStatDataUsage: settings.AllowCityStatDataUsage,
+		Personalization:   settings.AllowDataPersonalizationUsage,
+		Analytics:         settings.AllowDataAnaliticsAndPerformance,
+	}, nil
+}
+
+// Allowed reports whether the user consented to purpose
+func (s *ConsentService) Allowed(ctx context.Context, clerkUserID, purpose string) (bool, error) {
+	consents, err := s.Consents(ctx, clerkUserID)
+	if err != nil {
+		return false, err
+	}
+
+	switch purpose {
+	case types.ConsentLocationTracking:
+		return consents.LocationTracking, nil
+	case types.ConsentCityStatDataUsage:
+		return consents.CityStatDataUsage, nil
+	case types.ConsentPersonalization:
+		return consents.Personalization, nil
+	case types.ConsentAnalytics:
+		return consents.Analytics, nil
+	}
+	return false, fmt.Errorf("unknown consent purpose %q", purpose)
+}
+
+// Require returns an error wrapping ErrConsentRequired unless the user consented to purpose
+func (s *ConsentService) Require(ctx context.Context, clerkUserID, purpose string) error {
+	allowed, err := s.Allowed(ctx, clerkUserID, purpose)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s is turned off in settings", ErrConsentRequired, purpose)
+	}
+	return nil
+}
+
+// Ledger returns the user's consent changes, newest first
+func (s *ConsentService) Ledger(ctx context.Context, clerkUserID string, limit, offset int) ([]types.ConsentEventResult, error) {
+	events, err := s.client.ConsentEvent.FindMany(
+		db.ConsentEvent.UserID.Equals(clerkUserID),
+	).OrderBy(
+		db.ConsentEvent.RecordedAt.Order(db.DESC),
+	).Skip(offset).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load consent ledger: %w", err)
+	}
+
+	results := make([]types.ConsentEventResult, len(events))
+	for i, event := range events {
+		results[i] = types.ConsentEventResult{
+			Purpose:    event.Purpose,
+			Granted:    event.Granted,
+			Source:     event.Source,
+			RecordedAt: event.RecordedAt.Format(time.RFC3339),
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+type DistrictService struct {
+	client         *db.PrismaClient
+	consentService *ConsentService
+}
+
+func NewDistrictService(client *db.PrismaClient, consentService *ConsentService) *DistrictService {
+	return &DistrictService{client: client, consentService: consentService}
+}
+
+type districtStreetRow struct {
+	DistrictID   string  `json:"district_id"`
+	StreetID     string  `json:"street_id"`
+	LengthMeters float64 `json:"length_meters"`
+}
+
+// ImportDistricts replaces a city's districts of one level. Districts keep their id when
+// re-imported under the same name, so goals set on them survive a boundary update.
+func (s *DistrictService) ImportDistricts(ctx context.Context, cityID, level string, districts []utils.NamedBoundary) (int, error) {
+	type districtRow struct {
+		Name     string          `json:"name"`
+		Boundary json.RawMessage `json:"boundary"`
+	}
+	rows := make([]districtRow, 0, len(districts))
+	for _, district := range districts {
+		boundary, err := utils.BoundaryGeometry(district.Polygons)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode boundary of %s: %w", district.Name, err)
+		}
+		rows = append(rows, districtRow{Name: district.Name, Boundary: boundary})
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode districts: %w", err)
+	}
+
+	err = s.client.Prisma.Transaction(
+		s.client.Prisma.ExecuteRaw(`
+			DELETE FROM districts
+			WHERE city_id = $1 AND level = $2
+				AND name NOT IN (SELECT name FROM jsonb_to_recordset($3::jsonb) AS x(name text))`,
+			cityID, level, string(payload),
+		).Tx(),
+		s.client.Prisma.ExecuteRaw(`
+			INSERT INTO districts (id, city_id, name, level, boundary, updated_at)
+			SELECT gen_random_uuid()::text, $1, name, $2, boundary, now()
+			FROM jsonb_to_recordset($3::jsonb) AS x(name text, boundary jsonb)
+			ON CONFLICT (city_id, level, name) DO UPDATE SET
+				boundary = EXCLUDED.boundary, updated_at = now()`,
+			cityID, level, string(payload),
+		).Tx(),
+	).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to import districts: %w", err)
+	}
+
+	return s.AssignStreets(ctx, cityID)
+}
+
+// AssignStreets splits the city's streets across its districts by length, so a street running
+// through several districts counts towards each for the part inside it. It runs after every
+// district or street import and returns how many district streets were stored.
+func (s *DistrictService) AssignStreets(ctx context.Context, cityID string) (int, error) {
+	districts, err := s.client.District.FindMany(
+		db.District.CityID.Equals(cityID),
+	).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load districts: %w", err)
+	}
+
+	// districts of one level do not overlap, but a neighborhood lies inside a district
+	boundaries := make(map[string][]utils.CityBoundary)
+	for _, district := range districts {
+		polygons, err := utils.ParseBoundaryGeoJSON(district.Boundary)
+		if err != nil {
+			fmt.Printf("Warning: skipping boundary of district %s: %v\n", district.ID, err)
+			continue
+		}
+		boundaries[district.Level] = append(boundaries[district.Level], utils.CityBoundary{CityID: district.ID, Polygons: polygons})
+	}
+	locators := make([]*utils.CityLocator, 0, len(boundaries))
+	for _, levelBoundaries := range boundaries {
+		locators = append(locators, utils.NewCityLocator(levelBoundaries))
+	}
+
+	var segments []struct {
+		StreetID db.RawString    `json:"street_id"`
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT seg.street_id, seg.geometry
+		FROM street_segments seg
+		JOIN streets st ON st.id = seg.street_id
+		WHERE st.city_id = $1`,
+		cityID,
+	).Exec(ctx, &segments)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load street segments: %w", err)
+	}
+
+	lengths := make(map[[2]string]float64)
+	var order [][2]string
+	for _, segment := range segments {
+		line, err := utils.ParseLineStringGeometry(segment.Geometry)
+		if err != nil {
+			fmt.Printf("Warning: skipping segment of street %s: %v\n", segment.StreetID, err)
+			continue
+		}
+		for _, locator := range locators {
+			for districtID, meters := range locator.LengthWithin(line) {
+				key := [2]string{districtID, string(segment.StreetID)}
+				if _, ok := lengths[key]; !ok {
+					order = append(order, key)
+				}
+				lengths[key] += meters
+			}
+		}
+	}
+
+	txs := []db.PrismaTransaction{
+		s.client.Prisma.ExecuteRaw(`
+			DELETE FROM district_streets
+			WHERE district_id IN (SELECT id FROM districts WHERE city_id = $1)`,
+			cityID,
+		).Tx(),
+	}
+	for start := 0; start < len(order); start += streetImportChunk {
+		end := min(start+streetImportChunk, len(order))
+		rows := make([]districtStreetRow, 0, end-start)
+		for _, key := range order[start:end] {
+			rows = append(rows, districtStreetRow{DistrictID: key[0], StreetID: key[1], LengthMeters: lengths[key]})
+		}
+		payload, err := json.Marshal(rows)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode district streets: %w", err)
+		}
+		txs = append(txs, s.client.Prisma.ExecuteRaw(`
+			INSERT INTO district_streets (id, district_id, street_id, length_meters)
+			SELECT gen_random_uuid()::text, district_id, street_id, length_meters
+			FROM jsonb_to_recordset($1::jsonb) AS x(district_id text, street_id text, length_meters float8)`,
+			string(payload),
+		).Tx())
+	}
+	txs = append(txs, s.client.Prisma.ExecuteRaw(`
+		UPDATE districts d SET
+			street_count = (SELECT COUNT(*) FROM district_streets ds WHERE ds.district_id = d.id),
+			total_length_meters = (SELECT COALESCE(SUM(ds.length_meters), 0) FROM district_streets ds WHERE ds.district_id = d.id),
+			updated_at = now()
+		WHERE d.city_id = $1`,
+		cityID,
+	).Tx())
+
+	if err := s.client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to store district streets: %w", err)
+	}
+
+	return len(order), nil
+}
+
+// Coverage returns the user's coverage of every district of a city, the user's current city when
+// cityID is empty, optionally limited to one level. The nearest incomplete district is measured
+// from ref, or from the user's latest visit in the city when ref is nil, and is only suggested
+// with personalization consent.
+func (s *DistrictService) Coverage(ctx context.Context, clerkUserID, cityID, level string, ref *utils.LatLng) (*types.DistrictCoverageResponse, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentCityStatDataUsage); err != nil {
+		return nil, err
+	}
+
+	if cityID == "" {
+		user, err := s.client.User.FindUnique(
+			db.User.ID.Equals(clerkUserID),
+		).Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		current, ok := user.CurrentCityID()
+		if !ok {
+			return nil, fmt.Errorf("no current city, pass a cityId")
+		}
+		cityID = current
+	}
+
+	// the suggestion draws on the user's history, so it needs personalization consent
+	personalized, err := s.consentService.Allowed(ctx, clerkUserID, types.ConsentPersonalization)
+	if err != nil {
+		return nil, err
+	}
+	if !personalized {
+		ref = nil
+	} else if ref == nil {
+		var latest []struct {
+			Lat db.RawFloat `json:"lat"`
+			Lng db.RawFloat `json:"lng"`
+		}
+		err := s.client.Prisma.QueryRaw(`
+			SELECT entry_latitude::float8 AS lat, entry_longitude::float8 AS lng
+			FROM visited_streets
+			WHERE user_id = $1 AND city_id = $2 AND NOT flagged AND entry_latitude IS NOT NULL
+			ORDER BY entry_timestamp DESC
+			LIMIT 1`,
+			clerkUserID, cityID,
+		).Exec(ctx, &latest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load latest visit: %w", err)
+		}
+		if len(latest) > 0 {
+			ref = &utils.LatLng{Lat: float64(latest[0].Lat), Lng: float64(latest[0].Lng)}
+		}
+	}
+
+	var rows []struct {
+		ID            db.RawString    `json:"id"`
+		Name          db.RawString    `json:"name"`
+		Level         db.RawString    `json:"level"`
+		StreetCount   db.RawInt       `json:"street_count"`
+		StreetsWalked db.RawInt       `json:"streets_walked"`
+		TotalMeters   db.RawFloat     `json:"total_meters"`
+		WalkedMeters  db.RawFloat     `json:"walked_meters"`
+		Boundary      json.RawMessage `json:"boundary"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT d.id, d.name, d.level, d.street_count, d.boundary,
+			d.total_length_meters::float8 AS total_meters,
+			COUNT(w.street_id)::int AS streets_walked,
+			COALESCE(SUM(ds.length_meters) FILTER (WHERE w.street_id IS NOT NULL), 0)::float8 AS walked_meters
+		FROM districts d
+		LEFT JOIN district_streets ds ON ds.district_id = d.id
+		LEFT JOIN (
+			SELECT DISTINCT street_id FROM visited_streets WHERE user_id = $1 AND NOT flagged
+		) w ON w.street_id = ds.street_id
+		WHERE d.city_id = $2 AND ($3 = '' OR d.level = $3)
+		GROUP BY d.id
+		ORDER BY d.level, d.name`,
+		clerkUserID, cityID, level,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute district coverage: %w", err)
+	}
+
+	response := &types.DistrictCoverageResponse{
+		CityID:    cityID,
+		Districts: make([]types.DistrictCoverage, 0, len(rows)),
+	}
+	nearest := -1
+	for _, row := range rows {
+		coverage := types.DistrictCoverage{
+			ID:               string(row.ID),
+			Name:             string(row.Name),
+			Level:            string(row.Level),
+			StreetCount:      int(row.StreetCount),
+			StreetsWalked:    int(row.StreetsWalked),
+			TotalKilometers:  float64(row.TotalMeters) / 1000,
+			WalkedKilometers: float64(row.WalkedMeters) / 1000,
+			CoveragePct:      utils.CoveragePercent(float64(row.WalkedMeters), float64(row.TotalMeters)),
+		}
+		if ref != nil {
+			polygons, err := utils.ParseBoundaryGeoJSON(row.Boundary)
+			if err != nil {
+				fmt.Printf("Warning: invalid boundary of district %s: %v\n", row.ID, err)
+			} else {
+				distance := math.Round(utils.PolygonsDistanceMeters(polygons, *ref))
+				coverage.DistanceMeters = &distance
+			}
+		}
+		response.Districts = append(response.Districts, coverage)
+
+		i := len(response.Districts) - 1
+		if coverage.DistanceMeters == nil || coverage.StreetCount == 0 || coverage.CoveragePct >= 100 {
+			continue
+		}
+		if nearest < 0 || *coverage.DistanceMeters < *response.Districts[nearest].DistanceMeters {
+			nearest = i
+		}
+	}
+	if nearest >= 0 {
+		response.NearestIncomplete = &response.Districts[nearest]
+	}
+
+	return response, nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// gpxSegmentGapMillis starts a new GPX track segment when the walk paused for longer than this
+const gpxSegmentGapMillis = 5 * 60 * 1000
+
+type ExportService struct {
+	client         *db.PrismaClient
+	consentService *ConsentService
+}
+
+func NewExportService(client *db.PrismaClient, consentService *ConsentService) *ExportService {
+	return &ExportService{client: client, consentService: consentService}
+}
+
+// WalkedStreetsGeoJSON returns one feature per street the user walked. Streets from the imported
+// network carry their geometry, less the stretches inside the user's privacy zones; other streets, and
+// imported ones lying wholly inside a zone, fall back to the point where they were first entered.
+func (s *ExportService) WalkedStreetsGeoJSON(ctx context.Context, clerkUserID string, filter types.ExportFilter) (*types.GeoJSONFeatureCollection, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentLocationTracking); err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"v.user_id = $1", "NOT v.flagged"}
+	params := []interface{}{clerkUserID}
+	addCondition := func(condition string, value interface{}) {
+		params = append(params, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(params)))
+	}
+	if filter.From != nil {
+		addCondition("v.entry_timestamp >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("v.entry_timestamp < $%d", *filter.To)
+	}
+	if filter.SessionID != "" {
+		addCondition("v.session_id = $%d", filter.SessionID)
+	}
+	if filter.CityID != "" {
+		addCondition("v.city_id = $%d", filter.CityID)
+	}
+
+	var rows []struct {
+		StreetID     db.RawString    `json:"street_id"`
+		StreetName   db.RawString    `json:"street_name"`
+		CityID       *db.RawString   `json:"city_id"`
+		FirstVisit   db.RawBigInt    `json:"first_visit"`
+		LastVisit    db.RawBigInt    `json:"last_visit"`
+		VisitCount   db.RawInt       `json:"visit_count"`
+		TotalSeconds db.RawInt       `json:"total_seconds"`
+		Latitude     *db.RawFloat    `json:"latitude"`
+		Longitude    *db.RawFloat    `json:"longitude"`
+		Geometry     json.RawMessage `json:"geometry"`
+	}
+	query := fmt.Sprintf(`
+		SELECT v.street_id,
+			COALESCE(MAX(st.name), (array_agg(v.street_name ORDER BY v.entry_timestamp DESC))[1]) AS street_name,
+			MAX(v.city_id) AS city_id,
+			MIN(v.entry_timestamp) AS first_visit,
+			MAX(COALESCE(v.exit_timestamp, v.entry_timestamp)) AS last_visit,
+			COUNT(*)::int AS visit_count,
+			COALESCE(SUM(GREATEST(COALESCE(v.duration_seconds, (v.exit_timestamp - v.entry_timestamp) / 1000, 0), 0)), 0)::int AS total_seconds,
+			((array_agg(v.entry_latitude ORDER BY v.entry_timestamp) FILTER (WHERE v.entry_latitude IS NOT NULL))[1])::float8 AS latitude,
+			((array_agg(v.entry_longitude ORDER BY v.entry_timestamp) FILTER (WHERE v.entry_latitude IS NOT NULL))[1])::float8 AS longitude,
+			(array_agg(st.geometry))[1] AS geometry
+		FROM visited_streets v
+		LEFT JOIN streets st ON st.id = v.street_id
+		WHERE %s
+		GROUP BY v.street_id
+		ORDER BY first_visit`,
+		strings.Join(conditions, " AND "),
+	)
+	if err := s.client.Prisma.QueryRaw(query, params...).Exec(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to load walked streets: %w", err)
+	}
+
+	zones, err := loadPrivacyZones(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &types.GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]types.GeoJSONFeature, 0, len(rows)),
+	}
+	for _, row := range rows {
+		geometry := row.Geometry
+		if len(geometry) > 0 && string(geometry) != "null" {
+			if geometry, err = clipStreetGeometry(zones, geometry); err != nil {
+				return nil, fmt.Errorf("failed to clip street %s: %w", row.StreetID, err)
+			}
+		}
+		if (len(geometry) == 0 || string(geometry) == "null") && row.Latitude != nil && row.Longitude != nil {
+			point, err := json.Marshal(map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{float64(*row.Longitude), float64(*row.Latitude)},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode geometry: %w", err)
+			}
+			geometry = point
+		} else if len(geometry) == 0 {
+			// unimported streets whose entry points the retention job removed have no location left
+			geometry = json.RawMessage("null")
+		}
+
+		properties := types.WalkedStreetProperties{
+			StreetID:     string(row.StreetID),
+			StreetName:   string(row.StreetName),
+			FirstVisit:   time.UnixMilli(int64(row.FirstVisit)).UTC().Format(time.RFC3339),
+			LastVisit:    time.UnixMilli(int64(row.LastVisit)).UTC().Format(time.RFC3339),
+			VisitCount:   int(row.VisitCount),
+			TotalSeconds: int(row.TotalSeconds),
+		}
+		if row.CityID != nil {
+			cityID := string(*row.CityID)
+			properties.CityID = &cityID
+		}
+
+		collection.Features = append(collection.Features, types.GeoJSONFeature{
+			Type:       "Feature",
+			ID:         string(row.StreetID),
+			Geometry:   geometry,
+			Properties: properties,
+		})
+	}
+
+	return collection, nil
+}
+
+// SessionGPX renders a session's visited streets as a GPX 1.1 track with one point per street entry
+func (s *ExportService) SessionGPX(ctx context.Context, clerkUserID, sessionID string) ([]byte, error) {
+	session, err := s.client.WalkSession.FindFirst(
+		db.WalkSession.ID.Equals(sessionID),
+		db.WalkSession.UserID.Equals(clerkUserID),
+	).With(
+		db.WalkSession.VisitedStreets.Fetch(
+			db.VisitedStreet.Flagged.Equals(false),
+		).OrderBy(
+			db.VisitedStreet.EntryTimestamp.Order(db.ASC),
+		),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	var segments [][]utils.GPXPoint
+	var current []utils.GPXPoint
+	var lastTimestamp int64
+	for _, visit := range session.VisitedStreets() {
+		entry := int64(visit.EntryTimestamp)
+		if len(current) > 0 && entry-lastTimestamp > gpxSegmentGapMillis {
+			segments = append(segments, current)
+			current = nil
+		}
+		point, ok := visitEntryPoint(&visit)
+		if !ok {
+			// the retention job removed the entry point
+			continue
+		}
+		current = append(current, utils.GPXPoint{
+			Lat:  point.Lat,
+			Lng:  point.Lng,
+			Time: time.UnixMilli(entry),
+			Name: visit.StreetName,
+		})
+		lastTimestamp = max(lastTimestamp, entry)
+		if exit, ok := visit.ExitTimestamp(); ok {
+			lastTimestamp = max(lastTimestamp, int64(exit))
+		}
+	}
+	if len(current) > 0 {
+		segments = append(segments, current)
+	}
+
+	name := "Walk on " + session.StartedAt.UTC().Format("2006-01-02 15:04")
+	var buf bytes.Buffer
+	if err := utils.WriteGPX(&buf, name, session.StartedAt, segments); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
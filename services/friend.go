@@ -3,17 +3,20 @@ package services
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"citystatAPI/prisma/db"
 	"citystatAPI/types"
 )
 
 type FriendService struct {
-	client *db.PrismaClient
+	client             *db.PrismaClient
+	achievementService *AchievementService
+	xpService          *XpService
 }
 
-func NewFriendService(client *db.PrismaClient) *FriendService {
-	return &FriendService{client: client}
+func NewFriendService(client *db.PrismaClient, achievementService *AchievementService, xpService *XpService) *FriendService {
+	return &FriendService{client: client, achievementService: achievementService, xpService: xpService}
 }
 
 
@@ -131,23 +134,82 @@ func (s *FriendService) GetUserFriends(ctx context.Context, userID string) ([]ty
 	}
 
 	results := make([]types.FriendResult, len(friends))
-	for i, friend := range friends {
-		fn, _ := friend.FirstName()
-		ln, _ := friend.LastName()
-		imageURL, _ := friend.ImageURL()
-		results[i] = types.FriendResult{
-			ID:        friend.ID,
-			FriendID:  friend.FriendID,
-			UserName:  friend.UserName,
-			FirstName: &fn,
-			LastName:  &ln,
-			ImageURL:  &imageURL,
-			CreatedAt: friend.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		}
+	for i := range friends {
+		results[i] = toFriendResult(&friends[i])
 	}
 
 	return results, nil
 }
+
+// GetFriendProfile returns the profile of one of the user's friends with the badges they earned and
+// their level
+func (s *FriendService) GetFriendProfile(ctx context.Context, userID, friendID string) (*types.GetFriendProfileResponse, error) {
+	_, err := s.client.Friend.FindFirst(
+		db.Friend.UserID.Equals(userID),
+		db.Friend.FriendID.Equals(friendID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, fmt.Errorf("friendship not found")
+		}
+		return nil, fmt.Errorf("failed to check friendship: %w", err)
+	}
+
+	friend, err := s.client.User.FindUnique(
+		db.User.ID.Equals(friendID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get friend: %w", err)
+	}
+
+	achievements, err := s.achievementService.Earned(ctx, friendID)
+	if err != nil {
+		return nil, err
+	}
+	level, err := s.xpService.Level(ctx, friend)
+	if err != nil {
+		return nil, err
+	}
+	nameColor, err := s.xpService.NameColor(ctx, userID, level)
+	if err != nil {
+		return nil, err
+	}
+
+	userName, _ := friend.UserName()
+	firstName, _ := friend.FirstName()
+	lastName, _ := friend.LastName()
+	return &types.GetFriendProfileResponse{
+		ID:           friend.ID,
+		UserName:     userName,
+		FirstName:    &firstName,
+		LastName:     &lastName,
+		ImageURL:     &friend.ImageURL,
+		CreatedAt:    friend.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:    friend.UpdatedAt.Format(time.RFC3339),
+		Achievements: achievements.Achievements,
+		Level:        level,
+		NameColor:    nameColor,
+	}, nil
+}
+
+func toFriendResult(friend *db.FriendModel) types.FriendResult {
+	fn, _ := friend.FirstName()
+	ln, _ := friend.LastName()
+	imageURL, _ := friend.ImageURL()
+	return types.FriendResult{
+		ID:        friend.ID,
+		FriendID:  friend.FriendID,
+		UserName:  friend.UserName,
+		FirstName: &fn,
+		LastName:  &ln,
+		ImageURL:  &imageURL,
+		CreatedAt: friend.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
 func (s *FriendService) RemoveFriend(ctx context.Context, userID, friendID string) error {
 	// Remove the friendship using DeleteMany
 	result, err := s.client.Friend.FindMany(
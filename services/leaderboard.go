@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+type LeaderboardService struct {
+	client *db.PrismaClient
+	// mu keeps the scheduled ranking job and manual runs from replacing the same boards at once
+	mu sync.Mutex
+}
+
+func NewLeaderboardService(client *db.PrismaClient) *LeaderboardService {
+	return &LeaderboardService{client: client}
+}
+
+// FriendLeaderboard ranks the caller and their friends. Coverage is measured in cityID, which
+// defaults to the caller's current city; the other metrics cover all cities unless cityID is set.
+func (s *LeaderboardService) FriendLeaderboard(ctx context.Context, clerkUserID, metric, period string, cityID *string) (*types.LeaderboardResponse, error) {
+	if !validLeaderboardMetric(metric) {
+		return nil, fmt.Errorf("invalid leaderboard: metric must be streets, kilometers, coverage or newStreets")
+	}
+	since, err := s.periodStart(ctx, clerkUserID, period)
+	if err != nil {
+		return nil, err
+	}
+
+	if metric == types.LeaderboardMetricCoverage && cityID == nil {
+		user, err := s.client.User.FindUnique(
+			db.User.ID.Equals(clerkUserID),
+		).Exec(ctx)
+		if err != nil && err != db.ErrNotFound {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		if user != nil {
+			if current, ok := user.CurrentCityID(); ok {
+				cityID = &current
+			}
+		}
+		if cityID == nil {
+			return nil, fmt.Errorf("invalid leaderboard: coverage needs a cityId")
+		}
+	}
+
+	friends, err := s.client.Friend.FindMany(
+		db.Friend.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get friends: %w", err)
+	}
+	userIDs := []string{clerkUserID}
+	for _, friend := range friends {
+		userIDs = append(userIDs, friend.FriendID)
+	}
+
+	values, err := leaderboardValues(ctx, s.client, metric, leaderboardFilter{
+		UserIDs: userIDs,
+		Since:   since,
+		CityID:  cityID,
+		Viewer:  &clerkUserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.client.User.FindMany(
+		db.User.ID.In(userIDs),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leaderboard users: %w", err)
+	}
+
+	entries := make([]types.LeaderboardEntry, 0, len(values))
+	for _, user := range users {
+		if value, ok := values[user.ID]; ok {
+			entries = append(entries, toLeaderboardEntry(&user, value, clerkUserID))
+		}
+	}
+	rankLeaderboard(entries)
+
+	response := &types.LeaderboardResponse{
+		Metric:  metric,
+		Period:  period,
+		CityID:  cityID,
+		Entries: entries,
+	}
+	if since > 0 {
+		formatted := time.UnixMilli(since).UTC().Format(time.RFC3339)
+		response.Since = &formatted
+	}
+	for _, entry := range entries {
+		if entry.IsCaller {
+			rank := entry.Rank
+			response.CallerRank = &rank
+		}
+	}
+
+	return response, nil
+}
+
+// periodStart returns the unix milliseconds the period started at in the user's time zone, 0 for all time
+func (s *LeaderboardService) periodStart(ctx context.Context, clerkUserID, period string) (int64, error) {
+	if err := validLeaderboardPeriod(period); err != nil {
+		return 0, err
+	}
+	loc, err := userLocation(ctx, s.client, clerkUserID)
+	if err != nil {
+		return 0, err
+	}
+	return leaderboardPeriodStart(period, time.Now(), loc), nil
+}
+
+func validLeaderboardPeriod(period string) error {
+	switch period {
+	case types.LeaderboardPeriodWeek, types.LeaderboardPeriodMonth, types.LeaderboardPeriodAll:
+		return nil
+	}
+	return fmt.Errorf("invalid leaderboard: period must be week, month or all")
+}
+
+// leaderboardPeriodStart returns the unix milliseconds the period containing now started at in loc,
+// 0 for all time
+func leaderboardPeriodStart(period string, now time.Time, loc *time.Location) int64 {
+	switch period {
+	case types.LeaderboardPeriodWeek:
+		return utils.BucketStart(now, utils.IntervalWeek, loc).UnixMilli()
+	case types.LeaderboardPeriodMonth:
+		return utils.BucketStart(now, utils.IntervalMonth, loc).UnixMilli()
+	}
+	return 0
+}
+
+func validLeaderboardMetric(metric string) bool {
+	switch metric {
+	case types.LeaderboardMetricStreets, types.LeaderboardMetricKilometers,
+		types.LeaderboardMetricCoverage, types.LeaderboardMetricNewStreets:
+		return true
+	}
+	return false
+}
+
+func toLeaderboardEntry(user *db.UserModel, value float64, clerkUserID string) types.LeaderboardEntry {
+	entry := types.LeaderboardEntry{
+		UserID:   user.ID,
+		ImageURL: user.ImageURL,
+		Value:    value,
+		IsCaller: user.ID == clerkUserID,
+	}
+	if userName, ok := user.UserName(); ok {
+		entry.UserName = &userName
+	}
+	if firstName, ok := user.FirstName(); ok {
+		entry.FirstName = &firstName
+	}
+	if lastName, ok := user.LastName(); ok {
+		entry.LastName = &lastName
+	}
+	return entry
+}
+
+// rankLeaderboard sorts entries by value and gives equal values the same rank
+func rankLeaderboard(entries []types.LeaderboardEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+}
+
+// leaderboardFilter selects the users and visits leaderboardValues measures
+type leaderboardFilter struct {
+	// UserIDs limits it to these users, nil measures everyone
+	UserIDs []string
+	// Since and Until bound the visits counted, in unix milliseconds; an Until of 0 leaves it open
+	Since int64
+	Until int64
+	// CityID limits it to the visits in one city
+	CityID *string
+	// Viewer is whose board it is: users whose profile visibility hides them from the viewer are left
+	// out. A nil Viewer is the public, who only sees public profiles.
+	Viewer *string
+	// AnyVisibility skips the profile visibility check, for boards users joined themselves
+	AnyVisibility bool
+}
+
+// leaderboardValues measures users on a leaderboard metric from their visits that are not flagged,
+// within the filter. Users who turned off city stat data usage, and disabled or deleted accounts,
+// are left out.
+func leaderboardValues(ctx context.Context, client *db.PrismaClient, metric string, filter leaderboardFilter) (map[string]float64, error) {
+	scopes, err := leaderboardScopeValues(ctx, client, metric, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	return scopes[types.LeaderboardScopeGlobal], nil
+}
+
+// leaderboardScopeValues measures users like leaderboardValues, keyed by scope: the global scope holds
+// the values over all visits within the filter and, when byCity is set, each city id holds the values
+// over the visits in that city, all from one pass over the visits. Coverage is a share of one city, so
+// with byCity it only has city scopes.
+func leaderboardScopeValues(ctx context.Context, client *db.PrismaClient, metric string, filter leaderboardFilter, byCity bool) (map[string]map[string]float64, error) {
+	var ids *string
+	if filter.UserIDs != nil {
+		payload, err := json.Marshal(filter.UserIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode leaderboard users: %w", err)
+		}
+		encoded := string(payload)
+		ids = &encoded
+	}
+
+	// $1 users, $2 since, $3 city, $4 viewer, $5 until, $6 any visibility
+	participants := `
+			WITH participants AS (
+				SELECT u.id FROM users u
+				LEFT JOIN settings s ON s."userId" = u.id
+				WHERE ($1::jsonb IS NULL OR u.id IN (SELECT jsonb_array_elements_text($1::jsonb)))
+					AND COALESCE(s."allowCityStatDataUsage", true)
+					AND NOT u."disableAccount" AND NOT u."deleteAccount"
+					AND ($6::boolean OR u.id = $4::text OR COALESCE(s."profileVisibility"::text, 'PUBLIC') IN
+						('PUBLIC', CASE WHEN $4::text IS NULL THEN 'PUBLIC' ELSE 'FRIENDS' END))
+			)`
+	// a street's first visit, for new streets and coverage
+	firstVisits := `
+			first_visits AS (
+				SELECT v.user_id, v.street_id, MAX(v.city_id) AS city_id, MIN(v.entry_timestamp) AS first_entry
+				FROM visited_streets v
+				WHERE v.user_id IN (SELECT id FROM participants) AND NOT v.flagged
+				GROUP BY v.user_id, v.street_id
+			)`
+	// grouped returns the scope columns and the grouping of a query whose visits carry their city in
+	// cityColumn; with byCity every user gets a row for all cities and one per city
+	grouped := func(cityColumn string) (string, string) {
+		if !byCity {
+			return `NULL::text AS city_id, true AS all_cities`, `GROUP BY p.id`
+		}
+		return cityColumn + ` AS city_id, GROUPING(` + cityColumn + `) = 1 AS all_cities`,
+			`GROUP BY GROUPING SETS ((p.id), (p.id, ` + cityColumn + `))`
+	}
+
+	var query string
+	switch metric {
+	case types.LeaderboardMetricStreets:
+		scope, groupBy := grouped("v.city_id")
+		query = participants + `
+			SELECT p.id AS user_id, ` + scope + `, COUNT(DISTINCT v.street_id)::float8 AS value
+			FROM participants p
+			LEFT JOIN visited_streets v ON v.user_id = p.id AND NOT v.flagged
+				AND v.entry_timestamp >= $2 AND ($5::bigint = 0 OR v.entry_timestamp < $5) AND ($3::text IS NULL OR v.city_id = $3)
+			` + groupBy
+	case types.LeaderboardMetricNewStreets:
+		scope, groupBy := grouped("f.city_id")
+		query = participants + `, ` + firstVisits + `
+			SELECT p.id AS user_id, ` + scope + `, COUNT(f.street_id)::float8 AS value
+			FROM participants p
+			LEFT JOIN first_visits f ON f.user_id = p.id AND f.first_entry >= $2 AND ($5::bigint = 0 OR f.first_entry < $5)
+				AND ($3::text IS NULL OR f.city_id = $3)
+			` + groupBy
+	case types.LeaderboardMetricCoverage:
+		// a street counts with its full length, as in the city stats
+		if byCity {
+			query = participants + `, ` + firstVisits + `
+			SELECT p.id AS user_id, c.id AS city_id, false AS all_cities,
+				SUM(st.length_meters)::float8 / NULLIF(MAX(c.total_length_meters), 0) * 100 AS value
+			FROM participants p
+			JOIN first_visits f ON f.user_id = p.id AND f.first_entry >= $2 AND ($5::bigint = 0 OR f.first_entry < $5)
+			JOIN streets st ON st.id = f.street_id
+			JOIN cities c ON c.id = st.city_id
+			WHERE $3::text IS NULL OR c.id = $3
+			GROUP BY p.id, c.id`
+			break
+		}
+		query = participants + `, ` + firstVisits + `
+			SELECT p.id AS user_id, NULL::text AS city_id, true AS all_cities,
+				COALESCE(SUM(st.length_meters), 0)::float8 / NULLIF(MAX(c.total_length_meters), 0) * 100 AS value
+			FROM participants p
+			CROSS JOIN cities c
+			LEFT JOIN first_visits f ON f.user_id = p.id AND f.first_entry >= $2 AND ($5::bigint = 0 OR f.first_entry < $5)
+			LEFT JOIN streets st ON st.id = f.street_id AND st.city_id = c.id
+			WHERE c.id = $3
+			GROUP BY p.id`
+	case types.LeaderboardMetricKilometers:
+		// legs as in the street walks: each one counts for the visit it starts at, redacted points add
+		// nothing, and legs frozen by the retention job keep their distance. The haversine radius
+		// matches utils.HaversineMeters.
+		scope, groupBy := grouped("l.city_id")
+		query = participants + `,
+		legs AS (
+			SELECT v.user_id, v.city_id, v.entry_timestamp, v.leg_meters,
+				v.entry_latitude::float8 AS lat, v.entry_longitude::float8 AS lng,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_latitude::float8) OVER w END AS next_lat,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_longitude::float8) OVER w END AS next_lng
+			FROM visited_streets v
+			WHERE v.user_id IN (SELECT id FROM participants) AND NOT v.flagged
+			WINDOW w AS (PARTITION BY v.session_id ORDER BY v.entry_timestamp, v.id)
+		)
+			SELECT p.id AS user_id, ` + scope + `, COALESCE(SUM(COALESCE(l.leg_meters,
+				2 * 6371008.8 * asin(LEAST(1, sqrt(
+					power(sin(radians(l.next_lat - l.lat) / 2), 2) +
+					cos(radians(l.lat)) * cos(radians(l.next_lat)) * power(sin(radians(l.next_lng - l.lng) / 2), 2)
+				)))
+			)), 0)::float8 / 1000 AS value
+			FROM participants p
+			LEFT JOIN legs l ON l.user_id = p.id AND l.entry_timestamp >= $2 AND ($5::bigint = 0 OR l.entry_timestamp < $5)
+				AND ($3::text IS NULL OR l.city_id = $3)
+			` + groupBy
+	default:
+		return nil, fmt.Errorf("unknown leaderboard metric %q", metric)
+	}
+
+	var rows []struct {
+		UserID    db.RawString  `json:"user_id"`
+		CityID    *db.RawString `json:"city_id"`
+		AllCities db.RawBoolean `json:"all_cities"`
+		Value     *db.RawFloat  `json:"value"`
+	}
+	if err := client.Prisma.QueryRaw(strings.TrimSpace(query), ids, filter.Since, filter.CityID, filter.Viewer, filter.Until, filter.AnyVisibility).Exec(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to compute leaderboard: %w", err)
+	}
+
+	scopes := make(map[string]map[string]float64)
+	if !byCity || metric != types.LeaderboardMetricCoverage {
+		scopes[types.LeaderboardScopeGlobal] = make(map[string]float64, len(rows))
+	}
+	for _, row := range rows {
+		scope := types.LeaderboardScopeGlobal
+		if !bool(row.AllCities) {
+			// users without visits in the filter have a city row without a city
+			if row.CityID == nil {
+				continue
+			}
+			scope = string(*row.CityID)
+		}
+		if scopes[scope] == nil {
+			scopes[scope] = make(map[string]float64)
+		}
+		value := 0.0
+		if row.Value != nil {
+			value = float64(*row.Value)
+		}
+		scopes[scope][string(row.UserID)] = value
+	}
+	return scopes, nil
+}
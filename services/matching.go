@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// MaxTrackPoints caps a single raw track upload
+const MaxTrackPoints = 20000
+
+// MaxImportTrackPoints caps an imported GPX or KML file, which may hold a long history
+const MaxImportTrackPoints = 200000
+
+var (
+	// ErrStreetGraphNotLoaded is returned while no street graph is available for matching
+	ErrStreetGraphNotLoaded = errors.New("street graph not loaded")
+	// ErrEmptyTrack is returned for track files without any track points
+	ErrEmptyTrack = errors.New("track has no points")
+	// ErrTrackTooLong is returned for track files over MaxImportTrackPoints
+	ErrTrackTooLong = fmt.Errorf("track has more than %d points", MaxImportTrackPoints)
+)
+
+// MatchingService snaps raw GPS tracks onto an in-memory street graph and stores the result
+// as visited streets, exactly as if the client had posted them.
+type MatchingService struct {
+	visitorService *VisitorService
+	sessionService *SessionService
+
+	mu    sync.RWMutex
+	graph *utils.StreetGraph
+}
+
+func NewMatchingService(visitorService *VisitorService, sessionService *SessionService) *MatchingService {
+	return &MatchingService{visitorService: visitorService, sessionService: sessionService}
+}
+
+// LoadGraphFile replaces the street graph with the streets of a GeoJSON file
+func (s *MatchingService) LoadGraphFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open street graph: %w", err)
+	}
+	defer file.Close()
+
+	streets, err := utils.ParseStreetsGeoJSON(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse street graph: %w", err)
+	}
+
+	graph := utils.NewStreetGraph(streets)
+	s.SetGraph(graph)
+
+	return graph.Len(), nil
+}
+
+// SetGraph replaces the street graph used for matching
+func (s *MatchingService) SetGraph(graph *utils.StreetGraph) {
+	s.mu.Lock()
+	s.graph = graph
+	s.mu.Unlock()
+}
+
+func (s *MatchingService) currentGraph() (*utils.StreetGraph, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.graph == nil {
+		return nil, ErrStreetGraphNotLoaded
+	}
+	return s.graph, nil
+}
+
+// MatchPoints snaps points onto streets and returns them as visited street entries
+func (s *MatchingService) MatchPoints(points []utils.TrackPoint) ([]types.VisitedStreetRequest, error) {
+	graph, err := s.currentGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	visits := utils.MatchTrack(graph, points)
+	streets := make([]types.VisitedStreetRequest, len(visits))
+	for i, visit := range visits {
+		exit := visit.ExitTimestamp
+		duration := int((visit.ExitTimestamp - visit.EntryTimestamp) / 1000)
+		streets[i] = types.VisitedStreetRequest{
+			StreetID:        visit.StreetID,
+			StreetName:      visit.StreetName,
+			EntryTimestamp:  visit.EntryTimestamp,
+			ExitTimestamp:   &exit,
+			DurationSeconds: &duration,
+			EntryLatitude:   visit.Entry.Lat,
+			EntryLongitude:  visit.Entry.Lng,
+		}
+	}
+
+	return streets, nil
+}
+
+// SaveTrack matches a raw GPS track and ingests the matched streets into the given session
+func (s *MatchingService) SaveTrack(ctx context.Context, clerkUserID string, req types.SaveTrackRequest) (*types.SaveTrackResponse, error) {
+	points := make([]utils.TrackPoint, len(req.Points))
+	for i, p := range req.Points {
+		points[i] = utils.TrackPoint{
+			Lat:       p.Latitude,
+			Lng:       p.Longitude,
+			Timestamp: p.Timestamp,
+			Accuracy:  p.Accuracy,
+			Speed:     p.Speed,
+		}
+	}
+
+	streets, err := s.MatchPoints(points)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := s.saveMatchedStreets(ctx, clerkUserID, req.SessionID, streets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SaveTrackResponse{
+		PointsReceived: len(req.Points),
+		StreetsMatched: len(streets),
+		Streets:        *saved,
+	}, nil
+}
+
+// saveMatchedStreets ingests matched streets in batches of MaxVisitedStreetsBatch, since a long
+// track can match more streets than one batch takes. Results are indexed across all batches. Each
+// batch is written atomically on its own; saving again after a failure only reports the earlier
+// batches as duplicates.
+func (s *MatchingService) saveMatchedStreets(ctx context.Context, clerkUserID, sessionID string, streets []types.VisitedStreetRequest) (*types.SaveVisitedStreetsResponse, error) {
+	response := &types.SaveVisitedStreetsResponse{
+		SessionID: sessionID,
+		Results:   make([]types.VisitedStreetResult, 0, len(streets)),
+	}
+	for start := 0; ; start += MaxVisitedStreetsBatch {
+		end := min(start+MaxVisitedStreetsBatch, len(streets))
+		saved, err := s.visitorService.SaveVisitedStreets(ctx, clerkUserID, types.SaveVisitedStreetsRequest{
+			SessionID:      sessionID,
+			VisitedStreets: streets[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		response.Inserted += saved.Inserted
+		response.Duplicates += saved.Duplicates
+		response.Rejected += saved.Rejected
+		response.Flagged += saved.Flagged
+		for _, result := range saved.Results {
+			result.Index += start
+			response.Results = append(response.Results, result)
+		}
+		if end == len(streets) {
+			return response, nil
+		}
+	}
+}
+
+// Track file formats accepted by ImportTrackFile
+const (
+	TrackFormatGPX = "gpx"
+	TrackFormatKML = "kml"
+)
+
+// ImportTrackFile matches a GPX or KML file into a completed session of its own. The session id is
+// derived from the user and the file contents, so uploading the same file again only reports duplicates.
+func (s *MatchingService) ImportTrackFile(ctx context.Context, clerkUserID, format string, data []byte) (*types.ImportTrackResponse, error) {
+	var points []utils.TrackPoint
+	var err error
+	switch format {
+	case TrackFormatGPX:
+		points, err = utils.ParseGPX(bytes.NewReader(data))
+	case TrackFormatKML:
+		points, err = utils.ParseKML(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", utils.ErrInvalidTrackFile, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, ErrEmptyTrack
+	}
+	if len(points) > MaxImportTrackPoints {
+		return nil, ErrTrackTooLong
+	}
+
+	streets, err := s.MatchPoints(points)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := points[0].Timestamp, points[0].Timestamp
+	for _, p := range points {
+		first = min(first, p.Timestamp)
+		last = max(last, p.Timestamp)
+	}
+
+	// the same file imported by different users must land in different sessions
+	hash := sha256.New()
+	hash.Write([]byte(clerkUserID))
+	hash.Write([]byte{0})
+	hash.Write(data)
+	digest := hash.Sum(nil)
+	sessionID := "import-" + hex.EncodeToString(digest[:10])
+	device := "import:" + format
+	if _, err := s.sessionService.StartSession(ctx, clerkUserID, types.StartSessionRequest{
+		SessionID: &sessionID,
+		Device:    &device,
+		StartedAt: &first,
+	}); err != nil {
+		return nil, err
+	}
+
+	kept, recorded, err := s.visitorService.FilterRecordedVisits(ctx, clerkUserID, sessionID, streets)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := s.saveMatchedStreets(ctx, clerkUserID, sessionID, kept)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessionService.StopSession(ctx, clerkUserID, sessionID, types.StopSessionRequest{EndedAt: &last})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.ImportTrackResponse{
+		SessionID:       sessionID,
+		Format:          format,
+		PointsRead:      len(points),
+		StreetsMatched:  len(streets),
+		AlreadyRecorded: recorded,
+		Streets:         *saved,
+		Session:         session,
+	}, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+)
+
+type ModerationService struct {
+	client         *db.PrismaClient
+	statsService   *StatsService
+	sessionService *SessionService
+}
+
+func NewModerationService(client *db.PrismaClient, statsService *StatsService, sessionService *SessionService) *ModerationService {
+	return &ModerationService{client: client, statsService: statsService, sessionService: sessionService}
+}
+
+// ListFlags returns movement flags with the given status, oldest first so the review queue is worked in order
+func (s *ModerationService) ListFlags(ctx context.Context, status db.FlagStatus, limit, offset int) ([]types.MovementFlagResult, error) {
+	flags, err := s.client.MovementFlag.FindMany(
+		db.MovementFlag.Status.Equals(status),
+	).With(
+		db.MovementFlag.VisitedStreet.Fetch(),
+	).OrderBy(
+		db.MovementFlag.CreatedAt.Order(db.ASC),
+	).Skip(offset).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list movement flags: %w", err)
+	}
+
+	results := make([]types.MovementFlagResult, len(flags))
+	for i := range flags {
+		results[i] = toMovementFlagResult(&flags[i])
+	}
+
+	return results, nil
+}
+
+// ResolveFlag records a moderator's decision. Dismissing the last open flag of a visit puts the
+// visit back into the user's stats.
+func (s *ModerationService) ResolveFlag(ctx context.Context, moderatorID, flagID string, req types.ResolveFlagRequest) (*types.MovementFlagResult, error) {
+	var status db.FlagStatus
+	switch req.Action {
+	case types.FlagActionConfirm:
+		status = db.FlagStatusConfirmed
+	case types.FlagActionDismiss:
+		status = db.FlagStatusDismissed
+	default:
+		return nil, fmt.Errorf("invalid action %q, expected confirm or dismiss", req.Action)
+	}
+
+	flag, err := s.client.MovementFlag.FindUnique(
+		db.MovementFlag.ID.Equals(flagID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, fmt.Errorf("flag not found")
+		}
+		return nil, fmt.Errorf("failed to get flag: %w", err)
+	}
+	if flag.Status != db.FlagStatusPending {
+		return nil, fmt.Errorf("flag already resolved")
+	}
+
+	// only a pending flag is updated, so of two moderators resolving it at once one gets the error
+	updated, err := s.client.MovementFlag.FindMany(
+		db.MovementFlag.ID.Equals(flagID),
+		db.MovementFlag.Status.Equals(db.FlagStatusPending),
+	).Update(
+		db.MovementFlag.Status.Set(status),
+		db.MovementFlag.ReviewedBy.Set(moderatorID),
+		db.MovementFlag.ReviewedAt.Set(time.Now()),
+		db.MovementFlag.ReviewNote.SetIfPresent(req.Note),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve flag: %w", err)
+	}
+	if updated.Count == 0 {
+		return nil, fmt.Errorf("flag already resolved")
+	}
+
+	if status == db.FlagStatusDismissed {
+		if err := s.restoreVisitIfCleared(ctx, flag); err != nil {
+			return nil, err
+		}
+	}
+
+	resolved, err := s.client.MovementFlag.FindUnique(
+		db.MovementFlag.ID.Equals(flagID),
+	).With(
+		db.MovementFlag.VisitedStreet.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flag: %w", err)
+	}
+
+	result := toMovementFlagResult(resolved)
+	return &result, nil
+}
+
+func (s *ModerationService) restoreVisitIfCleared(ctx context.Context, flag *db.MovementFlagModel) error {
+	open, err := s.client.MovementFlag.FindMany(
+		db.MovementFlag.VisitedStreetID.Equals(flag.VisitedStreetID),
+		db.MovementFlag.Status.In([]db.FlagStatus{db.FlagStatusPending, db.FlagStatusConfirmed}),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check remaining flags: %w", err)
+	}
+	if len(open) > 0 {
+		return nil
+	}
+
+	_, err = s.client.VisitedStreet.FindUnique(
+		db.VisitedStreet.ID.Equals(flag.VisitedStreetID),
+	).Update(
+		db.VisitedStreet.Flagged.Set(false),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to restore visit: %w", err)
+	}
+
+	if _, err := s.statsService.RefreshCityStats(ctx, flag.UserID); err != nil {
+		fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+	}
+	if _, err := s.sessionService.RefreshTotals(ctx, flag.UserID, flag.SessionID); err != nil {
+		fmt.Printf("Warning: failed to refresh session totals: %v\n", err)
+	}
+
+	return nil
+}
+
+func toMovementFlagResult(flag *db.MovementFlagModel) types.MovementFlagResult {
+	visit := flag.VisitedStreet()
+	result := types.MovementFlagResult{
+		ID:              flag.ID,
+		UserID:          flag.UserID,
+		SessionID:       flag.SessionID,
+		VisitedStreetID: flag.VisitedStreetID,
+		StreetID:        visit.StreetID,
+		StreetName:      visit.StreetName,
+		EntryTimestamp:  int64(visit.EntryTimestamp),
+		Reason:          flag.Reason,
+		Status:          string(flag.Status),
+		CreatedAt:       flag.CreatedAt.Format(time.RFC3339),
+	}
+	if speed, ok := flag.SpeedMps(); ok {
+		result.SpeedMps = &speed
+	}
+	if distance, ok := flag.DistanceMeters(); ok {
+		result.DistanceMeters = &distance
+	}
+	if reviewedBy, ok := flag.ReviewedBy(); ok {
+		result.ReviewedBy = &reviewedBy
+	}
+	if reviewedAt, ok := flag.ReviewedAt(); ok {
+		formatted := reviewedAt.Format(time.RFC3339)
+		result.ReviewedAt = &formatted
+	}
+	if note, ok := flag.ReviewNote(); ok {
+		result.ReviewNote = &note
+	}
+
+	return result
+}
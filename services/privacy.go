@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+const (
+	// MaxPrivacyZones caps the zones of one user
+	MaxPrivacyZones = 10
+	// MinPrivacyZoneRadius keeps circles large enough that their coarsened points do not cluster on the spot
+	MinPrivacyZoneRadius = 100
+	MaxPrivacyZoneRadius = 5000
+	// redactChunk is how many stored visits are coarsened per UPDATE when a zone is added
+	redactChunk = 5000
+)
+
+type PrivacyService struct {
+	client         *db.PrismaClient
+	statsService   *StatsService
+	sessionService *SessionService
+}
+
+func NewPrivacyService(client *db.PrismaClient, statsService *StatsService, sessionService *SessionService) *PrivacyService {
+	return &PrivacyService{client: client, statsService: statsService, sessionService: sessionService}
+}
+
+func (s *PrivacyService) ListZones(ctx context.Context, clerkUserID string) ([]types.PrivacyZoneResult, error) {
+	zones, err := s.client.PrivacyZone.FindMany(
+		db.PrivacyZone.UserID.Equals(clerkUserID),
+	).OrderBy(
+		db.PrivacyZone.CreatedAt.Order(db.ASC),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list privacy zones: %w", err)
+	}
+
+	results := make([]types.PrivacyZoneResult, len(zones))
+	for i := range zones {
+		results[i] = toPrivacyZoneResult(&zones[i])
+	}
+
+	return results, nil
+}
+
+// CreateZone adds a zone and coarsens the user's stored visits inside it. Coarsening cannot be
+// undone, so deleting the zone later only affects visits stored after that.
+func (s *PrivacyService) CreateZone(ctx context.Context, clerkUserID string, req types.CreatePrivacyZoneRequest) (*types.CreatePrivacyZoneResponse, error) {
+	params := []db.PrivacyZoneSetParam{
+		db.PrivacyZone.Name.Set(strings.TrimSpace(req.Name)),
+	}
+	circle := req.Latitude != nil || req.Longitude != nil || req.RadiusMeters != nil
+	switch {
+	case circle && len(req.Polygon) > 0:
+		return nil, fmt.Errorf("invalid zone: give either a circle or a polygon")
+	case circle:
+		if req.Latitude == nil || req.Longitude == nil || req.RadiusMeters == nil {
+			return nil, fmt.Errorf("invalid zone: a circle needs latitude, longitude and radiusMeters")
+		}
+		if *req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180 {
+			return nil, fmt.Errorf("invalid zone: coordinates out of range")
+		}
+		if *req.RadiusMeters < MinPrivacyZoneRadius || *req.RadiusMeters > MaxPrivacyZoneRadius {
+			return nil, fmt.Errorf("invalid zone: radiusMeters must be between %d and %d", MinPrivacyZoneRadius, MaxPrivacyZoneRadius)
+		}
+		params = append(params,
+			db.PrivacyZone.CenterLat.Set(*req.Latitude),
+			db.PrivacyZone.CenterLng.Set(*req.Longitude),
+			db.PrivacyZone.RadiusMeters.Set(*req.RadiusMeters),
+		)
+	case len(req.Polygon) > 0:
+		polygons, err := utils.ParseBoundaryGeoJSON(req.Polygon)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone: %w", err)
+		}
+		for _, polygon := range polygons {
+			if len(polygon) == 0 || len(polygon[0]) < 4 {
+				return nil, fmt.Errorf("invalid zone: a polygon ring needs at least 4 positions")
+			}
+		}
+		geometry, err := utils.BoundaryGeometry(polygons)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode zone polygon: %w", err)
+		}
+		params = append(params, db.PrivacyZone.Polygon.Set(db.JSON(geometry)))
+	default:
+		return nil, fmt.Errorf("invalid zone: give a circle or a polygon")
+	}
+
+	existing, err := s.client.PrivacyZone.FindMany(
+		db.PrivacyZone.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count privacy zones: %w", err)
+	}
+	if len(existing) >= MaxPrivacyZones {
+		return nil, fmt.Errorf("invalid zone: at most %d privacy zones are allowed", MaxPrivacyZones)
+	}
+
+	zone, err := s.client.PrivacyZone.CreateOne(
+		db.PrivacyZone.User.Link(db.User.ID.Equals(clerkUserID)),
+		params...,
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create privacy zone: %w", err)
+	}
+
+	redacted, err := s.redactStoredVisits(ctx, clerkUserID, []utils.PrivacyZone{toUtilsPrivacyZone(zone)})
+	if err != nil {
+		return nil, err
+	}
+	s.refreshStreetWalks(ctx, clerkUserID)
+
+	return &types.CreatePrivacyZoneResponse{
+		Zone:           toPrivacyZoneResult(zone),
+		RedactedVisits: redacted,
+	}, nil
+}
+
+func (s *PrivacyService) DeleteZone(ctx context.Context, clerkUserID, zoneID string) error {
+	zone, err := s.client.PrivacyZone.FindFirst(
+		db.PrivacyZone.ID.Equals(zoneID),
+		db.PrivacyZone.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return fmt.Errorf("privacy zone not found")
+		}
+		return fmt.Errorf("failed to get privacy zone: %w", err)
+	}
+
+	_, err = s.client.PrivacyZone.FindUnique(
+		db.PrivacyZone.ID.Equals(zone.ID),
+	).Delete().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete privacy zone: %w", err)
+	}
+	s.refreshStreetWalks(ctx, clerkUserID)
+
+	return nil
+}
+
+// refreshStreetWalks rebuilds the user's city stats, whose street walks are drawn clipped to the
+// zones and from entry points a new zone may have coarsened. A failed refresh is repaired by a recompute.
+func (s *PrivacyService) refreshStreetWalks(ctx context.Context, clerkUserID string) {
+	if _, err := s.statsService.RefreshCityStats(ctx, clerkUserID); err != nil {
+		fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+	}
+}
+
+// Zones returns the user's zones for checking coordinates at ingest
+func (s *PrivacyService) Zones(ctx context.Context, clerkUserID string) ([]utils.PrivacyZone, error) {
+	return loadPrivacyZones(ctx, s.client, clerkUserID)
+}
+
+// loadPrivacyZones reads a user's zones. Services drawing street geometry use it directly, since the
+// privacy service itself depends on the stats service.
+func loadPrivacyZones(ctx context.Context, client *db.PrismaClient, clerkUserID string) ([]utils.PrivacyZone, error) {
+	zones, err := client.PrivacyZone.FindMany(
+		db.PrivacyZone.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load privacy zones: %w", err)
+	}
+
+	result := make([]utils.PrivacyZone, len(zones))
+	for i := range zones {
+		result[i] = toUtilsPrivacyZone(&zones[i])
+	}
+
+	return result, nil
+}
+
+// clipStreetGeometry cuts the stretches inside the zones out of a LineString or MultiLineString
+// geometry. It returns nil when the whole street lies inside the zones, and the geometry as it was
+// when no zone touches it.
+func clipStreetGeometry(zones []utils.PrivacyZone, geometry json.RawMessage) (json.RawMessage, error) {
+	if len(zones) == 0 {
+		return geometry, nil
+	}
+	lines, err := utils.ParseLinesGeometry(geometry)
+	if err != nil {
+		return nil, err
+	}
+	lines, clipped := utils.ClipPrivacyZones(zones, lines)
+	if !clipped {
+		return geometry, nil
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	multi := make([][][]float64, len(lines))
+	for i, line := range lines {
+		multi[i] = make([][]float64, len(line))
+		for j, p := range line {
+			multi[i][j] = []float64{p.Lng, p.Lat}
+		}
+	}
+	clippedGeometry, err := json.Marshal(map[string]interface{}{"type": "MultiLineString", "coordinates": multi})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode street geometry: %w", err)
+	}
+	return clippedGeometry, nil
+}
+
+// redactStoredVisits coarsens the user's precise visits inside the zones and refreshes the sessions
+// built from them
+func (s *PrivacyService) redactStoredVisits(ctx context.Context, clerkUserID string, zones []utils.PrivacyZone) (int, error) {
+	var visits []struct {
+		ID        db.RawString `json:"id"`
+		SessionID db.RawString `json:"session_id"`
+		Lat       db.RawFloat  `json:"lat"`
+		Lng       db.RawFloat  `json:"lng"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT id, session_id, entry_latitude::float8 AS lat, entry_longitude::float8 AS lng
+		FROM visited_streets
+		WHERE user_id = $1 AND NOT redacted AND entry_latitude IS NOT NULL`,
+		clerkUserID,
+	).Exec(ctx, &visits)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load visits: %w", err)
+	}
+
+	type redactedRow struct {
+		ID  string  `json:"id"`
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}
+	var rows []redactedRow
+	sessions := make(map[string]bool)
+	for _, visit := range visits {
+		p := utils.LatLng{Lat: float64(visit.Lat), Lng: float64(visit.Lng)}
+		if !utils.InPrivacyZone(zones, p) {
+			continue
+		}
+		coarse := utils.CoarsenLatLng(p)
+		rows = append(rows, redactedRow{ID: string(visit.ID), Lat: coarse.Lat, Lng: coarse.Lng})
+		sessions[string(visit.SessionID)] = true
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	for start := 0; start < len(rows); start += redactChunk {
+		end := min(start+redactChunk, len(rows))
+		payload, err := json.Marshal(rows[start:end])
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode redacted visits: %w", err)
+		}
+		_, err = s.client.Prisma.ExecuteRaw(`
+			UPDATE visited_streets v SET entry_latitude = x.lat, entry_longitude = x.lng, redacted = true
+			FROM jsonb_to_recordset($2::jsonb) AS x(id text, lat numeric, lng numeric)
+			WHERE v.id = x.id AND v.user_id = $1`,
+			clerkUserID, string(payload),
+		).Exec(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to redact visits: %w", err)
+		}
+	}
+
+	for sessionID := range sessions {
+		if _, err := s.sessionService.RefreshTotals(ctx, clerkUserID, sessionID); err != nil {
+			fmt.Printf("Warning: failed to refresh session totals: %v\n", err)
+		}
+	}
+
+	return len(rows), nil
+}
+
+func toUtilsPrivacyZone(zone *db.PrivacyZoneModel) utils.PrivacyZone {
+	var result utils.PrivacyZone
+	if radius, ok := zone.RadiusMeters(); ok {
+		lat, _ := zone.CenterLat()
+		lng, _ := zone.CenterLng()
+		result.Center = utils.LatLng{Lat: lat, Lng: lng}
+		result.RadiusMeters = radius
+	}
+	if raw, ok := zone.Polygon(); ok {
+		polygons, err := utils.ParseBoundaryGeoJSON(raw)
+		if err != nil {
+			fmt.Printf("Warning: invalid polygon of privacy zone %s: %v\n", zone.ID, err)
+		}
+		result.Polygons = polygons
+	}
+	return result
+}
+
+func toPrivacyZoneResult(zone *db.PrivacyZoneModel) types.PrivacyZoneResult {
+	result := types.PrivacyZoneResult{
+		ID:        zone.ID,
+		Name:      zone.Name,
+		Shape:     types.PrivacyZonePolygon,
+		CreatedAt: zone.CreatedAt.Format(time.RFC3339),
+	}
+	if radius, ok := zone.RadiusMeters(); ok {
+		lat, _ := zone.CenterLat()
+		lng, _ := zone.CenterLng()
+		result.Shape = types.PrivacyZoneCircle
+		result.Latitude = &lat
+		result.Longitude = &lng
+		result.RadiusMeters = &radius
+	}
+	if raw, ok := zone.Polygon(); ok {
+		result.Polygon = json.RawMessage(raw)
+	}
+	return result
+}
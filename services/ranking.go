@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+)
+
+// RankingInterval is how often city and global leaderboards are ranked
+const RankingInterval = 15 * time.Minute
+
+// rankedMetrics are the metrics city and global leaderboards are ranked by. Coverage is a share of
+// one city's streets, so it is only ranked per city.
+var rankedMetrics = []string{
+	types.LeaderboardMetricStreets,
+	types.LeaderboardMetricKilometers,
+	types.LeaderboardMetricNewStreets,
+	types.LeaderboardMetricCoverage,
+}
+
+var rankedPeriods = []string{
+	types.LeaderboardPeriodWeek,
+	types.LeaderboardPeriodMonth,
+	types.LeaderboardPeriodAll,
+}
+
+// RankLeaderboards materializes the global leaderboard and those of every city with visits, for
+// every metric and period. Each metric and period is measured in one pass grouped by city, from which
+// the global board and the city boards are taken. Only users with public profiles who allow city stat
+// data usage are ranked, and only once they have a value above zero. Boards of a metric and period
+// that fails to rank keep their previous ranks.
+func (s *LeaderboardService) RankLeaderboards(ctx context.Context) (*types.LeaderboardRankingResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &types.LeaderboardRankingResult{}
+	now := time.Now()
+	for _, metric := range rankedMetrics {
+		for _, period := range rankedPeriods {
+			since := leaderboardPeriodStart(period, now, time.UTC)
+			scopes, err := leaderboardScopeValues(ctx, s.client, metric, leaderboardFilter{Since: since}, true)
+			if err != nil {
+				fmt.Printf("Warning: failed to rank leaderboards %s/%s: %v\n", metric, period, err)
+				continue
+			}
+
+			// a board that fails to store keeps its previous ranks
+			ranked := make([]string, 0, len(scopes))
+			for scope, values := range scopes {
+				ranked = append(ranked, scope)
+				entries, err := s.rankBoard(ctx, scope, metric, period, values, since, now)
+				if err != nil {
+					fmt.Printf("Warning: failed to rank %s leaderboard %s/%s: %v\n", scope, metric, period, err)
+					continue
+				}
+				result.Boards++
+				result.Entries += entries
+			}
+
+			// cities nobody walked in during the period
+			payload, err := json.Marshal(ranked)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode leaderboard scopes: %w", err)
+			}
+			_, err = s.client.Prisma.ExecuteRaw(`
+				DELETE FROM leaderboard_ranks
+				WHERE metric = $1 AND period = $2 AND scope NOT IN (SELECT jsonb_array_elements_text($3::jsonb))`,
+				metric, period, string(payload),
+			).Exec(ctx)
+			if err != nil {
+				fmt.Printf("Warning: failed to clear empty leaderboards %s/%s: %v\n", metric, period, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// rankBoard replaces the ranks of one board with the users' values and returns how many users are on it
+func (s *LeaderboardService) rankBoard(ctx context.Context, scope, metric, period string, values map[string]float64, since int64, now time.Time) (int, error) {
+	entries := make([]types.LeaderboardEntry, 0, len(values))
+	for userID, value := range values {
+		if value > 0 {
+			entries = append(entries, types.LeaderboardEntry{UserID: userID, Value: value})
+		}
+	}
+	rankLeaderboard(entries)
+
+	type rankRow struct {
+		UserID string  `json:"user_id"`
+		Rank   int     `json:"rank"`
+		Value  float64 `json:"value"`
+	}
+	rows := make([]rankRow, len(entries))
+	for i, entry := range entries {
+		rows[i] = rankRow{UserID: entry.UserID, Rank: entry.Rank, Value: entry.Value}
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode leaderboard ranks: %w", err)
+	}
+
+	err = s.client.Prisma.Transaction(
+		s.client.Prisma.ExecuteRaw(`
+			DELETE FROM leaderboard_ranks WHERE scope = $1 AND metric = $2 AND period = $3`,
+			scope, metric, period,
+		).Tx(),
+		s.client.Prisma.ExecuteRaw(`
+			INSERT INTO leaderboard_ranks (id, scope, metric, period, user_id, rank, value, period_start, computed_at)
+			SELECT gen_random_uuid()::text, $1, $2, $3, user_id, rank, value, $5, $6
+			FROM jsonb_to_recordset($4::jsonb) AS x(user_id text, rank int, value float8)`,
+			scope, metric, period, string(payload), since, now,
+		).Tx(),
+	).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store leaderboard ranks: %w", err)
+	}
+
+	return len(rows), nil
+}
+
+// RankedLeaderboard returns a page of the ranked leaderboard of cityID, or the global one when cityID
+// is nil, along with the caller's own entry. Ranks are as of the last ranking run, so changes to a
+// user's visits, consent or profile visibility show once the board is ranked again.
+func (s *LeaderboardService) RankedLeaderboard(ctx context.Context, clerkUserID, metric, period string, cityID *string, limit, offset int) (*types.RankedLeaderboardResponse, error) {
+	if !validLeaderboardMetric(metric) {
+		return nil, fmt.Errorf("invalid leaderboard: metric must be streets, kilometers, coverage or newStreets")
+	}
+	if err := validLeaderboardPeriod(period); err != nil {
+		return nil, err
+	}
+	scope := types.LeaderboardScopeGlobal
+	if cityID != nil {
+		scope = *cityID
+	} else if metric == types.LeaderboardMetricCoverage {
+		return nil, fmt.Errorf("invalid leaderboard: coverage is only ranked per city")
+	}
+
+	board := []db.LeaderboardRankWhereParam{
+		db.LeaderboardRank.Scope.Equals(scope),
+		db.LeaderboardRank.Metric.Equals(metric),
+		db.LeaderboardRank.Period.Equals(period),
+	}
+	ranks, err := s.client.LeaderboardRank.FindMany(board...).OrderBy(
+		db.LeaderboardRank.Rank.Order(db.ASC),
+		db.LeaderboardRank.UserID.Order(db.ASC),
+	).Skip(offset).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load leaderboard: %w", err)
+	}
+	caller, err := s.client.LeaderboardRank.FindFirst(
+		append(board, db.LeaderboardRank.UserID.Equals(clerkUserID))...,
+	).Exec(ctx)
+	if err != nil && err != db.ErrNotFound {
+		return nil, fmt.Errorf("failed to load caller rank: %w", err)
+	}
+
+	var boardRows []struct {
+		Total      db.RawInt       `json:"total"`
+		ComputedAt *db.RawDateTime `json:"computed_at"`
+		Since      *db.RawBigInt   `json:"since"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT COUNT(*)::int AS total, MAX(computed_at) AS computed_at, MAX(period_start) AS since
+		FROM leaderboard_ranks WHERE scope = $1 AND metric = $2 AND period = $3`,
+		scope, metric, period,
+	).Exec(ctx, &boardRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load leaderboard size: %w", err)
+	}
+
+	userIDs := make([]string, 0, len(ranks)+1)
+	for _, rank := range ranks {
+		userIDs = append(userIDs, rank.UserID)
+	}
+	if caller != nil {
+		userIDs = append(userIDs, clerkUserID)
+	}
+	users, err := s.client.User.FindMany(
+		db.User.ID.In(userIDs),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leaderboard users: %w", err)
+	}
+	byID := make(map[string]*db.UserModel, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+	toEntry := func(rank *db.LeaderboardRankModel) types.LeaderboardEntry {
+		entry := types.LeaderboardEntry{UserID: rank.UserID, Value: rank.Value, IsCaller: rank.UserID == clerkUserID}
+		if user, ok := byID[rank.UserID]; ok {
+			entry = toLeaderboardEntry(user, rank.Value, clerkUserID)
+		}
+		entry.Rank = rank.Rank
+		return entry
+	}
+
+	response := &types.RankedLeaderboardResponse{
+		Metric:  metric,
+		Period:  period,
+		CityID:  cityID,
+		Limit:   limit,
+		Offset:  offset,
+		Entries: make([]types.LeaderboardEntry, len(ranks)),
+	}
+	for i := range ranks {
+		response.Entries[i] = toEntry(&ranks[i])
+	}
+	if caller != nil {
+		entry := toEntry(caller)
+		response.Caller = &entry
+	}
+	if len(boardRows) > 0 {
+		response.Total = int(boardRows[0].Total)
+		if computedAt := boardRows[0].ComputedAt; computedAt != nil {
+			formatted := computedAt.Time.UTC().Format(time.RFC3339)
+			response.ComputedAt = &formatted
+		}
+		if since := boardRows[0].Since; since != nil && *since > 0 {
+			formatted := time.UnixMilli(int64(*since)).UTC().Format(time.RFC3339)
+			response.Since = &formatted
+		}
+	}
+
+	return response, nil
+}
+
+// RunEvery ranks the city and global leaderboards every interval until ctx is done
+func (s *LeaderboardService) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RankLeaderboards(ctx); err != nil {
+			fmt.Printf("Warning: leaderboard ranking failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
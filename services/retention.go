@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+const (
+	// DefaultCoarsenDays and DefaultPurgeDays apply when no retention policy is configured
+	DefaultCoarsenDays = 30
+	DefaultPurgeDays   = 365
+	// RetentionInterval is how often the retention job runs
+	RetentionInterval = 6 * time.Hour
+	// retentionChunk is how many visits one pass of the retention job reduces
+	retentionChunk = 5000
+	dayMillis      = int64(24 * time.Hour / time.Millisecond)
+)
+
+// RetentionPolicy is how long precise coordinates of raw visits are kept. After CoarsenDays the
+// entry points are snapped to the privacy grid, after PurgeDays they are removed. The visit itself,
+// and so every street-level stat built from it, is kept.
+type RetentionPolicy struct {
+	CoarsenDays int
+	PurgeDays   int
+}
+
+// forUser applies a user's shorter retention to the policy
+func (p RetentionPolicy) forUser(retentionDays *int) RetentionPolicy {
+	if retentionDays == nil {
+		return p
+	}
+	return RetentionPolicy{CoarsenDays: min(p.CoarsenDays, *retentionDays), PurgeDays: min(p.PurgeDays, *retentionDays)}
+}
+
+type RetentionService struct {
+	client       *db.PrismaClient
+	statsService *StatsService
+	policy       RetentionPolicy
+	// mu keeps the scheduled job and manual runs from working on the same rows at once
+	mu sync.Mutex
+}
+
+func NewRetentionService(client *db.PrismaClient, statsService *StatsService, policy RetentionPolicy) *RetentionService {
+	return &RetentionService{client: client, statsService: statsService, policy: policy}
+}
+
+// GetRetention returns the retention that applies to the user
+func (s *RetentionService) GetRetention(ctx context.Context, clerkUserID string) (*types.RetentionResponse, error) {
+	settings, err := s.client.Settings.FindUnique(
+		db.Settings.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil && err != db.ErrNotFound {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	var retentionDays *int
+	if settings != nil {
+		if days, ok := settings.LocationRetentionDays(); ok {
+			retentionDays = &days
+		}
+	}
+	return s.toRetentionResponse(retentionDays), nil
+}
+
+// SetRetention stores the user's retention and applies it to their stored visits right away
+func (s *RetentionService) SetRetention(ctx context.Context, clerkUserID string, retentionDays *int) (*types.RetentionResponse, error) {
+	if retentionDays != nil && (*retentionDays < 1 || *retentionDays > s.policy.PurgeDays) {
+		return nil, fmt.Errorf("invalid retention: retentionDays must be between 1 and %d", s.policy.PurgeDays)
+	}
+
+	_, err := s.client.Settings.UpsertOne(
+		db.Settings.UserID.Equals(clerkUserID),
+	).Create(
+		db.Settings.User.Link(db.User.ID.Equals(clerkUserID)),
+		db.Settings.LocationRetentionDays.SetOptional(retentionDays),
+	).Update(
+		db.Settings.LocationRetentionDays.SetOptional(retentionDays),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update retention: %w", err)
+	}
+
+	if _, err := s.Run(ctx, clerkUserID); err != nil {
+		// the scheduled job picks the visits up on its next run
+		fmt.Printf("Warning: failed to apply retention: %v\n", err)
+	}
+
+	return s.toRetentionResponse(retentionDays), nil
+}
+
+// Run coarsens and purges every visit past its retention, or only the given user's when
+// clerkUserID is set
+func (s *RetentionService) Run(ctx context.Context, clerkUserID string) (*types.RetentionRunResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &types.RetentionRunResult{}
+	now := time.Now().UnixMilli()
+
+	users := make(map[string]bool)
+	for {
+		coarsened, err := s.coarsenBatch(ctx, clerkUserID, now, users)
+		if err != nil {
+			return nil, err
+		}
+		result.CoarsenedVisits += coarsened
+		if coarsened < retentionChunk {
+			break
+		}
+	}
+
+	// street walks off the imported network are drawn at an entry point, which has to be redrawn coarsened
+	for userID := range users {
+		if _, err := s.statsService.RefreshCityStats(ctx, userID); err != nil {
+			fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+		}
+	}
+
+	for {
+		purged, err := s.client.Prisma.ExecuteRaw(`
+			UPDATE visited_streets SET entry_latitude = NULL, entry_longitude = NULL
+			WHERE id IN (
+				SELECT v.id
+				FROM visited_streets v
+				LEFT JOIN settings s ON s."userId" = v.user_id
+				WHERE v.leg_meters IS NOT NULL AND v.entry_latitude IS NOT NULL
+					AND ($2::text = '' OR v.user_id = $2)
+					AND v.entry_timestamp < $1 - LEAST($3, COALESCE(s."locationRetentionDays", $3))::bigint * $4
+				LIMIT $5
+			)`,
+			now, clerkUserID, s.policy.PurgeDays, dayMillis, retentionChunk,
+		).Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge visits: %w", err)
+		}
+		result.PurgedVisits += purged.Count
+		if purged.Count < retentionChunk {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// coarsenBatch coarsens the oldest visits past their coarsening age. The distance from each to the
+// next visit of its session is frozen first, so stats and session totals keep their values.
+func (s *RetentionService) coarsenBatch(ctx context.Context, clerkUserID string, now int64, users map[string]bool) (int, error) {
+	var visits []struct {
+		ID      db.RawString `json:"id"`
+		UserID  db.RawString `json:"user_id"`
+		Lat     db.RawFloat  `json:"lat"`
+		Lng     db.RawFloat  `json:"lng"`
+		NextLat *db.RawFloat `json:"next_lat"`
+		NextLng *db.RawFloat `json:"next_lng"`
+	}
+	// legs are taken over the visits stats count; flagged visits walk nothing. Visits are handled
+	// oldest first, so the next visit of a due one is never coarsened before it.
+	err := s.client.Prisma.QueryRaw(`
+		WITH due AS (
+			SELECT v.id, v.session_id
+			FROM visited_streets v
+			LEFT JOIN settings s ON s."userId" = v.user_id
+			WHERE v.leg_meters IS NULL AND v.entry_latitude IS NOT NULL
+				AND ($2::text = '' OR v.user_id = $2)
+				AND v.entry_timestamp < $1 - LEAST($3, COALESCE(s."locationRetentionDays", $3))::bigint * $4
+			ORDER BY v.entry_timestamp
+			LIMIT $5
+		), legs AS (
+			SELECT v.id, v.user_id, v.entry_latitude::float8 AS lat, v.entry_longitude::float8 AS lng,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_latitude::float8) OVER w END AS next_lat,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_longitude::float8) OVER w END AS next_lng
+			FROM visited_streets v
+			WHERE v.session_id IN (SELECT session_id FROM due) AND NOT v.flagged
+			WINDOW w AS (PARTITION BY v.session_id ORDER BY v.entry_timestamp, v.id)
+		)
+		SELECT l.id, l.user_id, l.lat, l.lng, l.next_lat, l.next_lng
+		FROM legs l WHERE l.id IN (SELECT id FROM due)
+		UNION ALL
+		SELECT v.id, v.user_id, v.entry_latitude::float8, v.entry_longitude::float8, NULL, NULL
+		FROM visited_streets v WHERE v.flagged AND v.id IN (SELECT id FROM due)`,
+		now, clerkUserID, s.policy.CoarsenDays, dayMillis, retentionChunk,
+	).Exec(ctx, &visits)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load visits past retention: %w", err)
+	}
+	if len(visits) == 0 {
+		return 0, nil
+	}
+
+	type coarsenedRow struct {
+		ID        string  `json:"id"`
+		Lat       float64 `json:"lat"`
+		Lng       float64 `json:"lng"`
+		LegMeters float64 `json:"leg_meters"`
+	}
+	rows := make([]coarsenedRow, len(visits))
+	for i, visit := range visits {
+		from := utils.LatLng{Lat: float64(visit.Lat), Lng: float64(visit.Lng)}
+		coarse := utils.CoarsenLatLng(from)
+		rows[i] = coarsenedRow{ID: string(visit.ID), Lat: coarse.Lat, Lng: coarse.Lng}
+		if visit.NextLat != nil && visit.NextLng != nil {
+			rows[i].LegMeters = utils.HaversineMeters(from, utils.LatLng{Lat: float64(*visit.NextLat), Lng: float64(*visit.NextLng)})
+		}
+		users[string(visit.UserID)] = true
+	}
+
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode coarsened visits: %w", err)
+	}
+	_, err = s.client.Prisma.ExecuteRaw(`
+		UPDATE visited_streets v
+		SET entry_latitude = x.lat, entry_longitude = x.lng, leg_meters = x.leg_meters, redacted = true
+		FROM jsonb_to_recordset($1::jsonb) AS x(id text, lat numeric, lng numeric, leg_meters float8)
+		WHERE v.id = x.id`,
+		string(payload),
+	).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to coarsen visits: %w", err)
+	}
+
+	return len(rows), nil
+}
+
+// RunEvery runs the retention job for all users every interval until ctx is done
+func (s *RetentionService) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := s.Run(ctx, "")
+		if err != nil {
+			fmt.Printf("Warning: retention run failed: %v\n", err)
+		} else if result.CoarsenedVisits > 0 || result.PurgedVisits > 0 {
+			fmt.Printf("Retention: coarsened %d and purged %d visits\n", result.CoarsenedVisits, result.PurgedVisits)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *RetentionService) toRetentionResponse(retentionDays *int) *types.RetentionResponse {
+	effective := s.policy.forUser(retentionDays)
+	return &types.RetentionResponse{
+		RetentionDays:    retentionDays,
+		CoarsenAfterDays: effective.CoarsenDays,
+		PurgeAfterDays:   effective.PurgeDays,
+		DefaultPurgeDays: s.policy.PurgeDays,
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// ErrSessionNotFound is returned for sessions that do not exist and for sessions of other users, so
+// callers cannot tell whether someone else's session id exists
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionEndBeforeStart is returned when a session is stopped at a time before it started
+var ErrSessionEndBeforeStart = errors.New("endedAt is before the session start")
+
+type SessionService struct {
+	client         *db.PrismaClient
+	statsService   *StatsService
+	consentService *ConsentService
+}
+
+func NewSessionService(client *db.PrismaClient, statsService *StatsService, consentService *ConsentService) *SessionService {
+	return &SessionService{client: client, statsService: statsService, consentService: consentService}
+}
+
+// StartSession opens a new walk session. Starting a session id the caller already owns returns it unchanged,
+// so offline clients can safely retry. The device is only kept with analytics consent.
+func (s *SessionService) StartSession(ctx context.Context, clerkUserID string, req types.StartSessionRequest) (*types.WalkSessionResult, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentLocationTracking); err != nil {
+		return nil, err
+	}
+
+	if req.SessionID != nil && *req.SessionID != "" {
+		existing, err := s.client.WalkSession.FindUnique(
+			db.WalkSession.ID.Equals(*req.SessionID),
+		).Exec(ctx)
+		if err != nil && err != db.ErrNotFound {
+			return nil, fmt.Errorf("failed to check existing session: %w", err)
+		}
+		if existing != nil {
+			if existing.UserID != clerkUserID {
+				return nil, ErrSessionNotFound
+			}
+			return toWalkSessionResult(existing), nil
+		}
+	}
+
+	startedAt := time.Now()
+	if req.StartedAt != nil {
+		startedAt = time.UnixMilli(*req.StartedAt)
+	}
+
+	analytics, err := s.consentService.Allowed(ctx, clerkUserID, types.ConsentAnalytics)
+	if err != nil {
+		return nil, err
+	}
+	optionalParams := []db.WalkSessionSetParam{
+		db.WalkSession.StartedAt.Set(startedAt),
+	}
+	if analytics {
+		optionalParams = append(optionalParams, db.WalkSession.Device.SetIfPresent(req.Device))
+	}
+	if req.SessionID != nil && *req.SessionID != "" {
+		optionalParams = append(optionalParams, db.WalkSession.ID.Set(*req.SessionID))
+	}
+
+	session, err := s.client.WalkSession.CreateOne(
+		db.WalkSession.User.Link(db.User.ID.Equals(clerkUserID)),
+		optionalParams...,
+	).Exec(ctx)
+	if err != nil {
+		// a concurrent retry with the same session id may have created it first
+		if _, isUnique := db.IsErrUniqueConstraint(err); isUnique && req.SessionID != nil && *req.SessionID != "" {
+			return s.StartSession(ctx, clerkUserID, req)
+		}
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+
+	return toWalkSessionResult(session), nil
+}
+
+// StopSession completes a session, computes its final totals and awards what the completed walk earns
+func (s *SessionService) StopSession(ctx context.Context, clerkUserID, sessionID string, req types.StopSessionRequest) (*types.WalkSessionResult, error) {
+	session, err := s.findOwnedSession(ctx, clerkUserID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Status != db.SessionStatusCompleted {
+		endedAt := time.Now()
+		if req.EndedAt != nil {
+			endedAt = time.UnixMilli(*req.EndedAt)
+		}
+		if endedAt.Before(session.StartedAt) {
+			return nil, ErrSessionEndBeforeStart
+		}
+
+		_, err = s.client.WalkSession.FindUnique(
+			db.WalkSession.ID.Equals(sessionID),
+		).Update(
+			db.WalkSession.Status.Set(db.SessionStatusCompleted),
+			db.WalkSession.EndedAt.Set(endedAt),
+		).Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stop session: %w", err)
+		}
+	}
+
+	session, err = s.RefreshTotals(ctx, clerkUserID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// completed walks count towards achievements
+	s.statsService.EvaluateRewards(ctx, clerkUserID)
+
+	return toWalkSessionResult(session), nil
+}
+
+func (s *SessionService) GetSession(ctx context.Context, clerkUserID, sessionID string) (*types.WalkSessionResult, error) {
+	session, err := s.findOwnedSession(ctx, clerkUserID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWalkSessionResult(session), nil
+}
+
+// ListSessions returns the user's sessions, most recent first
+func (s *SessionService) ListSessions(ctx context.Context, clerkUserID string, limit, offset int) ([]types.WalkSessionResult, error) {
+	sessions, err := s.client.WalkSession.FindMany(
+		db.WalkSession.UserID.Equals(clerkUserID),
+	).OrderBy(
+		db.WalkSession.StartedAt.Order(db.DESC),
+	).Skip(offset).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	results := make([]types.WalkSessionResult, len(sessions))
+	for i := range sessions {
+		results[i] = *toWalkSessionResult(&sessions[i])
+	}
+
+	return results, nil
+}
+
+// DeleteSession removes a session together with its visited streets and refreshes the user's stats
+func (s *SessionService) DeleteSession(ctx context.Context, clerkUserID, sessionID string) error {
+	if _, err := s.findOwnedSession(ctx, clerkUserID, sessionID); err != nil {
+		return err
+	}
+
+	_, err := s.client.WalkSession.FindUnique(
+		db.WalkSession.ID.Equals(sessionID),
+	).Delete().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	if _, err := s.statsService.RefreshCityStats(ctx, clerkUserID); err != nil {
+		fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+	}
+
+	return nil
+}
+
+// EnsureSession makes sure visits can be stored under sessionID, creating an active session for
+// clients that still send their own session ids without starting one first.
+func (s *SessionService) EnsureSession(ctx context.Context, clerkUserID, sessionID string, startedAt time.Time) error {
+	session, err := s.client.WalkSession.FindUnique(
+		db.WalkSession.ID.Equals(sessionID),
+	).Exec(ctx)
+	if err == nil {
+		if session.UserID != clerkUserID {
+			return ErrSessionNotFound
+		}
+		return nil
+	}
+	if err != db.ErrNotFound {
+		return fmt.Errorf("failed to check session: %w", err)
+	}
+
+	_, err = s.client.WalkSession.CreateOne(
+		db.WalkSession.User.Link(db.User.ID.Equals(clerkUserID)),
+		db.WalkSession.ID.Set(sessionID),
+		db.WalkSession.StartedAt.Set(startedAt),
+	).Exec(ctx)
+	if err != nil {
+		// a concurrent upload for the same session may have created it first
+		if _, isUnique := db.IsErrUniqueConstraint(err); isUnique {
+			return s.EnsureSession(ctx, clerkUserID, sessionID, startedAt)
+		}
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// visitEntryPoint returns where the visit started, unless the retention job removed it
+func visitEntryPoint(visit *db.VisitedStreetModel) (utils.LatLng, bool) {
+	lat, okLat := visit.EntryLatitude()
+	lng, okLng := visit.EntryLongitude()
+	if !okLat || !okLng {
+		return utils.LatLng{}, false
+	}
+	return utils.LatLng{Lat: lat.InexactFloat64(), Lng: lng.InexactFloat64()}, true
+}
+
+// legMeters is the distance walked from one visit to the next. Legs to or from a point coarsened
+// by a privacy zone add nothing; legs frozen by the retention job keep their original distance.
+func legMeters(from, to *db.VisitedStreetModel) float64 {
+	if frozen, ok := from.LegMeters(); ok {
+		return frozen
+	}
+	if from.Redacted || to.Redacted {
+		return 0
+	}
+	a, okFrom := visitEntryPoint(from)
+	b, okTo := visitEntryPoint(to)
+	if !okFrom || !okTo {
+		return 0
+	}
+	return utils.HaversineMeters(a, b)
+}
+
+// RefreshTotals recomputes a session's duration, distance and street counts from its visited streets
+func (s *SessionService) RefreshTotals(ctx context.Context, clerkUserID, sessionID string) (*db.WalkSessionModel, error) {
+	session, err := s.findOwnedSession(ctx, clerkUserID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	visits, err := s.client.VisitedStreet.FindMany(
+		db.VisitedStreet.SessionID.Equals(sessionID),
+		db.VisitedStreet.Flagged.Equals(false),
+	).OrderBy(
+		db.VisitedStreet.EntryTimestamp.Order(db.ASC),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session visits: %w", err)
+	}
+
+	startedAt := session.StartedAt
+	lastSeen := startedAt
+	streets := make(map[string]bool)
+	distanceMeters := 0.0
+	for i, visit := range visits {
+		streets[visit.StreetID] = true
+		if i > 0 {
+			distanceMeters += legMeters(&visits[i-1], &visit)
+		}
+
+		entry := time.UnixMilli(int64(visit.EntryTimestamp))
+		if entry.Before(startedAt) {
+			startedAt = entry
+		}
+		seen := entry
+		if exit, ok := visit.ExitTimestamp(); ok {
+			seen = time.UnixMilli(int64(exit))
+		}
+		if seen.After(lastSeen) {
+			lastSeen = seen
+		}
+	}
+
+	end := lastSeen
+	if endedAt, ok := session.EndedAt(); ok {
+		end = endedAt
+	}
+	duration := int(end.Sub(startedAt).Seconds())
+	if duration < 0 {
+		duration = 0
+	}
+
+	newStreets, err := s.countNewStreets(ctx, clerkUserID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := s.client.WalkSession.FindUnique(
+		db.WalkSession.ID.Equals(sessionID),
+	).Update(
+		db.WalkSession.StartedAt.Set(startedAt),
+		db.WalkSession.DurationSeconds.Set(duration),
+		db.WalkSession.DistanceKm.Set(distanceMeters/1000),
+		db.WalkSession.StreetsVisited.Set(len(streets)),
+		db.WalkSession.NewStreets.Set(newStreets),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session totals: %w", err)
+	}
+
+	return updated, nil
+}
+
+// countNewStreets counts the streets the user walked for the first time in this session
+func (s *SessionService) countNewStreets(ctx context.Context, clerkUserID, sessionID string) (int, error) {
+	var rows []struct {
+		Count db.RawInt `json:"count"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT COUNT(*)::int AS count FROM (
+			SELECT street_id FROM visited_streets
+			WHERE user_id = $1 AND NOT flagged
+			GROUP BY street_id
+			HAVING (array_agg(session_id ORDER BY entry_timestamp, id))[1] = $2
+		) first_visits`,
+		clerkUserID, sessionID,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count new streets: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	return int(rows[0].Count), nil
+}
+
+func (s *SessionService) findOwnedSession(ctx context.Context, clerkUserID, sessionID string) (*db.WalkSessionModel, error) {
+	session, err := s.client.WalkSession.FindUnique(
+		db.WalkSession.ID.Equals(sessionID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.UserID != clerkUserID {
+		return nil, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+func toWalkSessionResult(session *db.WalkSessionModel) *types.WalkSessionResult {
+	result := &types.WalkSessionResult{
+		ID:              session.ID,
+		Status:          string(session.Status),
+		StartedAt:       session.StartedAt.Format(time.RFC3339),
+		DurationSeconds: session.DurationSeconds,
+		DistanceKm:      session.DistanceKm,
+		StreetsVisited:  session.StreetsVisited,
+		NewStreets:      session.NewStreets,
+	}
+	if device, ok := session.Device(); ok {
+		result.Device = &device
+	}
+	if endedAt, ok := session.EndedAt(); ok {
+		formatted := endedAt.Format(time.RFC3339)
+		result.EndedAt = &formatted
+	}
+
+	return result
+}
@@ -0,0 +1,666 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+type StatsService struct {
+	client             *db.PrismaClient
+	consentService     *ConsentService
+	achievementService *AchievementService
+	xpService          *XpService
+}
+
+func NewStatsService(client *db.PrismaClient, consentService *ConsentService, achievementService *AchievementService, xpService *XpService) *StatsService {
+	return &StatsService{client: client, consentService: consentService, achievementService: achievementService, xpService: xpService}
+}
+
+// GetCityStat returns the stat of the user's current city. Users without one get their most
+// recently updated stat, or an empty stat if they have none yet.
+func (s *StatsService) GetCityStat(ctx context.Context, clerkUserID string) (*db.CityStatModel, error) {
+	user, err := s.client.User.FindUnique(
+		db.User.ID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil && err != db.ErrNotFound {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user != nil {
+		if cityID, ok := user.CurrentCityID(); ok {
+			cityStat, err := s.client.CityStat.FindFirst(
+				db.CityStat.UserID.Equals(clerkUserID),
+				db.CityStat.CityID.Equals(cityID),
+			).Exec(ctx)
+			if err == nil {
+				return cityStat, nil
+			}
+			if err != db.ErrNotFound {
+				return nil, fmt.Errorf("failed to get city stats: %w", err)
+			}
+		}
+	}
+
+	cityStat, err := s.client.CityStat.FindFirst(
+		db.CityStat.UserID.Equals(clerkUserID),
+	).OrderBy(
+		db.CityStat.UpdatedAt.Order(db.DESC),
+	).Exec(ctx)
+	if err == nil {
+		return cityStat, nil
+	}
+	if err != db.ErrNotFound {
+		return nil, fmt.Errorf("failed to get city stats: %w", err)
+	}
+
+	return s.ensureCityStat(ctx, clerkUserID, nil)
+}
+
+// ListCityStats returns one stat per city the user has walked in, most walked first
+func (s *StatsService) ListCityStats(ctx context.Context, clerkUserID string) ([]db.CityStatModel, error) {
+	cityStats, err := s.client.CityStat.FindMany(
+		db.CityStat.UserID.Equals(clerkUserID),
+	).With(
+		db.CityStat.City.Fetch(),
+	).OrderBy(
+		db.CityStat.TotalStreetsWalked.Order(db.DESC),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list city stats: %w", err)
+	}
+
+	return cityStats, nil
+}
+
+// RefreshCityStats recomputes the user's CityStat aggregates from their visited streets, one stat
+// per city their visits were routed to, moves the user's current city to where they last walked and
+// awards the achievements the new stats earn. It is the full rebuild used after visits are deleted,
+// flagged or coarsened and is safe to call repeatedly. Without city stat consent the stats are left
+// as they are.
+func (s *StatsService) RefreshCityStats(ctx context.Context, clerkUserID string) ([]db.CityStatModel, error) {
+	return s.refreshCityStats(ctx, clerkUserID, nil)
+}
+
+// RefreshSessionStats updates the user's CityStat aggregates after visits were added to the given
+// sessions. Only the cities and streets those sessions walked are recomputed.
+func (s *StatsService) RefreshSessionStats(ctx context.Context, clerkUserID string, sessionIDs []string) ([]db.CityStatModel, error) {
+	if sessionIDs == nil {
+		sessionIDs = []string{}
+	}
+	return s.refreshCityStats(ctx, clerkUserID, sessionIDs)
+}
+
+// refreshCityStats recomputes every city the user walked when sessionIDs is nil, otherwise only the
+// cities walked in those sessions
+func (s *StatsService) refreshCityStats(ctx context.Context, clerkUserID string, sessionIDs []string) ([]db.CityStatModel, error) {
+	allowed, err := s.consentService.Allowed(ctx, clerkUserID, types.ConsentCityStatDataUsage)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return s.ListCityStats(ctx, clerkUserID)
+	}
+
+	scope, err := sessionScope(sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var cities []struct {
+		CityID *db.RawString `json:"city_id"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT DISTINCT city_id FROM visited_streets
+		WHERE user_id = $1 AND NOT flagged
+			AND ($2::jsonb IS NULL OR session_id IN (SELECT jsonb_array_elements_text($2::jsonb)))`,
+		clerkUserID, scope,
+	).Exec(ctx, &cities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list walked cities: %w", err)
+	}
+
+	cityIDs := make([]*string, 0, len(cities))
+	for _, city := range cities {
+		var cityID *string
+		if city.CityID != nil {
+			id := string(*city.CityID)
+			cityID = &id
+		}
+		cityIDs = append(cityIDs, cityID)
+	}
+	// a user without visits keeps one empty stat
+	if len(cityIDs) == 0 && scope == nil {
+		cityIDs = append(cityIDs, nil)
+	}
+
+	keep := make([]string, 0, len(cityIDs))
+	for _, cityID := range cityIDs {
+		cityStat, err := s.refreshCityStat(ctx, clerkUserID, cityID, scope)
+		if err != nil {
+			return nil, err
+		}
+		keep = append(keep, cityStat.ID)
+	}
+
+	txs := []db.PrismaTransaction{
+		s.client.Prisma.ExecuteRaw(`
+			UPDATE users SET "currentCityId" = (
+				SELECT city_id FROM visited_streets
+				WHERE user_id = $1 AND NOT flagged
+				ORDER BY entry_timestamp DESC
+				LIMIT 1
+			)
+			WHERE id = $1`,
+			clerkUserID,
+		).Tx(),
+	}
+	// new visits never empty a city, only a full rebuild drops stats
+	if scope == nil {
+		payload, err := json.Marshal(keep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode city stat ids: %w", err)
+		}
+		// cities whose visits were all deleted or flagged
+		txs = append(txs, s.client.Prisma.ExecuteRaw(`
+			DELETE FROM city_stats
+			WHERE "userId" = $1 AND id NOT IN (SELECT jsonb_array_elements_text($2::jsonb))`,
+			clerkUserID, string(payload),
+		).Tx())
+	}
+	if err := s.client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update cities: %w", err)
+	}
+
+	s.EvaluateRewards(ctx, clerkUserID)
+
+	return s.ListCityStats(ctx, clerkUserID)
+}
+
+// EvaluateRewards awards the achievements and XP the user's current stats earn. Rewards missed by a
+// failed evaluation are awarded on the next one.
+func (s *StatsService) EvaluateRewards(ctx context.Context, clerkUserID string) {
+	if _, err := s.achievementService.Evaluate(ctx, clerkUserID); err != nil {
+		fmt.Printf("Warning: failed to evaluate achievements: %v\n", err)
+	}
+	// after the achievements, so new badges earn their XP right away
+	if _, err := s.xpService.Evaluate(ctx, clerkUserID); err != nil {
+		fmt.Printf("Warning: failed to evaluate XP: %v\n", err)
+	}
+}
+
+// sessionScope encodes the sessions a refresh is limited to, nil for a full rebuild
+func sessionScope(sessionIDs []string) (*string, error) {
+	if sessionIDs == nil {
+		return nil, nil
+	}
+	payload, err := json.Marshal(sessionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session ids: %w", err)
+	}
+	scope := string(payload)
+	return &scope, nil
+}
+
+// refreshCityStat recomputes the stat of one city, or of the visits outside every city when cityID is nil.
+// With a session scope only the streets walked in those sessions are recomputed.
+func (s *StatsService) refreshCityStat(ctx context.Context, clerkUserID string, cityID, scope *string) (*db.CityStatModel, error) {
+	existing, err := s.ensureCityStat(ctx, clerkUserID, cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.refreshStreetWalks(ctx, existing.ID, clerkUserID, cityID, scope); err != nil {
+		return nil, err
+	}
+
+	// the street walks hold one row per walked street, so the totals are read from them
+	var walked []struct {
+		Streets    db.RawInt   `json:"streets"`
+		Kilometers db.RawFloat `json:"kilometers"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT COUNT(*)::int AS streets, COALESCE(SUM("distanceKm"), 0)::float8 AS kilometers
+		FROM street_walks WHERE "cityStatId" = $1`,
+		existing.ID,
+	).Exec(ctx, &walked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count walked streets: %w", err)
+	}
+	totalStreets, totalKm := 0, 0.0
+	if len(walked) > 0 {
+		totalStreets, totalKm = int(walked[0].Streets), float64(walked[0].Kilometers)
+	}
+
+	// days are calendar days in the user's time zone; streak freezes apply in every city
+	loc, err := userLocation(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := activeDays(ctx, s.client, clerkUserID, loc, true, cityID)
+	if err != nil {
+		return nil, err
+	}
+	frozen, err := frozenDays(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	streaks := utils.Streaks(days, frozen, utils.LocalDay(time.Now(), loc))
+
+	params := []db.CityStatSetParam{
+		db.CityStat.TotalStreetsWalked.Set(totalStreets),
+		db.CityStat.TotalKilometers.Set(totalKm),
+		db.CityStat.DaysActive.Set(len(days)),
+		db.CityStat.LongestStreakDays.Set(streaks.Longest),
+	}
+
+	if cityID != nil {
+		coverage, err := s.cityCoverage(ctx, existing.ID, *cityID)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params,
+			db.CityStat.Name.Set(string(coverage.Name)),
+			db.CityStat.State.Set(string(coverage.State)),
+			db.CityStat.Country.Set(string(coverage.Country)),
+			db.CityStat.CityCoveragePct.Set(utils.CoveragePercent(float64(coverage.WalkedMeters), float64(coverage.TotalMeters))),
+		)
+	}
+
+	cityStat, err := s.client.CityStat.FindUnique(
+		db.CityStat.ID.Equals(existing.ID),
+	).Update(params...).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update city stats: %w", err)
+	}
+
+	return cityStat, nil
+}
+
+// hiddenStreetGeometry stands in for an imported street lying wholly inside privacy zones. It is an
+// empty geometry rather than null, which would keep the geometry stored before.
+var hiddenStreetGeometry = json.RawMessage(`{"type":"GeometryCollection","geometries":[]}`)
+
+type streetWalkRow struct {
+	StreetID   string          `json:"street_id"`
+	StreetName string          `json:"street_name"`
+	GeoJSON    json.RawMessage `json:"geo_json"`
+	DistanceKm float64         `json:"distance_km"`
+}
+
+// refreshStreetWalks rebuilds the user's per-street walked distances in a city. Within a session, the
+// stretch from one street's entry point to the next street's entry point is walked on the first street;
+// a street's distance is the sum of those stretches over all sessions. With a session scope only the
+// streets walked in those sessions are rebuilt, from the sessions those streets were walked in.
+func (s *StatsService) refreshStreetWalks(ctx context.Context, cityStatID, clerkUserID string, cityID, scope *string) error {
+	var legs []struct {
+		StreetID   db.RawString    `json:"street_id"`
+		StreetName db.RawString    `json:"street_name"`
+		Lat        *db.RawFloat    `json:"lat"`
+		Lng        *db.RawFloat    `json:"lng"`
+		NextLat    *db.RawFloat    `json:"next_lat"`
+		NextLng    *db.RawFloat    `json:"next_lng"`
+		LegMeters  *db.RawFloat    `json:"leg_meters"`
+		Geometry   json.RawMessage `json:"geometry"`
+	}
+	// the window runs over all cities so a stretch leaving the city still counts for the street it started on;
+	// legs to or from a point coarsened by a privacy zone add no distance, and legs frozen by the retention
+	// job keep the distance they had before their points were coarsened
+	// a new visit only changes the legs of its own session, so the streets of the scoped sessions are the
+	// ones whose distance can change, and only the sessions that walked them need their legs
+	err := s.client.Prisma.QueryRaw(`
+		WITH touched AS (
+			SELECT DISTINCT street_id FROM visited_streets
+			WHERE user_id = $1 AND NOT flagged AND city_id IS NOT DISTINCT FROM $2::text
+				AND session_id IN (SELECT jsonb_array_elements_text($3::jsonb))
+		),
+		sessions AS (
+			SELECT DISTINCT session_id FROM visited_streets
+			WHERE user_id = $1 AND street_id IN (SELECT street_id FROM touched)
+		),
+		legs AS (
+			SELECT v.id, v.street_id, v.street_name, v.city_id, v.entry_timestamp,
+				v.entry_latitude::float8 AS lat, v.entry_longitude::float8 AS lng, v.leg_meters,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_latitude::float8) OVER w END AS next_lat,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_longitude::float8) OVER w END AS next_lng
+			FROM visited_streets v
+			WHERE v.user_id = $1 AND NOT v.flagged
+				AND ($3::jsonb IS NULL OR v.session_id IN (SELECT session_id FROM sessions))
+			WINDOW w AS (PARTITION BY v.session_id ORDER BY v.entry_timestamp, v.id)
+		)
+		SELECT l.street_id, l.street_name, st.geometry, l.lat, l.lng, l.next_lat, l.next_lng, l.leg_meters
+		FROM legs l
+		LEFT JOIN streets st ON st.id = l.street_id
+		WHERE l.city_id IS NOT DISTINCT FROM $2::text
+			AND ($3::jsonb IS NULL OR l.street_id IN (SELECT street_id FROM touched))
+		ORDER BY l.entry_timestamp, l.id`,
+		clerkUserID, cityID, scope,
+	).Exec(ctx, &legs)
+	if err != nil {
+		return fmt.Errorf("failed to load walked legs: %w", err)
+	}
+
+	byStreet := make(map[string]*streetWalkRow)
+	imported := make(map[string]bool)
+	var order []string
+	for _, leg := range legs {
+		row, ok := byStreet[string(leg.StreetID)]
+		if !ok {
+			row = &streetWalkRow{StreetID: string(leg.StreetID)}
+			if len(leg.Geometry) > 0 && string(leg.Geometry) != "null" {
+				row.GeoJSON = leg.Geometry
+				imported[row.StreetID] = true
+			}
+			byStreet[row.StreetID] = row
+			order = append(order, row.StreetID)
+		}
+		row.StreetName = string(leg.StreetName)
+
+		if leg.Lat == nil || leg.Lng == nil {
+			// the retention job removed the entry point, the frozen leg is all that is left
+			if leg.LegMeters != nil {
+				row.DistanceKm += float64(*leg.LegMeters) / 1000
+			}
+			continue
+		}
+		from := utils.LatLng{Lat: float64(*leg.Lat), Lng: float64(*leg.Lng)}
+		if row.GeoJSON == nil {
+			// streets outside the imported network are drawn where they were first entered
+			row.GeoJSON, err = json.Marshal(map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{from.Lng, from.Lat},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode street geometry: %w", err)
+			}
+		}
+
+		meters := 0.0
+		if leg.LegMeters != nil {
+			meters = float64(*leg.LegMeters)
+		} else if leg.NextLat != nil && leg.NextLng != nil {
+			meters = utils.HaversineMeters(from, utils.LatLng{Lat: float64(*leg.NextLat), Lng: float64(*leg.NextLng)})
+		}
+		row.DistanceKm += meters / 1000
+	}
+
+	zones, err := loadPrivacyZones(ctx, s.client, clerkUserID)
+	if err != nil {
+		return err
+	}
+	rows := make([]streetWalkRow, len(order))
+	for i, id := range order {
+		row := byStreet[id]
+		// imported streets are drawn without their stretches inside privacy zones
+		if imported[id] {
+			clipped, err := clipStreetGeometry(zones, row.GeoJSON)
+			if err != nil {
+				return fmt.Errorf("failed to clip street %s: %w", id, err)
+			}
+			row.GeoJSON = clipped
+			if clipped == nil {
+				row.GeoJSON = hiddenStreetGeometry
+			}
+		}
+		rows[i] = *row
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode street walks: %w", err)
+	}
+
+	txs := []db.PrismaTransaction{
+		s.client.Prisma.ExecuteRaw(`
+			INSERT INTO street_walks (id, "cityStatId", "streetId", "streetName", "geoJson", "distanceKm")
+			SELECT gen_random_uuid()::text, $1, street_id, street_name, COALESCE(geo_json, 'null'::jsonb), distance_km
+			FROM jsonb_to_recordset($2::jsonb) AS x(street_id text, street_name text, geo_json jsonb, distance_km float8)
+			ON CONFLICT ("cityStatId", "streetId") DO UPDATE SET
+				"streetName" = EXCLUDED."streetName",
+				"geoJson" = CASE WHEN EXCLUDED."geoJson" = 'null'::jsonb THEN street_walks."geoJson" ELSE EXCLUDED."geoJson" END,
+				"distanceKm" = EXCLUDED."distanceKm"`,
+			cityStatID, string(payload),
+		).Tx(),
+	}
+	if scope == nil {
+		// streets whose visits were all deleted, and rows from before street ids were recorded
+		txs = append(txs, s.client.Prisma.ExecuteRaw(`
+			DELETE FROM street_walks
+			WHERE "cityStatId" = $1
+				AND ("streetId" IS NULL OR "streetId" NOT IN (SELECT street_id FROM jsonb_to_recordset($2::jsonb) AS x(street_id text)))`,
+			cityStatID, string(payload),
+		).Tx())
+	}
+	if err := s.client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store street walks: %w", err)
+	}
+
+	return nil
+}
+
+type cityCoverageRow struct {
+	CityID       db.RawString `json:"city_id"`
+	Name         db.RawString `json:"name"`
+	State        db.RawString `json:"state"`
+	Country      db.RawString `json:"country"`
+	TotalMeters  db.RawFloat  `json:"total_meters"`
+	WalkedMeters db.RawFloat  `json:"walked_meters"`
+}
+
+// cityCoverage returns the walked and total street length of a city. A walked street counts with its
+// full length; the walked streets are read from the stat's street walks rather than the visit history.
+func (s *StatsService) cityCoverage(ctx context.Context, cityStatID, cityID string) (*cityCoverageRow, error) {
+	var rows []cityCoverageRow
+	err := s.client.Prisma.QueryRaw(`
+		SELECT c.id AS city_id, c.name, c.state, c.country,
+			c.total_length_meters::float8 AS total_meters,
+			COALESCE((
+				SELECT SUM(st.length_meters) FROM street_walks sw
+				JOIN streets st ON st.id = sw."streetId"
+				WHERE sw."cityStatId" = $1 AND st.city_id = c.id
+			), 0)::float8 AS walked_meters
+		FROM cities c
+		WHERE c.id = $2`,
+		cityStatID, cityID,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute city coverage: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("city %s not found", cityID)
+	}
+
+	return &rows[0], nil
+}
+
+// RecomputeAll rebuilds the CityStat aggregates of every user who has visited streets.
+// It is the repair path for stats that drifted or were never computed.
+func (s *StatsService) RecomputeAll(ctx context.Context) (int, error) {
+	var users []struct {
+		UserID db.RawString `json:"user_id"`
+	}
+	err := s.client.Prisma.QueryRaw(
+		`SELECT DISTINCT user_id FROM visited_streets`,
+	).Exec(ctx, &users)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list users with visits: %w", err)
+	}
+
+	recomputed := 0
+	for _, u := range users {
+		if _, err := s.RefreshCityStats(ctx, string(u.UserID)); err != nil {
+			return recomputed, fmt.Errorf("failed to recompute stats for user %s: %w", u.UserID, err)
+		}
+		recomputed++
+	}
+
+	return recomputed, nil
+}
+
+// ensureCityStat returns the user's stat for a city, or for the visits outside every city when
+// cityID is nil, creating it if needed
+func (s *StatsService) ensureCityStat(ctx context.Context, clerkUserID string, cityID *string) (*db.CityStatModel, error) {
+	find := func() (*db.CityStatModel, error) {
+		return s.client.CityStat.FindFirst(
+			db.CityStat.UserID.Equals(clerkUserID),
+			db.CityStat.CityID.EqualsOptional(cityID),
+		).Exec(ctx)
+	}
+
+	cityStat, err := find()
+	if err == nil {
+		return cityStat, nil
+	}
+	if err != db.ErrNotFound {
+		return nil, fmt.Errorf("error checking city stats: %w", err)
+	}
+
+	params := []db.CityStatSetParam{}
+	if cityID != nil {
+		params = append(params, db.CityStat.City.Link(db.City.ID.Equals(*cityID)))
+	}
+	cityStat, err = s.client.CityStat.CreateOne(
+		db.CityStat.Name.Set(""),
+		db.CityStat.State.Set(""),
+		db.CityStat.Country.Set(""),
+		db.CityStat.User.Link(db.User.ID.Equals(clerkUserID)),
+		params...,
+	).Exec(ctx)
+	if err != nil {
+		// a concurrent ingest may have created it first
+		if _, isUnique := db.IsErrUniqueConstraint(err); isUnique {
+			return find()
+		}
+		return nil, fmt.Errorf("failed to create city stats: %w", err)
+	}
+
+	return cityStat, nil
+}
+
+// MaxTimeseriesBuckets caps the buckets in one time series response
+const MaxTimeseriesBuckets = 400
+
+// Timeseries buckets the user's walking between from and to by day, week or month in loc.
+// A stretch between two street entries counts towards the bucket it started in.
+func (s *StatsService) Timeseries(ctx context.Context, clerkUserID, interval string, from, to time.Time, loc *time.Location) (*types.TimeseriesResponse, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentCityStatDataUsage); err != nil {
+		return nil, err
+	}
+
+	starts, err := utils.BucketStarts(from, to, interval, loc, MaxTimeseriesBuckets)
+	if err != nil {
+		return nil, err
+	}
+	rangeStart := starts[0].UnixMilli()
+	rangeEnd := utils.NextBucket(starts[len(starts)-1], interval).UnixMilli()
+
+	// the windows run over all visits so first visits and stretches are right at the range edges
+	var visits []struct {
+		EntryTimestamp db.RawBigInt  `json:"entry_timestamp"`
+		StreetID       db.RawString  `json:"street_id"`
+		FirstVisit     db.RawBoolean `json:"first_visit"`
+		ActiveSeconds  db.RawInt     `json:"active_seconds"`
+		Lat            *db.RawFloat  `json:"lat"`
+		Lng            *db.RawFloat  `json:"lng"`
+		NextLat        *db.RawFloat  `json:"next_lat"`
+		NextLng        *db.RawFloat  `json:"next_lng"`
+		LegMeters      *db.RawFloat  `json:"leg_meters"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		WITH visits AS (
+			SELECT v.entry_timestamp, v.street_id,
+				ROW_NUMBER() OVER (PARTITION BY v.street_id ORDER BY v.entry_timestamp, v.id) = 1 AS first_visit,
+				GREATEST(COALESCE(v.duration_seconds, (v.exit_timestamp - v.entry_timestamp) / 1000, 0), 0)::int AS active_seconds,
+				v.entry_latitude::float8 AS lat, v.entry_longitude::float8 AS lng, v.leg_meters,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_latitude::float8) OVER w END AS next_lat,
+				CASE WHEN v.redacted OR LEAD(v.redacted) OVER w THEN NULL ELSE LEAD(v.entry_longitude::float8) OVER w END AS next_lng
+			FROM visited_streets v
+			WHERE v.user_id = $1 AND NOT v.flagged
+			WINDOW w AS (PARTITION BY v.session_id ORDER BY v.entry_timestamp, v.id)
+		)
+		SELECT * FROM visits
+		WHERE entry_timestamp >= $2 AND entry_timestamp < $3
+		ORDER BY entry_timestamp`,
+		clerkUserID, rangeStart, rangeEnd,
+	).Exec(ctx, &visits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load visits: %w", err)
+	}
+
+	var before []struct {
+		Count db.RawInt `json:"count"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT COUNT(*)::int AS count FROM (
+			SELECT MIN(entry_timestamp) AS first_entry
+			FROM visited_streets
+			WHERE user_id = $1 AND NOT flagged
+			GROUP BY street_id
+		) f
+		WHERE first_entry < $2`,
+		clerkUserID, rangeStart,
+	).Exec(ctx, &before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count earlier streets: %w", err)
+	}
+
+	response := &types.TimeseriesResponse{
+		Interval: interval,
+		TimeZone: loc.String(),
+		Buckets:  make([]types.TimeseriesBucket, len(starts)),
+	}
+	index := make(map[string]int, len(starts))
+	for i, start := range starts {
+		key := utils.BucketKey(start)
+		index[key] = i
+		response.Buckets[i].Start = key
+	}
+
+	streets := make([]map[string]bool, len(starts))
+	activeSeconds := make([]int, len(starts))
+	for _, visit := range visits {
+		entry := time.UnixMilli(int64(visit.EntryTimestamp))
+		i, ok := index[utils.BucketKey(utils.BucketStart(entry, interval, loc))]
+		if !ok {
+			continue
+		}
+		bucket := &response.Buckets[i]
+
+		if streets[i] == nil {
+			streets[i] = make(map[string]bool)
+		}
+		streets[i][string(visit.StreetID)] = true
+		if visit.FirstVisit {
+			bucket.NewStreets++
+		}
+		activeSeconds[i] += int(visit.ActiveSeconds)
+		if visit.LegMeters != nil {
+			bucket.DistanceKm += float64(*visit.LegMeters) / 1000
+		} else if visit.Lat != nil && visit.Lng != nil && visit.NextLat != nil && visit.NextLng != nil {
+			meters := utils.HaversineMeters(
+				utils.LatLng{Lat: float64(*visit.Lat), Lng: float64(*visit.Lng)},
+				utils.LatLng{Lat: float64(*visit.NextLat), Lng: float64(*visit.NextLng)},
+			)
+			bucket.DistanceKm += meters / 1000
+		}
+	}
+
+	total := 0
+	if len(before) > 0 {
+		total = int(before[0].Count)
+	}
+	for i := range response.Buckets {
+		bucket := &response.Buckets[i]
+		total += bucket.NewStreets
+		bucket.StreetsWalked = len(streets[i])
+		bucket.TotalStreets = total
+		bucket.ActiveMinutes = (activeSeconds[i] + 30) / 60
+		bucket.DistanceKm = math.Round(bucket.DistanceKm*1000) / 1000
+	}
+
+	return response, nil
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// MaxStreakFreezeAge is how many days back a streak freeze can be spent
+const MaxStreakFreezeAge = 7
+
+// userLocation returns the time zone in the user's profile, UTC when it is unset or unknown
+func userLocation(ctx context.Context, client *db.PrismaClient, clerkUserID string) (*time.Location, error) {
+	user, err := client.User.FindUnique(
+		db.User.ID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return time.UTC, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	loc, err := time.LoadLocation(user.TimeZone)
+	if err != nil {
+		fmt.Printf("Warning: unknown time zone %q of user %s: %v\n", user.TimeZone, clerkUserID, err)
+		return time.UTC, nil
+	}
+	return loc, nil
+}
+
+// activeDays returns the sorted calendar days in loc with a visit that is not flagged, over all
+// cities or, when inCity is set, in cityID only (nil being the visits outside every city)
+func activeDays(ctx context.Context, client *db.PrismaClient, clerkUserID string, loc *time.Location, inCity bool, cityID *string) ([]time.Time, error) {
+	var dayRows []struct {
+		Day db.RawString `json:"day"`
+	}
+	// entry timestamps are unix milliseconds
+	err := client.Prisma.QueryRaw(`
+		SELECT DISTINCT to_char(to_timestamp(entry_timestamp / 1000.0) AT TIME ZONE $2, 'YYYY-MM-DD') AS day
+		FROM visited_streets
+		WHERE user_id = $1 AND NOT flagged AND (NOT $3::boolean OR city_id IS NOT DISTINCT FROM $4::text)`,
+		clerkUserID, loc.String(), inCity, cityID,
+	).Exec(ctx, &dayRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load active days: %w", err)
+	}
+
+	values := make([]string, len(dayRows))
+	for i, row := range dayRows {
+		values[i] = string(row.Day)
+	}
+	return utils.ParseDays(values), nil
+}
+
+// lockUser takes a lock on the user that is held until the end of the transaction it runs in
+func lockUser(client *db.PrismaClient, clerkUserID string) db.PrismaTransaction {
+	return client.Prisma.ExecuteRaw(`SELECT pg_advisory_xact_lock(hashtext($1))`, clerkUserID).Tx()
+}
+
+// frozenDays returns the sorted days the user spent streak freezes on
+func frozenDays(ctx context.Context, client *db.PrismaClient, clerkUserID string) ([]time.Time, error) {
+	freezes, err := client.StreakFreeze.FindMany(
+		db.StreakFreeze.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load streak freezes: %w", err)
+	}
+
+	values := make([]string, len(freezes))
+	for i, freeze := range freezes {
+		values[i] = freeze.Day
+	}
+	return utils.ParseDays(values), nil
+}
+
+// UserLocation returns the time zone in the user's profile
+func (s *StatsService) UserLocation(ctx context.Context, clerkUserID string) (*time.Location, error) {
+	return userLocation(ctx, s.client, clerkUserID)
+}
+
+// StreakState returns the user's streaks over all cities, counted in their profile time zone
+func (s *StatsService) StreakState(ctx context.Context, clerkUserID string) (*types.StreakStateResponse, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentCityStatDataUsage); err != nil {
+		return nil, err
+	}
+
+	loc, err := userLocation(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	active, err := activeDays(ctx, s.client, clerkUserID, loc, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	frozen, err := frozenDays(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := utils.LocalDay(time.Now(), loc)
+	summary := utils.Streaks(active, frozen, today)
+	response := &types.StreakStateResponse{
+		TimeZone:          loc.String(),
+		Today:             utils.FormatDay(today),
+		CurrentStreakDays: summary.Current,
+		LongestStreakDays: summary.Longest,
+		DaysActive:        len(active),
+		TodayActive:       summary.TodayActive,
+		FreezesEarned:     summary.FreezesEarned,
+		FreezesAvailable:  max(summary.FreezesEarned-len(frozen), 0),
+		FrozenDays:        make([]string, len(frozen)),
+	}
+	for i, day := range frozen {
+		response.FrozenDays[i] = utils.FormatDay(day)
+	}
+
+	return response, nil
+}
+
+// SpendStreakFreeze spends one of the user's earned freezes on a recent day without activity,
+// yesterday when day is empty
+func (s *StatsService) SpendStreakFreeze(ctx context.Context, clerkUserID, day string) (*types.StreakStateResponse, error) {
+	state, err := s.StreakState(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	today, err := time.Parse("2006-01-02", state.Today)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse today: %w", err)
+	}
+	target := today.AddDate(0, 0, -1)
+	if day != "" {
+		target, err = time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day: expected YYYY-MM-DD")
+		}
+	}
+	if !target.Before(today) || target.Before(today.AddDate(0, 0, -MaxStreakFreezeAge)) {
+		return nil, fmt.Errorf("invalid day: a freeze can only be spent on one of the last %d days", MaxStreakFreezeAge)
+	}
+	if state.FreezesAvailable == 0 {
+		return nil, fmt.Errorf("no streak freezes left")
+	}
+	for _, frozenDay := range state.FrozenDays {
+		if frozenDay == utils.FormatDay(target) {
+			return nil, fmt.Errorf("invalid day: %s is already frozen", frozenDay)
+		}
+	}
+
+	loc, err := time.LoadLocation(state.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone: %w", err)
+	}
+	active, err := activeDays(ctx, s.client, clerkUserID, loc, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, activeDay := range active {
+		if activeDay.Equal(target) {
+			return nil, fmt.Errorf("invalid day: %s already has activity", utils.FormatDay(target))
+		}
+	}
+
+	// the freeze is only stored while the spent ones stay below the earned ones, under the user's lock
+	// so concurrent requests cannot both spend the last freeze
+	spend := s.client.Prisma.ExecuteRaw(`
+		INSERT INTO streak_freezes (id, user_id, day)
+		SELECT gen_random_uuid()::text, $1, $2
+		WHERE (SELECT COUNT(*) FROM streak_freezes WHERE user_id = $1) < $3
+		ON CONFLICT (user_id, day) DO NOTHING`,
+		clerkUserID, utils.FormatDay(target), state.FreezesEarned,
+	).Tx()
+	if err := s.client.Prisma.Transaction(lockUser(s.client, clerkUserID), spend).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to spend streak freeze: %w", err)
+	}
+	if spend.Result().Count == 0 {
+		return nil, fmt.Errorf("no streak freezes left")
+	}
+
+	// the longest streaks stored on the city stats may have grown
+	if _, err := s.RefreshCityStats(ctx, clerkUserID); err != nil {
+		fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+	}
+
+	return s.StreakState(ctx, clerkUserID)
+}
@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/utils"
+)
+
+// streetImportChunk is how many streets go into one INSERT during an import
+const streetImportChunk = 1000
+
+// boundaryRouteChunk is how many visits are routed to a city per UPDATE after a boundary import
+const boundaryRouteChunk = 5000
+
+// NetworkReloadInterval is how often the server picks up streets and boundaries stored by another
+// process, such as cmd/importstreets
+const NetworkReloadInterval = time.Minute
+
+type StreetService struct {
+	client *db.PrismaClient
+
+	mu      sync.RWMutex
+	locator *utils.CityLocator
+}
+
+func NewStreetService(client *db.PrismaClient) *StreetService {
+	return &StreetService{client: client, locator: utils.NewCityLocator(nil)}
+}
+
+type streetRow struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	LengthMeters float64         `json:"length_meters"`
+	Geometry     json.RawMessage `json:"geometry"`
+	MinLat       float64         `json:"min_lat"`
+	MinLng       float64         `json:"min_lng"`
+	MaxLat       float64         `json:"max_lat"`
+	MaxLng       float64         `json:"max_lng"`
+}
+
+type streetSegmentRow struct {
+	StreetID     string          `json:"street_id"`
+	Position     int             `json:"position"`
+	LengthMeters float64         `json:"length_meters"`
+	Geometry     json.RawMessage `json:"geometry"`
+}
+
+// ImportCity replaces a city's street network in one transaction, creating the city on its first import.
+// Every line of a street is stored as one of its segments.
+func (s *StreetService) ImportCity(ctx context.Context, name, state, country string, streets []utils.Street) (*db.CityModel, error) {
+	merged := utils.MergeStreets(streets)
+	streets = merged[:0]
+	for _, street := range merged {
+		if len(street.Lines) > 0 {
+			streets = append(streets, street)
+		}
+	}
+
+	city, err := s.EnsureCity(ctx, name, state, country)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := []db.PrismaTransaction{
+		s.client.Prisma.ExecuteRaw(`DELETE FROM streets WHERE city_id = $1`, city.ID).Tx(),
+	}
+
+	totalLength := 0.0
+	for start := 0; start < len(streets); start += streetImportChunk {
+		end := min(start+streetImportChunk, len(streets))
+
+		var streetRows []streetRow
+		var segmentRows []streetSegmentRow
+		for _, street := range streets[start:end] {
+			row, segments, err := toStreetRows(street)
+			if err != nil {
+				return nil, err
+			}
+			totalLength += row.LengthMeters
+			streetRows = append(streetRows, row)
+			segmentRows = append(segmentRows, segments...)
+		}
+
+		streetPayload, err := json.Marshal(streetRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode streets: %w", err)
+		}
+		segmentPayload, err := json.Marshal(segmentRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode street segments: %w", err)
+		}
+
+		// ids imported from GeoJSON are not namespaced by city, so a street may move between cities
+		txs = append(txs,
+			s.client.Prisma.ExecuteRaw(`
+				INSERT INTO streets (id, city_id, name, length_meters, geometry, min_lat, min_lng, max_lat, max_lng)
+				SELECT id, $1, name, length_meters, geometry, min_lat, min_lng, max_lat, max_lng
+				FROM jsonb_to_recordset($2::jsonb) AS x(
+					id text, name text, length_meters float8, geometry jsonb,
+					min_lat float8, min_lng float8, max_lat float8, max_lng float8
+				)
+				ON CONFLICT (id) DO UPDATE SET
+					city_id = EXCLUDED.city_id, name = EXCLUDED.name, length_meters = EXCLUDED.length_meters,
+					geometry = EXCLUDED.geometry, min_lat = EXCLUDED.min_lat, min_lng = EXCLUDED.min_lng,
+					max_lat = EXCLUDED.max_lat, max_lng = EXCLUDED.max_lng`,
+				city.ID, string(streetPayload),
+			).Tx(),
+			s.client.Prisma.ExecuteRaw(`
+				DELETE FROM street_segments
+				WHERE street_id IN (SELECT id FROM jsonb_to_recordset($1::jsonb) AS x(id text))`,
+				string(streetPayload),
+			).Tx(),
+			s.client.Prisma.ExecuteRaw(`
+				INSERT INTO street_segments (id, street_id, position, length_meters, geometry)
+				SELECT gen_random_uuid()::text, street_id, position, length_meters, geometry
+				FROM jsonb_to_recordset($1::jsonb) AS x(
+					street_id text, position int, length_meters float8, geometry jsonb
+				)`,
+				string(segmentPayload),
+			).Tx(),
+			// visits on these streets now belong to this city
+			s.client.Prisma.ExecuteRaw(`
+				UPDATE visited_streets SET city_id = $1
+				WHERE street_id IN (SELECT id FROM jsonb_to_recordset($2::jsonb) AS x(id text))
+					AND city_id IS DISTINCT FROM $1`,
+				city.ID, string(streetPayload),
+			).Tx(),
+		)
+	}
+
+	updateCity := s.client.City.FindUnique(
+		db.City.ID.Equals(city.ID),
+	).Update(
+		db.City.StreetCount.Set(len(streets)),
+		db.City.TotalLengthMeters.Set(totalLength),
+		db.City.ImportedAt.Set(time.Now()),
+	).Tx()
+	txs = append(txs, updateCity)
+
+	if err := s.client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to import streets: %w", err)
+	}
+
+	return updateCity.Result(), nil
+}
+
+// EnsureCity returns the city with the given name, state and country, creating it if needed
+func (s *StreetService) EnsureCity(ctx context.Context, name, state, country string) (*db.CityModel, error) {
+	city, err := s.client.City.UpsertOne(
+		db.City.NameStateCountry(
+			db.City.Name.Equals(name),
+			db.City.State.Equals(state),
+			db.City.Country.Equals(country),
+		),
+	).Create(
+		db.City.Name.Set(name),
+		db.City.Country.Set(country),
+		db.City.State.Set(state),
+	).Update(
+		db.City.Name.Set(name),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert city: %w", err)
+	}
+
+	return city, nil
+}
+
+// SetCityBoundary stores a city's boundary and routes the stored visits inside it that no city
+// claimed yet. It returns how many visits were routed.
+func (s *StreetService) SetCityBoundary(ctx context.Context, cityID string, polygons []utils.Polygon) (int, error) {
+	geometry, err := utils.BoundaryGeometry(polygons)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode boundary: %w", err)
+	}
+	_, err = s.client.City.FindUnique(
+		db.City.ID.Equals(cityID),
+	).Update(
+		db.City.Boundary.Set(db.JSON(geometry)),
+	).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store boundary: %w", err)
+	}
+
+	locator := utils.NewCityLocator([]utils.CityBoundary{{CityID: cityID, Polygons: polygons}})
+	var visits []struct {
+		ID  db.RawString `json:"id"`
+		Lat db.RawFloat  `json:"lat"`
+		Lng db.RawFloat  `json:"lng"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT id, entry_latitude::float8 AS lat, entry_longitude::float8 AS lng
+		FROM visited_streets WHERE city_id IS NULL AND entry_latitude IS NOT NULL`,
+	).Exec(ctx, &visits)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load unrouted visits: %w", err)
+	}
+
+	var inside []string
+	for _, visit := range visits {
+		if _, ok := locator.Locate(utils.LatLng{Lat: float64(visit.Lat), Lng: float64(visit.Lng)}); ok {
+			inside = append(inside, string(visit.ID))
+		}
+	}
+	for start := 0; start < len(inside); start += boundaryRouteChunk {
+		end := min(start+boundaryRouteChunk, len(inside))
+		payload, err := json.Marshal(inside[start:end])
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode visit ids: %w", err)
+		}
+		_, err = s.client.Prisma.ExecuteRaw(`
+			UPDATE visited_streets SET city_id = $1
+			WHERE id IN (SELECT jsonb_array_elements_text($2::jsonb)) AND city_id IS NULL`,
+			cityID, string(payload),
+		).Exec(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to route visits: %w", err)
+		}
+	}
+
+	if _, err := s.LoadBoundaries(ctx); err != nil {
+		return len(inside), err
+	}
+	return len(inside), nil
+}
+
+// LoadBoundaries rebuilds the city locator from the boundaries stored on cities and returns how
+// many cities have one
+func (s *StreetService) LoadBoundaries(ctx context.Context) (int, error) {
+	cities, err := s.client.City.FindMany().Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load city boundaries: %w", err)
+	}
+
+	boundaries := make([]utils.CityBoundary, 0, len(cities))
+	for _, city := range cities {
+		raw, ok := city.Boundary()
+		if !ok {
+			continue
+		}
+		polygons, err := utils.ParseBoundaryGeoJSON(raw)
+		if err != nil {
+			fmt.Printf("Warning: skipping boundary of city %s: %v\n", city.ID, err)
+			continue
+		}
+		boundaries = append(boundaries, utils.CityBoundary{CityID: city.ID, Polygons: polygons})
+	}
+
+	locator := utils.NewCityLocator(boundaries)
+	s.mu.Lock()
+	s.locator = locator
+	s.mu.Unlock()
+
+	return locator.Len(), nil
+}
+
+// RunEvery checks every interval until ctx is done whether streets or boundaries were imported since
+// the last check, and then reloads the city locator and, when onGraph is set, hands it a street
+// graph rebuilt from the imported streets
+func (s *StreetService) RunEvery(ctx context.Context, interval time.Duration, onGraph func(*utils.StreetGraph)) {
+	loaded, err := s.networkVersion(ctx)
+	if err != nil {
+		fmt.Printf("Warning: street network check failed: %v\n", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		version, err := s.networkVersion(ctx)
+		if err != nil {
+			fmt.Printf("Warning: street network check failed: %v\n", err)
+			continue
+		}
+		if version == loaded {
+			continue
+		}
+
+		if _, err := s.LoadBoundaries(ctx); err != nil {
+			fmt.Printf("Warning: boundary reload failed: %v\n", err)
+			continue
+		}
+		if onGraph != nil {
+			graph, err := s.LoadGraph(ctx)
+			if err != nil {
+				fmt.Printf("Warning: street graph reload failed: %v\n", err)
+				continue
+			}
+			if graph.Len() > 0 {
+				onGraph(graph)
+			}
+		}
+		loaded = version
+	}
+}
+
+// networkVersion changes whenever a city's streets or boundary are imported or removed; both bump
+// the city's updated_at
+func (s *StreetService) networkVersion(ctx context.Context) (string, error) {
+	var rows []struct {
+		Imported   db.RawInt       `json:"imported"`
+		Boundaries db.RawInt       `json:"boundaries"`
+		Updated    *db.RawDateTime `json:"updated"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT COUNT(*) FILTER (WHERE imported_at IS NOT NULL)::int AS imported,
+			COUNT(*) FILTER (WHERE boundary IS NOT NULL)::int AS boundaries,
+			MAX(updated_at) FILTER (WHERE imported_at IS NOT NULL OR boundary IS NOT NULL) AS updated
+		FROM cities`,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return "", fmt.Errorf("failed to check the street network: %w", err)
+	}
+	if len(rows) == 0 || rows[0].Updated == nil {
+		return "", nil
+	}
+	return fmt.Sprintf("%d:%d:%s", rows[0].Imported, rows[0].Boundaries, rows[0].Updated.Time.Format(time.RFC3339Nano)), nil
+}
+
+// LocateCity returns the city whose boundary contains p
+func (s *StreetService) LocateCity(p utils.LatLng) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locator.Locate(p)
+}
+
+// LoadGraph builds a street graph from every imported street segment
+func (s *StreetService) LoadGraph(ctx context.Context) (*utils.StreetGraph, error) {
+	var rows []struct {
+		StreetID string          `json:"street_id"`
+		Name     string          `json:"name"`
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT seg.street_id, st.name, seg.geometry
+		FROM street_segments seg
+		JOIN streets st ON st.id = seg.street_id
+		ORDER BY seg.street_id, seg.position`,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load street segments: %w", err)
+	}
+
+	streets := make([]utils.Street, 0, len(rows))
+	for _, row := range rows {
+		line, err := utils.ParseLineStringGeometry(row.Geometry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geometry for street %s: %w", row.StreetID, err)
+		}
+		streets = append(streets, utils.Street{
+			ID:    row.StreetID,
+			Name:  row.Name,
+			Lines: [][]utils.LatLng{line},
+		})
+	}
+
+	return utils.NewStreetGraph(streets), nil
+}
+
+// ResolveStreets returns the canonical names of the given street ids that exist
+func (s *StreetService) ResolveStreets(ctx context.Context, ids []string) (map[string]string, error) {
+	payload, err := json.Marshal(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode street ids: %w", err)
+	}
+
+	var rows []struct {
+		ID   db.RawString `json:"id"`
+		Name db.RawString `json:"name"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT id, name FROM streets
+		WHERE id IN (SELECT jsonb_array_elements_text($1::jsonb))`,
+		string(payload),
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve streets: %w", err)
+	}
+
+	names := make(map[string]string, len(rows))
+	for _, row := range rows {
+		names[string(row.ID)] = string(row.Name)
+	}
+	return names, nil
+}
+
+// ImportedCities returns the ids of the cities whose street network has been imported. Visits
+// located inside one of them must be on its streets.
+func (s *StreetService) ImportedCities(ctx context.Context) (map[string]bool, error) {
+	var rows []struct {
+		ID db.RawString `json:"id"`
+	}
+	err := s.client.Prisma.QueryRaw(`SELECT id FROM cities WHERE imported_at IS NOT NULL`).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load imported cities: %w", err)
+	}
+
+	imported := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		imported[string(row.ID)] = true
+	}
+	return imported, nil
+}
+
+func toStreetRows(street utils.Street) (streetRow, []streetSegmentRow, error) {
+	row := streetRow{
+		ID:     street.ID,
+		Name:   street.Name,
+		MinLat: math.Inf(1),
+		MinLng: math.Inf(1),
+		MaxLat: math.Inf(-1),
+		MaxLng: math.Inf(-1),
+	}
+
+	multi := make([][][2]float64, 0, len(street.Lines))
+	segments := make([]streetSegmentRow, 0, len(street.Lines))
+	for i, line := range street.Lines {
+		coords := make([][2]float64, len(line))
+		for j, p := range line {
+			coords[j] = [2]float64{p.Lng, p.Lat}
+			row.MinLat = math.Min(row.MinLat, p.Lat)
+			row.MinLng = math.Min(row.MinLng, p.Lng)
+			row.MaxLat = math.Max(row.MaxLat, p.Lat)
+			row.MaxLng = math.Max(row.MaxLng, p.Lng)
+		}
+		multi = append(multi, coords)
+
+		geometry, err := json.Marshal(map[string]interface{}{"type": "LineString", "coordinates": coords})
+		if err != nil {
+			return streetRow{}, nil, fmt.Errorf("failed to encode segment geometry: %w", err)
+		}
+		length := utils.PathLengthMeters(line)
+		row.LengthMeters += length
+		segments = append(segments, streetSegmentRow{
+			StreetID:     street.ID,
+			Position:     i,
+			LengthMeters: length,
+			Geometry:     geometry,
+		})
+	}
+	if len(segments) == 0 {
+		return streetRow{}, nil, fmt.Errorf("street %s has no geometry", street.ID)
+	}
+
+	geometry, err := json.Marshal(map[string]interface{}{"type": "MultiLineString", "coordinates": multi})
+	if err != nil {
+		return streetRow{}, nil, fmt.Errorf("failed to encode street geometry: %w", err)
+	}
+	row.Geometry = geometry
+
+	return row, segments, nil
+}
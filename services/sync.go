@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+)
+
+const (
+	// MaxSyncSessions caps the sessions in one pushed batch
+	MaxSyncSessions = 50
+	// an unfinished batch older than this is assumed abandoned by a crashed request and may be re-applied
+	syncBatchStaleAfter = 10 * time.Minute
+)
+
+var (
+	// ErrSyncBatchInProgress is returned when a batch with the same idempotency key is being applied by another request
+	ErrSyncBatchInProgress = errors.New("sync batch is still being processed")
+	// ErrInvalidSyncCursor is returned for pull cursors this server did not hand out
+	ErrInvalidSyncCursor = errors.New("invalid cursor")
+)
+
+type SyncService struct {
+	client          *db.PrismaClient
+	visitorService  *VisitorService
+	sessionService  *SessionService
+	statsService    *StatsService
+	settingsService *SettingsService
+	consentService  *ConsentService
+}
+
+func NewSyncService(client *db.PrismaClient, visitorService *VisitorService, sessionService *SessionService, statsService *StatsService, settingsService *SettingsService, consentService *ConsentService) *SyncService {
+	return &SyncService{
+		client:          client,
+		visitorService:  visitorService,
+		sessionService:  sessionService,
+		statsService:    statsService,
+		settingsService: settingsService,
+		consentService:  consentService,
+	}
+}
+
+// Push applies a batch recorded offline. The first request with an idempotency key applies the
+// batch and stores its outcome; retries get the stored outcome back. Applying is itself idempotent
+// (visits are unique per user, session, street and entry time), so a batch that failed half way
+// can simply be retried.
+func (s *SyncService) Push(ctx context.Context, clerkUserID string, req types.SyncPushRequest) (*types.SyncPushResponse, error) {
+	// checked up front so a batch is never half applied for lack of consent
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentLocationTracking); err != nil {
+		return nil, err
+	}
+	analytics, err := s.consentService.Allowed(ctx, clerkUserID, types.ConsentAnalytics)
+	if err != nil {
+		return nil, err
+	}
+	if !analytics {
+		req.DeviceID = nil
+	}
+
+	batchID, stored, err := s.claimBatch(ctx, clerkUserID, req)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		stored.Replayed = true
+		return stored, nil
+	}
+
+	response, err := s.applyBatch(ctx, clerkUserID, req)
+	if err != nil {
+		// release the key so the client can retry
+		if _, releaseErr := s.client.SyncBatch.FindUnique(
+			db.SyncBatch.ID.Equals(batchID),
+		).Delete().Exec(ctx); releaseErr != nil {
+			fmt.Printf("Warning: failed to release sync batch: %v\n", releaseErr)
+		}
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sync response: %w", err)
+	}
+	_, err = s.client.SyncBatch.FindUnique(
+		db.SyncBatch.ID.Equals(batchID),
+	).Update(
+		db.SyncBatch.Response.Set(db.JSON(encoded)),
+		db.SyncBatch.CompletedAt.Set(time.Now()),
+	).Exec(ctx)
+	if err != nil {
+		// the batch is applied; a retry re-applies it harmlessly
+		fmt.Printf("Warning: failed to store sync response: %v\n", err)
+	}
+
+	return response, nil
+}
+
+// claimBatch reserves the idempotency key. It returns the stored response when the batch was
+// already applied, and an error while another request is still applying it.
+func (s *SyncService) claimBatch(ctx context.Context, clerkUserID string, req types.SyncPushRequest) (string, *types.SyncPushResponse, error) {
+	var claimed []struct {
+		ID db.RawString `json:"id"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		INSERT INTO sync_batches (id, user_id, idempotency_key, device_id)
+		VALUES (gen_random_uuid()::text, $1, $2, $3)
+		ON CONFLICT (user_id, idempotency_key) DO NOTHING
+		RETURNING id`,
+		clerkUserID, req.IdempotencyKey, req.DeviceID,
+	).Exec(ctx, &claimed)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to claim sync batch: %w", err)
+	}
+	if len(claimed) > 0 {
+		return string(claimed[0].ID), nil, nil
+	}
+
+	batch, err := s.client.SyncBatch.FindUnique(
+		db.SyncBatch.UserIDIdempotencyKey(
+			db.SyncBatch.UserID.Equals(clerkUserID),
+			db.SyncBatch.IdempotencyKey.Equals(req.IdempotencyKey),
+		),
+	).Exec(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get sync batch: %w", err)
+	}
+
+	if stored, ok := batch.Response(); ok {
+		var response types.SyncPushResponse
+		if err := json.Unmarshal(stored, &response); err != nil {
+			return "", nil, fmt.Errorf("failed to decode stored sync response: %w", err)
+		}
+		return batch.ID, &response, nil
+	}
+
+	// take over a batch whose request died before finishing; the conditional update lets only one retry win
+	result, err := s.client.Prisma.ExecuteRaw(`
+		UPDATE sync_batches SET created_at = now()
+		WHERE id = $1 AND completed_at IS NULL AND created_at < $2`,
+		batch.ID, time.Now().Add(-syncBatchStaleAfter),
+	).Exec(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to reclaim sync batch: %w", err)
+	}
+	if result == nil || result.Count == 0 {
+		return "", nil, ErrSyncBatchInProgress
+	}
+
+	return batch.ID, nil, nil
+}
+
+func (s *SyncService) applyBatch(ctx context.Context, clerkUserID string, req types.SyncPushRequest) (*types.SyncPushResponse, error) {
+	response := &types.SyncPushResponse{
+		IdempotencyKey: req.IdempotencyKey,
+		Sessions:       make([]types.SyncSessionResult, 0, len(req.Sessions)),
+	}
+
+	for _, upload := range req.Sessions {
+		if upload.StartedAt != nil {
+			sessionID := upload.SessionID
+			_, err := s.sessionService.StartSession(ctx, clerkUserID, types.StartSessionRequest{
+				SessionID: &sessionID,
+				Device:    req.DeviceID,
+				StartedAt: upload.StartedAt,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("session %s: %w", upload.SessionID, err)
+			}
+		}
+
+		result := types.SyncSessionResult{SessionID: upload.SessionID}
+		if len(upload.VisitedStreets) > 0 {
+			streets, err := s.visitorService.SaveVisitedStreets(ctx, clerkUserID, types.SaveVisitedStreetsRequest{
+				SessionID:      upload.SessionID,
+				VisitedStreets: upload.VisitedStreets,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("session %s: %w", upload.SessionID, err)
+			}
+			result.Streets = *streets
+		} else {
+			result.Streets = types.SaveVisitedStreetsResponse{
+				SessionID: upload.SessionID,
+				Results:   []types.VisitedStreetResult{},
+			}
+		}
+
+		var err error
+		if upload.EndedAt != nil {
+			result.Session, err = s.sessionService.StopSession(ctx, clerkUserID, upload.SessionID, types.StopSessionRequest{
+				EndedAt: upload.EndedAt,
+			})
+		} else {
+			result.Session, err = s.sessionService.GetSession(ctx, clerkUserID, upload.SessionID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %w", upload.SessionID, err)
+		}
+
+		response.Sessions = append(response.Sessions, result)
+	}
+
+	return response, nil
+}
+
+type syncChangeRow struct {
+	ID       db.RawBigInt  `json:"id"`
+	Entity   db.RawString  `json:"entity"`
+	EntityID db.RawString  `json:"entity_id"`
+	Deleted  db.RawBoolean `json:"deleted"`
+}
+
+// Pull returns the current state of every entity that changed after cursor, at most limit changes
+// at a time. An empty cursor starts from the beginning. Entities changed several times are returned
+// once, in their latest state, so clients converge by applying pages in order.
+func (s *SyncService) Pull(ctx context.Context, clerkUserID, cursor string, limit int) (*types.SyncPullResponse, error) {
+	after := int64(0)
+	if cursor != "" {
+		parsed, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || parsed < 0 {
+			return nil, ErrInvalidSyncCursor
+		}
+		after = parsed
+	}
+
+	var changes []syncChangeRow
+	err := s.client.Prisma.QueryRaw(`
+		SELECT id, entity, entity_id, deleted
+		FROM sync_changes
+		WHERE user_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`,
+		clerkUserID, after, limit+1,
+	).Exec(ctx, &changes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load changes: %w", err)
+	}
+
+	response := &types.SyncPullResponse{
+		Cursor:   strconv.FormatInt(after, 10),
+		Sessions: []types.WalkSessionResult{},
+		Visits:   []types.SyncVisit{},
+		Friends:  []types.FriendResult{},
+		Deleted:  []types.SyncDeletion{},
+	}
+	if len(changes) > limit {
+		response.HasMore = true
+		changes = changes[:limit]
+	}
+	if len(changes) == 0 {
+		return response, nil
+	}
+	response.Cursor = strconv.FormatInt(int64(changes[len(changes)-1].ID), 10)
+
+	// the last change of an entity decides whether it is returned or reported deleted
+	latest := make(map[types.SyncDeletion]bool)
+	var order []types.SyncDeletion
+	for _, change := range changes {
+		key := types.SyncDeletion{Entity: string(change.Entity), ID: string(change.EntityID)}
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = bool(change.Deleted)
+	}
+
+	changed := make(map[string][]string)
+	for _, key := range order {
+		if latest[key] {
+			response.Deleted = append(response.Deleted, key)
+			continue
+		}
+		changed[key.Entity] = append(changed[key.Entity], key.ID)
+	}
+
+	if err := s.loadChanged(ctx, clerkUserID, changed, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// loadChanged fills the response with the current rows. A row that no longer exists has a later
+// delete change, which the client receives on this or a following page.
+func (s *SyncService) loadChanged(ctx context.Context, clerkUserID string, changed map[string][]string, response *types.SyncPullResponse) error {
+	if ids := changed[types.SyncEntitySession]; len(ids) > 0 {
+		sessions, err := s.client.WalkSession.FindMany(
+			db.WalkSession.ID.In(ids),
+			db.WalkSession.UserID.Equals(clerkUserID),
+		).OrderBy(
+			db.WalkSession.StartedAt.Order(db.ASC),
+		).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load sessions: %w", err)
+		}
+		for i := range sessions {
+			response.Sessions = append(response.Sessions, *toWalkSessionResult(&sessions[i]))
+		}
+	}
+
+	if ids := changed[types.SyncEntityVisit]; len(ids) > 0 {
+		visits, err := s.client.VisitedStreet.FindMany(
+			db.VisitedStreet.ID.In(ids),
+			db.VisitedStreet.UserID.Equals(clerkUserID),
+		).OrderBy(
+			db.VisitedStreet.EntryTimestamp.Order(db.ASC),
+		).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load visits: %w", err)
+		}
+		for i := range visits {
+			response.Visits = append(response.Visits, toSyncVisit(&visits[i]))
+		}
+	}
+
+	if ids := changed[types.SyncEntityFriend]; len(ids) > 0 {
+		friends, err := s.client.Friend.FindMany(
+			db.Friend.ID.In(ids),
+			db.Friend.UserID.Equals(clerkUserID),
+		).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load friends: %w", err)
+		}
+		for i := range friends {
+			response.Friends = append(response.Friends, toFriendResult(&friends[i]))
+		}
+	}
+
+	if ids := changed[types.SyncEntityStats]; len(ids) > 0 {
+		cityStats, err := s.client.CityStat.FindMany(
+			db.CityStat.ID.In(ids),
+			db.CityStat.UserID.Equals(clerkUserID),
+		).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load city stats: %w", err)
+		}
+		response.CityStats = cityStats
+
+		// older clients read stats as the single stat of GET /api/stats
+		cityStat, err := s.statsService.GetCityStat(ctx, clerkUserID)
+		if err != nil {
+			return err
+		}
+		response.Stats = cityStat
+	}
+
+	if len(changed[types.SyncEntitySettings]) > 0 {
+		settings, err := s.settingsService.GetUserSettings(ctx, clerkUserID)
+		if err != nil {
+			return err
+		}
+		response.Settings = settings
+	}
+
+	return nil
+}
+
+func toSyncVisit(visit *db.VisitedStreetModel) types.SyncVisit {
+	result := types.SyncVisit{
+		ID:             visit.ID,
+		SessionID:      visit.SessionID,
+		StreetID:       visit.StreetID,
+		StreetName:     visit.StreetName,
+		EntryTimestamp: int64(visit.EntryTimestamp),
+		Flagged:        visit.Flagged,
+		Redacted:       visit.Redacted,
+	}
+	if point, ok := visitEntryPoint(visit); ok {
+		result.EntryLatitude = &point.Lat
+		result.EntryLongitude = &point.Lng
+	}
+	if cityID, ok := visit.CityID(); ok {
+		result.CityID = &cityID
+	}
+	if exit, ok := visit.ExitTimestamp(); ok {
+		exitMillis := int64(exit)
+		result.ExitTimestamp = &exitMillis
+	}
+	if duration, ok := visit.DurationSeconds(); ok {
+		result.DurationSeconds = &duration
+	}
+
+	return result
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+const (
+	// tileCacheSize bounds the number of rendered tiles kept in memory
+	tileCacheSize = 4096
+	// heatmapCellSize bins entry points into squares of this many tile units
+	heatmapCellSize = 16
+)
+
+// Layer names in heatmap tiles
+const (
+	HeatmapLayerVisits  = "visits"
+	HeatmapLayerStreets = "streets"
+)
+
+type TileService struct {
+	client         *db.PrismaClient
+	consentService *ConsentService
+	cache          *tileCache
+}
+
+func NewTileService(client *db.PrismaClient, consentService *ConsentService) *TileService {
+	return &TileService{client: client, consentService: consentService, cache: newTileCache(tileCacheSize)}
+}
+
+// HeatmapTile renders the user's visit density for a tile and returns it with a version that
+// changes whenever any of the user's visits change. Tiles are cached per version, so new visits
+// invalidate them without any explicit eviction.
+func (s *TileService) HeatmapTile(ctx context.Context, clerkUserID string, tile utils.TileID) ([]byte, string, error) {
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentLocationTracking); err != nil {
+		return nil, "", err
+	}
+
+	version, err := s.visitsVersion(ctx, clerkUserID)
+	if err != nil {
+		return nil, "", err
+	}
+	// zones change what the streets layer draws without touching any visit
+	zones, err := loadPrivacyZones(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(zones) > 0 {
+		version += "-" + zonesVersion(zones)
+	}
+
+	key := fmt.Sprintf("%s|%s|%d/%d/%d", clerkUserID, version, tile.Z, tile.X, tile.Y)
+	if data, ok := s.cache.get(key); ok {
+		return data, version, nil
+	}
+
+	visits, err := s.densityLayer(ctx, clerkUserID, tile)
+	if err != nil {
+		return nil, "", err
+	}
+	streets, err := s.streetsLayer(ctx, clerkUserID, tile, zones)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data := utils.EncodeMVT(visits, streets)
+	s.cache.put(key, data)
+	return data, version, nil
+}
+
+// visitsVersion is the id of the user's latest visit change in the sync log
+func (s *TileService) visitsVersion(ctx context.Context, clerkUserID string) (string, error) {
+	var rows []struct {
+		Version db.RawBigInt `json:"version"`
+	}
+	err := s.client.Prisma.QueryRaw(
+		`SELECT COALESCE(MAX(id), 0) AS version FROM sync_changes WHERE user_id = $1 AND entity = 'visit'`,
+		clerkUserID,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return "", fmt.Errorf("failed to load tile version: %w", err)
+	}
+	if len(rows) == 0 {
+		return "0", nil
+	}
+
+	return strconv.FormatInt(int64(rows[0].Version), 10), nil
+}
+
+// densityLayer bins street entry points into a grid and emits one point per cell with its count
+func (s *TileService) densityLayer(ctx context.Context, clerkUserID string, tile utils.TileID) (*utils.MVTLayer, error) {
+	minLat, minLng, maxLat, maxLng := tile.Bounds()
+	var points []struct {
+		Lat db.RawFloat `json:"lat"`
+		Lng db.RawFloat `json:"lng"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT entry_latitude::float8 AS lat, entry_longitude::float8 AS lng
+		FROM visited_streets
+		WHERE user_id = $1 AND NOT flagged
+			AND entry_latitude BETWEEN $2 AND $3
+			AND entry_longitude BETWEEN $4 AND $5`,
+		clerkUserID, minLat, maxLat, minLng, maxLng,
+	).Exec(ctx, &points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tile visits: %w", err)
+	}
+
+	type cell struct{ x, y int }
+	counts := make(map[cell]int)
+	var order []cell
+	for _, p := range points {
+		x, y := tile.Project(utils.LatLng{Lat: float64(p.Lat), Lng: float64(p.Lng)})
+		if !utils.InTile(x, y) {
+			continue
+		}
+		c := cell{int(math.Floor(x / heatmapCellSize)), int(math.Floor(y / heatmapCellSize))}
+		if counts[c] == 0 {
+			order = append(order, c)
+		}
+		counts[c]++
+	}
+
+	layer := utils.NewMVTLayer(HeatmapLayerVisits)
+	for _, c := range order {
+		center := float64(heatmapCellSize) / 2
+		layer.AddPoint(float64(c.x*heatmapCellSize)+center, float64(c.y*heatmapCellSize)+center,
+			map[string]interface{}{"count": counts[c]})
+	}
+	return layer, nil
+}
+
+// zonesVersion fingerprints the user's privacy zones
+func zonesVersion(zones []utils.PrivacyZone) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%v", zones)
+	return strconv.FormatUint(hash.Sum64(), 36)
+}
+
+// streetsLayer draws the imported streets the user walked in the tile, weighted by visit count,
+// without their stretches inside the user's privacy zones
+func (s *TileService) streetsLayer(ctx context.Context, clerkUserID string, tile utils.TileID, zones []utils.PrivacyZone) (*utils.MVTLayer, error) {
+	minLat, minLng, maxLat, maxLng := tile.Bounds()
+	var streets []struct {
+		StreetID db.RawString    `json:"street_id"`
+		Visits   db.RawInt       `json:"visits"`
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT st.id AS street_id, COUNT(*)::int AS visits, st.geometry
+		FROM streets st
+		JOIN visited_streets v ON v.street_id = st.id
+		WHERE v.user_id = $1 AND NOT v.flagged
+			AND st.max_lat >= $2 AND st.min_lat <= $3
+			AND st.max_lng >= $4 AND st.min_lng <= $5
+		GROUP BY st.id`,
+		clerkUserID, minLat, maxLat, minLng, maxLng,
+	).Exec(ctx, &streets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tile streets: %w", err)
+	}
+
+	layer := utils.NewMVTLayer(HeatmapLayerStreets)
+	for _, street := range streets {
+		// street geometries are MultiLineStrings; each part is drawn as a feature of its own
+		parts, err := utils.ParseLinesGeometry(street.Geometry)
+		if err != nil {
+			fmt.Printf("Warning: skipping street %s in tile: %v\n", street.StreetID, err)
+			continue
+		}
+		parts, _ = utils.ClipPrivacyZones(zones, parts)
+		for _, points := range parts {
+			line := make([][2]float64, len(points))
+			for i, p := range points {
+				x, y := tile.Project(p)
+				line[i] = [2]float64{x, y}
+			}
+			layer.AddLine(utils.ClipLine(line), map[string]interface{}{
+				"streetId": string(street.StreetID),
+				"visits":   int(street.Visits),
+			})
+		}
+	}
+	return layer, nil
+}
+
+// tileCache is a small LRU of rendered tiles
+type tileCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type tileCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newTileCache(capacity int) *tileCache {
+	return &tileCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *tileCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*tileCacheEntry).data, true
+}
+
+func (c *tileCache) put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*tileCacheEntry).data = data
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&tileCacheEntry{key: key, data: data})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tileCacheEntry).key)
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"citystatAPI/prisma/db"
 	"citystatAPI/types"
@@ -60,6 +61,12 @@ func (s *UserService) UpdateUserDetails(ctx context.Context, clerkUserID string,
 	if updates.CompletedTutorial != nil {
 		updateOps = append(updateOps, db.User.CompletedTutorial.Set(*updates.CompletedTutorial))
 	}
+	if updates.TimeZone != nil {
+		if _, err := time.LoadLocation(*updates.TimeZone); err != nil || *updates.TimeZone == "" || *updates.TimeZone == "Local" {
+			return nil, fmt.Errorf("invalid time zone %q", *updates.TimeZone)
+		}
+		updateOps = append(updateOps, db.User.TimeZone.Set(*updates.TimeZone))
+	}
 
 	// If no updates provided, return existing user
 	if len(updateOps) == 0 {
@@ -220,6 +227,8 @@ func (s *UserService) GetOrCreateUser(ctx context.Context, clerkUserID string) (
 		db.User.ID.Equals(clerkUserID),
 	).With(
 		db.User.Settings.Fetch(),
+		db.User.CityStats.Fetch(),
+		db.User.CurrentCity.Fetch(),
 	).Exec(ctx)
 
 	if err == nil {
@@ -446,6 +455,14 @@ func (s *UserService) UpdateUserSettings(ctx context.Context, clerkUserID string
 	if stickersAnimationStr, ok := rawSettings["stickersAnimation"].(string); ok {
 		settingsOps = append(settingsOps, db.Settings.StickersAnimation.Set(db.StickersAnimation(stickersAnimationStr)))
 	}
+	if profileVisibilityStr, ok := rawSettings["profileVisibility"].(string); ok {
+		switch visibility := db.ProfileVisibility(profileVisibilityStr); visibility {
+		case db.ProfileVisibilityPublic, db.ProfileVisibilityFriends, db.ProfileVisibilityPrivate:
+			settingsOps = append(settingsOps, db.Settings.ProfileVisibility.Set(visibility))
+		default:
+			return nil, fmt.Errorf("invalid profileVisibility %q: expected PUBLIC, FRIENDS or PRIVATE", profileVisibilityStr)
+		}
+	}
 
 	// Boolean settings
 	if enabledLocationTracking, ok := rawSettings["enabledLocationTracking"].(bool); ok {
@@ -3,20 +3,32 @@ package services
 import (
 	"citystatAPI/prisma/db"
 	"citystatAPI/types"
+	"citystatAPI/utils"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-    prismaTypes "github.com/steebchen/prisma-client-go/runtime/types"
-
-	"github.com/shopspring/decimal"
+	"time"
 )
 
 type VisitorService struct {
-	client *db.PrismaClient
+	client         *db.PrismaClient
+	statsService   *StatsService
+	sessionService *SessionService
+	streetService  *StreetService
+	privacyService *PrivacyService
+	consentService *ConsentService
 }
 
-func NewVisitorService(client *db.PrismaClient) *VisitorService {
-	return &VisitorService{client: client}
+func NewVisitorService(client *db.PrismaClient, statsService *StatsService, sessionService *SessionService, streetService *StreetService, privacyService *PrivacyService, consentService *ConsentService) *VisitorService {
+	return &VisitorService{
+		client:         client,
+		statsService:   statsService,
+		sessionService: sessionService,
+		streetService:  streetService,
+		privacyService: privacyService,
+		consentService: consentService,
+	}
 }
 
 func (s *VisitorService) GetLocationPermission(ctx context.Context, clerkUserID string) (bool, error) {
@@ -58,58 +70,376 @@ func (s *VisitorService) SaveLocationPermission(ctx context.Context, clerkUserID
 }
 
 
-// Service function
-func (s *VisitorService) SaveVisitedStreets(ctx context.Context, clerkUserID string, req types.SaveVisitedStreetsRequest) error {
+// MaxVisitedStreetsBatch caps a single ingest so one request stays one reasonably sized statement
+const MaxVisitedStreetsBatch = 5000
+
+// ErrTooManyVisitedStreets is returned for batches over MaxVisitedStreetsBatch
+var ErrTooManyVisitedStreets = fmt.Errorf("at most %d visited streets per batch", MaxVisitedStreetsBatch)
+
+// visitedStreetRow is one accepted entry, shipped to Postgres as a JSON recordset
+type visitedStreetRow struct {
+	Index           int      `json:"idx"`
+	StreetID        string   `json:"street_id"`
+	StreetName      string   `json:"street_name"`
+	EntryTimestamp  int64    `json:"entry_timestamp"`
+	ExitTimestamp   *int64   `json:"exit_timestamp"`
+	DurationSeconds *int     `json:"duration_seconds"`
+	EntryLatitude   float64  `json:"entry_latitude"`
+	EntryLongitude  float64  `json:"entry_longitude"`
+	Flagged         bool     `json:"flagged"`
+	FlagReason      string   `json:"flag_reason,omitempty"`
+	SpeedMps        *float64 `json:"speed_mps,omitempty"`
+	DistanceMeters  *float64 `json:"distance_meters,omitempty"`
+	// CityID comes from the city boundaries; a street's own city takes precedence in the insert
+	CityID   *string `json:"city_id,omitempty"`
+	Redacted bool    `json:"redacted"`
+}
+
+// SaveVisitedStreets stores a batch of visited streets in a single INSERT statement, so the batch
+// is written atomically. Entries already stored (same user, session, street and entry timestamp)
+// are reported as duplicates and invalid entries as rejected; neither fails the batch.
+// Entries that fail the movement checks are stored flagged, left out of stats, and queued for review.
+func (s *VisitorService) SaveVisitedStreets(ctx context.Context, clerkUserID string, req types.SaveVisitedStreetsRequest) (*types.SaveVisitedStreetsResponse, error) {
+	if len(req.VisitedStreets) > MaxVisitedStreetsBatch {
+		return nil, ErrTooManyVisitedStreets
+	}
+	if err := s.consentService.Require(ctx, clerkUserID, types.ConsentLocationTracking); err != nil {
+		return nil, err
+	}
+
+	response := &types.SaveVisitedStreetsResponse{
+		SessionID: req.SessionID,
+		Results:   make([]types.VisitedStreetResult, len(req.VisitedStreets)),
+	}
+
+	streetIDs := make([]string, 0, len(req.VisitedStreets))
 	for _, street := range req.VisitedStreets {
-		// Convert types for Prisma compatibility
-		entryTimestamp := prismaTypes.BigInt(street.EntryTimestamp)
-		entryLatitude := decimal.NewFromFloat(street.EntryLatitude)
-		entryLongitude := decimal.NewFromFloat(street.EntryLongitude)
-		
-		// Check if the record already exists to avoid duplicates
-		existing, err := s.client.VisitedStreet.FindFirst(
-			db.VisitedStreet.UserID.Equals(clerkUserID),
-			db.VisitedStreet.SessionID.Equals(req.SessionID),
-			db.VisitedStreet.StreetID.Equals(street.StreetID),
-			db.VisitedStreet.EntryTimestamp.Equals(entryTimestamp),
-		).Exec(ctx)
-		
-		// If error is not "not found", return the error
-		if err != nil && !errors.Is(err, db.ErrNotFound) {
-			return err
-		}
-	
-		// If record doesn't exist, create it
-		if existing == nil {
-			// Prepare optional parameters
-			var optionalParams []db.VisitedStreetSetParam
-			
-			if street.ExitTimestamp != nil {
-				exitTimestamp := prismaTypes.BigInt(*street.ExitTimestamp)
-				optionalParams = append(optionalParams, db.VisitedStreet.ExitTimestamp.Set(exitTimestamp))
+		streetIDs = append(streetIDs, street.StreetID)
+	}
+	streetNames, err := s.streetService.ResolveStreets(ctx, streetIDs)
+	if err != nil {
+		return nil, err
+	}
+	importedCities, err := s.streetService.ImportedCities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]visitedStreetRow, 0, len(req.VisitedStreets))
+	seen := make(map[string]bool, len(req.VisitedStreets))
+	for i, street := range req.VisitedStreets {
+		result := types.VisitedStreetResult{
+			Index:          i,
+			StreetID:       street.StreetID,
+			EntryTimestamp: street.EntryTimestamp,
+		}
+
+		if reason := validateVisitedStreet(street); reason != "" {
+			result.Status = types.VisitStatusRejected
+			result.Reason = reason
+			response.Results[i] = result
+			continue
+		}
+
+		// imported streets keep their canonical names. Inside the boundary of a city whose street
+		// network is imported only its streets can be visited; elsewhere client street ids are taken as-is.
+		cityID, located := s.streetService.LocateCity(utils.LatLng{Lat: street.EntryLatitude, Lng: street.EntryLongitude})
+		if name, ok := streetNames[street.StreetID]; ok {
+			street.StreetName = name
+		} else if located && importedCities[cityID] {
+			result.Status = types.VisitStatusRejected
+			result.Reason = "unknown streetId"
+			response.Results[i] = result
+			continue
+		}
+
+		// the same entry twice in one batch is a duplicate of the first occurrence
+		key := fmt.Sprintf("%s|%d", street.StreetID, street.EntryTimestamp)
+		if seen[key] {
+			result.Status = types.VisitStatusDuplicate
+			response.Results[i] = result
+			continue
+		}
+		seen[key] = true
+
+		// provisional; entries that do not come back from the insert are duplicates
+		result.Status = types.VisitStatusDuplicate
+		response.Results[i] = result
+		row := visitedStreetRow{
+			Index:           i,
+			StreetID:        street.StreetID,
+			StreetName:      street.StreetName,
+			EntryTimestamp:  street.EntryTimestamp,
+			ExitTimestamp:   street.ExitTimestamp,
+			DurationSeconds: street.DurationSeconds,
+			EntryLatitude:   street.EntryLatitude,
+			EntryLongitude:  street.EntryLongitude,
+		}
+		if located {
+			row.CityID = &cityID
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) > 0 {
+		if err := s.flagImplausibleMovement(ctx, clerkUserID, req.SessionID, rows); err != nil {
+			return nil, err
+		}
+
+		// movement is checked on the precise points, but points inside a privacy zone are never stored
+		zones, err := s.privacyService.Zones(ctx, clerkUserID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			p := utils.LatLng{Lat: rows[i].EntryLatitude, Lng: rows[i].EntryLongitude}
+			if utils.InPrivacyZone(zones, p) {
+				coarse := utils.CoarsenLatLng(p)
+				rows[i].EntryLatitude, rows[i].EntryLongitude = coarse.Lat, coarse.Lng
+				rows[i].Redacted = true
 			}
-			
-			if street.DurationSeconds != nil {
-				optionalParams = append(optionalParams, db.VisitedStreet.DurationSeconds.Set(*street.DurationSeconds))
+		}
+
+		startedAt := rows[0].EntryTimestamp
+		for _, row := range rows {
+			if row.EntryTimestamp < startedAt {
+				startedAt = row.EntryTimestamp
+			}
+		}
+		if err := s.sessionService.EnsureSession(ctx, clerkUserID, req.SessionID, time.UnixMilli(startedAt)); err != nil {
+			return nil, err
+		}
+
+		inserted, err := s.insertVisitedStreets(ctx, clerkUserID, req.SessionID, rows)
+		if err != nil {
+			return nil, err
+		}
+		flagged := make(map[int]visitedStreetRow)
+		for _, row := range rows {
+			if row.Flagged {
+				flagged[row.Index] = row
 			}
-			
-			// Create the record - use User.Link for the relation
-			_, err = s.client.VisitedStreet.CreateOne(
-				db.VisitedStreet.SessionID.Set(req.SessionID),
-				db.VisitedStreet.StreetID.Set(street.StreetID),
-				db.VisitedStreet.StreetName.Set(street.StreetName),
-				db.VisitedStreet.EntryTimestamp.Set(entryTimestamp),
-				db.VisitedStreet.EntryLatitude.Set(entryLatitude),
-				db.VisitedStreet.EntryLongitude.Set(entryLongitude),
-				db.VisitedStreet.User.Link(db.User.ID.Equals(clerkUserID)),
-				optionalParams...,
-			).Exec(ctx)
-			
-			if err != nil {
-				return err
+		}
+		for _, idx := range inserted {
+			if row, ok := flagged[idx]; ok {
+				response.Results[idx].Status = types.VisitStatusFlagged
+				response.Results[idx].Reason = row.FlagReason
+				continue
 			}
+			response.Results[idx].Status = types.VisitStatusInserted
+		}
+	}
+
+	for _, result := range response.Results {
+		switch result.Status {
+		case types.VisitStatusInserted:
+			response.Inserted++
+		case types.VisitStatusDuplicate:
+			response.Duplicates++
+		case types.VisitStatusRejected:
+			response.Rejected++
+		case types.VisitStatusFlagged:
+			response.Flagged++
+		}
+	}
+
+	if response.Inserted+response.Flagged > 0 {
+		// The visits are stored at this point; a failed refresh is repaired by a recompute
+		if _, err := s.statsService.RefreshSessionStats(ctx, clerkUserID, []string{req.SessionID}); err != nil {
+			fmt.Printf("Warning: failed to refresh city stats: %v\n", err)
+		}
+		if _, err := s.sessionService.RefreshTotals(ctx, clerkUserID, req.SessionID); err != nil {
+			fmt.Printf("Warning: failed to refresh session totals: %v\n", err)
 		}
 	}
-	
+
+	return response, nil
+}
+
+// insertVisitedStreets bulk-inserts rows, skipping ones that hit the unique constraint,
+// and returns the request indexes of the rows that were actually inserted.
+func (s *VisitorService) insertVisitedStreets(ctx context.Context, clerkUserID, sessionID string, rows []visitedStreetRow) ([]int, error) {
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode visited streets: %w", err)
+	}
+
+	var inserted []struct {
+		Idx db.RawInt `json:"idx"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		WITH input AS (
+			SELECT * FROM jsonb_to_recordset($3::jsonb) AS x(
+				idx int, street_id text, street_name text, entry_timestamp bigint, exit_timestamp bigint,
+				duration_seconds int, entry_latitude numeric, entry_longitude numeric,
+				flagged boolean, flag_reason text, speed_mps float8, distance_meters float8, city_id text,
+				redacted boolean
+			)
+		), inserted AS (
+			INSERT INTO visited_streets (
+				id, user_id, session_id, street_id, street_name, entry_timestamp, exit_timestamp,
+				duration_seconds, entry_latitude, entry_longitude, flagged, city_id, redacted
+			)
+			SELECT gen_random_uuid()::text, $1, $2, street_id, street_name, entry_timestamp, exit_timestamp,
+				duration_seconds, entry_latitude, entry_longitude, flagged,
+				COALESCE((SELECT st.city_id FROM streets st WHERE st.id = input.street_id), input.city_id), redacted
+			FROM input
+			ON CONFLICT (user_id, session_id, street_id, entry_timestamp) DO NOTHING
+			RETURNING id, street_id, entry_timestamp
+		), matched AS (
+			SELECT input.*, inserted.id AS visited_street_id
+			FROM input JOIN inserted USING (street_id, entry_timestamp)
+		), flags AS (
+			INSERT INTO movement_flags (id, visited_street_id, user_id, session_id, reason, speed_mps, distance_meters)
+			SELECT gen_random_uuid()::text, visited_street_id, $1, $2, flag_reason, speed_mps, distance_meters
+			FROM matched WHERE flagged
+		)
+		SELECT idx FROM matched`,
+		clerkUserID, sessionID, string(payload),
+	).Exec(ctx, &inserted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert visited streets: %w", err)
+	}
+
+	indexes := make([]int, len(inserted))
+	for i, row := range inserted {
+		indexes[i] = int(row.Idx)
+	}
+	return indexes, nil
+}
+
+// flagImplausibleMovement marks rows whose movement from the previous visit of the session is
+// physically impossible. The latest plausible stored visit before the batch with a precise entry
+// point is the starting point.
+func (s *VisitorService) flagImplausibleMovement(ctx context.Context, clerkUserID, sessionID string, rows []visitedStreetRow) error {
+	samples := make([]utils.MovementSample, len(rows))
+	earliest := rows[0].EntryTimestamp
+	for i, row := range rows {
+		samples[i] = utils.MovementSample{Lat: row.EntryLatitude, Lng: row.EntryLongitude, Entry: row.EntryTimestamp}
+		if row.ExitTimestamp != nil {
+			samples[i].Exit = *row.ExitTimestamp
+		}
+		earliest = min(earliest, row.EntryTimestamp)
+	}
+
+	var prev *utils.MovementSample
+	last, err := s.client.VisitedStreet.FindFirst(
+		db.VisitedStreet.UserID.Equals(clerkUserID),
+		db.VisitedStreet.SessionID.Equals(sessionID),
+		db.VisitedStreet.Flagged.Equals(false),
+		db.VisitedStreet.Redacted.Equals(false),
+		db.VisitedStreet.EntryTimestamp.Lt(db.BigInt(earliest)),
+	).OrderBy(
+		db.VisitedStreet.EntryTimestamp.Order(db.DESC),
+	).Exec(ctx)
+	if err != nil && err != db.ErrNotFound {
+		return fmt.Errorf("failed to load previous visit: %w", err)
+	}
+	if last != nil {
+		if point, ok := visitEntryPoint(last); ok {
+			prev = &utils.MovementSample{
+				Lat:   point.Lat,
+				Lng:   point.Lng,
+				Entry: int64(last.EntryTimestamp),
+			}
+			if exit, ok := last.ExitTimestamp(); ok {
+				prev.Exit = int64(exit)
+			}
+		}
+	}
+
+	for _, flag := range utils.CheckMovement(prev, samples) {
+		row := &rows[flag.Index]
+		row.Flagged = true
+		row.FlagReason = flag.Reason
+		if flag.SpeedMps > 0 {
+			speed := flag.SpeedMps
+			row.SpeedMps = &speed
+		}
+		distance := flag.DistanceMeters
+		row.DistanceMeters = &distance
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// validateVisitedStreet returns why an entry cannot be stored, or "" when it is valid
+func validateVisitedStreet(street types.VisitedStreetRequest) string {
+	switch {
+	case street.StreetID == "":
+		return "streetId is required"
+	case street.StreetName == "":
+		return "streetName is required"
+	case street.EntryTimestamp <= 0:
+		return "entryTimestamp must be a positive unix timestamp in milliseconds"
+	case street.ExitTimestamp != nil && *street.ExitTimestamp < street.EntryTimestamp:
+		return "exitTimestamp is before entryTimestamp"
+	case street.DurationSeconds != nil && *street.DurationSeconds < 0:
+		return "durationSeconds must not be negative"
+	case street.EntryLatitude < -90 || street.EntryLatitude > 90:
+		return "entryLatitude is out of range"
+	case street.EntryLongitude < -180 || street.EntryLongitude > 180:
+		return "entryLongitude is out of range"
+	}
+	return ""
+}
+
+// importOverlapMillis is how far apart in time two visits of one street may be and still be the same walk
+const importOverlapMillis = 60 * 1000
+
+// FilterRecordedVisits drops entries that overlap a visit of the same street the user already has
+// in another session, so importing history recorded by the app as well doesn't count it twice
+func (s *VisitorService) FilterRecordedVisits(ctx context.Context, clerkUserID, sessionID string, streets []types.VisitedStreetRequest) ([]types.VisitedStreetRequest, int, error) {
+	if len(streets) == 0 {
+		return streets, 0, nil
+	}
+
+	type candidate struct {
+		Index          int    `json:"idx"`
+		StreetID       string `json:"street_id"`
+		EntryTimestamp int64  `json:"entry_timestamp"`
+		ExitTimestamp  int64  `json:"exit_timestamp"`
+	}
+	candidates := make([]candidate, len(streets))
+	for i, street := range streets {
+		exit := street.EntryTimestamp
+		if street.ExitTimestamp != nil {
+			exit = *street.ExitTimestamp
+		}
+		candidates[i] = candidate{Index: i, StreetID: street.StreetID, EntryTimestamp: street.EntryTimestamp, ExitTimestamp: exit}
+	}
+	payload, err := json.Marshal(candidates)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode visits: %w", err)
+	}
+
+	var overlapping []struct {
+		Idx db.RawInt `json:"idx"`
+	}
+	err = s.client.Prisma.QueryRaw(`
+		SELECT x.idx FROM jsonb_to_recordset($3::jsonb) AS x(idx int, street_id text, entry_timestamp bigint, exit_timestamp bigint)
+		WHERE EXISTS (
+			SELECT 1 FROM visited_streets v
+			WHERE v.user_id = $1 AND v.session_id <> $2 AND v.street_id = x.street_id
+				AND v.entry_timestamp <= x.exit_timestamp + $4
+				AND COALESCE(v.exit_timestamp, v.entry_timestamp) >= x.entry_timestamp - $4
+		)`,
+		clerkUserID, sessionID, string(payload), importOverlapMillis,
+	).Exec(ctx, &overlapping)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to check recorded visits: %w", err)
+	}
+
+	skip := make(map[int]bool, len(overlapping))
+	for _, row := range overlapping {
+		skip[int(row.Idx)] = true
+	}
+	kept := make([]types.VisitedStreetRequest, 0, len(streets)-len(skip))
+	for i, street := range streets {
+		if !skip[i] {
+			kept = append(kept, street)
+		}
+	}
+
+	return kept, len(skip), nil
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"citystatAPI/prisma/db"
+	"citystatAPI/types"
+	"citystatAPI/utils"
+)
+
+// XP awarded per unit of each source
+const (
+	XpPerNewStreet   = 10
+	XpPerKilometer   = 5
+	XpPerStreakDay   = 20
+	XpPerAchievement = 100
+)
+
+// DefaultLevelThresholds are the XP levels 2 and up start at when no thresholds are configured
+var DefaultLevelThresholds = []int{100, 250, 500, 1000, 2000, 3500, 5500, 8000, 11000, 15000}
+
+// LevelPolicy turns XP into levels
+type LevelPolicy struct {
+	// Thresholds are the XP levels 2, 3 and so on start at, in ascending order
+	Thresholds []int
+}
+
+// LevelTier names a range of levels and gives it a color, shown with the user's name the way
+// role colors are
+type LevelTier struct {
+	MinLevel int
+	Title    string
+	Color    string
+}
+
+// LevelTiers are the tiers from the lowest level up
+var LevelTiers = []LevelTier{
+	{MinLevel: 1, Title: "Newcomer", Color: "#9E9E9E"},
+	{MinLevel: 3, Title: "Wanderer", Color: "#4CAF50"},
+	{MinLevel: 5, Title: "Explorer", Color: "#2196F3"},
+	{MinLevel: 8, Title: "Pathfinder", Color: "#9C27B0"},
+	{MinLevel: 11, Title: "Trailblazer", Color: "#FF9800"},
+}
+
+// ParseLevelThresholds parses comma separated level thresholds, which must be strictly ascending
+// positive numbers
+func ParseLevelThresholds(value string) ([]int, error) {
+	var thresholds []int
+	for _, field := range strings.Split(value, ",") {
+		parsed, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || parsed < 1 || (len(thresholds) > 0 && parsed <= thresholds[len(thresholds)-1]) {
+			return nil, fmt.Errorf("invalid level thresholds %q: expected ascending positive numbers", value)
+		}
+		thresholds = append(thresholds, parsed)
+	}
+	return thresholds, nil
+}
+
+// LevelPolicyFromEnv reads XP_LEVEL_THRESHOLDS, the comma separated XP levels 2 and up start at,
+// falling back to the defaults when it is unset. The server and the tools that recompute levels
+// share it, so they never store levels from different thresholds.
+func LevelPolicyFromEnv() (LevelPolicy, error) {
+	policy := LevelPolicy{Thresholds: DefaultLevelThresholds}
+	if value := os.Getenv("XP_LEVEL_THRESHOLDS"); value != "" {
+		thresholds, err := ParseLevelThresholds(value)
+		if err != nil {
+			return policy, fmt.Errorf("XP_LEVEL_THRESHOLDS: %w", err)
+		}
+		policy.Thresholds = thresholds
+	}
+	return policy, nil
+}
+
+// Level returns the level xp reaches, starting at 1
+func (p LevelPolicy) Level(xp int) int {
+	return 1 + sort.Search(len(p.Thresholds), func(i int) bool { return p.Thresholds[i] > xp })
+}
+
+func (p LevelPolicy) levelResult(xp int) types.LevelResult {
+	level := p.Level(xp)
+	result := types.LevelResult{Level: level, Xp: xp}
+	if level > 1 {
+		result.LevelXp = p.Thresholds[level-2]
+	}
+	if level <= len(p.Thresholds) {
+		next := p.Thresholds[level-1]
+		result.NextLevelXp = &next
+	}
+	for _, tier := range LevelTiers {
+		if level >= tier.MinLevel {
+			result.Title, result.Color = tier.Title, tier.Color
+		}
+	}
+	return result
+}
+
+type XpService struct {
+	client *db.PrismaClient
+	policy LevelPolicy
+}
+
+func NewXpService(client *db.PrismaClient, policy LevelPolicy) *XpService {
+	return &XpService{client: client, policy: policy}
+}
+
+// Evaluate awards XP for the user's streets, distance and streak days since the last award, and
+// for achievements not yet rewarded, then stores their XP and level. Users who turned off in-app
+// rewards are skipped; they catch up once they turn it back on.
+func (s *XpService) Evaluate(ctx context.Context, clerkUserID string) ([]types.XpEventResult, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, clerkUserID)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	totals, err := s.totals(ctx, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	type sourceRow struct {
+		Source string `json:"source"`
+		Total  int    `json:"total"`
+		Rate   int    `json:"rate"`
+	}
+	sources := []sourceRow{
+		{Source: types.XpSourceStreets, Total: totals[types.XpSourceStreets], Rate: XpPerNewStreet},
+		{Source: types.XpSourceDistance, Total: totals[types.XpSourceDistance], Rate: XpPerKilometer},
+		{Source: types.XpSourceStreak, Total: totals[types.XpSourceStreak], Rate: XpPerStreakDay},
+	}
+	sourcePayload, err := json.Marshal(sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode XP sources: %w", err)
+	}
+
+	achievements, err := s.client.Achievement.FindMany(
+		db.Achievement.UserID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load achievements: %w", err)
+	}
+	codes := make([]string, len(achievements))
+	for i, achievement := range achievements {
+		codes[i] = achievement.Code
+	}
+	codePayload, err := json.Marshal(codes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode achievements: %w", err)
+	}
+	thresholdPayload, err := json.Marshal(s.policy.Thresholds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode level thresholds: %w", err)
+	}
+
+	// the awarded XP is read and extended under the user's lock, so concurrent evaluations cannot
+	// award the same growth twice under different totals. Totals can shrink when visits are deleted;
+	// XP already awarded is kept and only new growth counts.
+	award := s.client.Prisma.QueryRaw(`
+		INSERT INTO xp_events (id, user_id, source, ref, quantity, amount)
+		SELECT gen_random_uuid()::text, $1, source, ref, quantity, amount FROM (
+			SELECT x.source, x.total::text AS ref, x.total - a.awarded AS quantity, (x.total - a.awarded) * x.rate AS amount
+			FROM jsonb_to_recordset($2::jsonb) AS x(source text, total int, rate int)
+			CROSS JOIN LATERAL (
+				SELECT COALESCE(SUM(quantity), 0)::int AS awarded FROM xp_events WHERE user_id = $1 AND source = x.source
+			) a
+			WHERE x.total > a.awarded
+			UNION ALL
+			SELECT $3::text, code, 1, $4::int FROM jsonb_array_elements_text($5::jsonb) AS code
+		) events
+		ON CONFLICT (user_id, source, ref) DO NOTHING
+		RETURNING source, ref, quantity, amount, created_at`,
+		clerkUserID, string(sourcePayload), types.XpSourceAchievement, XpPerAchievement, string(codePayload),
+	).Tx()
+	// the level is stored even without new XP, so changed thresholds apply on the next evaluation
+	store := s.client.Prisma.ExecuteRaw(`
+		UPDATE users SET xp = total.xp, level = 1 + (
+			SELECT COUNT(*) FROM jsonb_array_elements_text($2::jsonb) AS threshold WHERE threshold::int <= total.xp
+		), "updatedAt" = now()
+		FROM (SELECT COALESCE(SUM(amount), 0)::int AS xp FROM xp_events WHERE user_id = $1) AS total
+		WHERE id = $1`,
+		clerkUserID, string(thresholdPayload),
+	).Tx()
+	if err := s.client.Prisma.Transaction(lockUser(s.client, clerkUserID), award, store).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to award XP: %w", err)
+	}
+
+	var inserted []struct {
+		Source    db.RawString   `json:"source"`
+		Ref       db.RawString   `json:"ref"`
+		Quantity  db.RawInt      `json:"quantity"`
+		Amount    db.RawInt      `json:"amount"`
+		CreatedAt db.RawDateTime `json:"created_at"`
+	}
+	if err := award.Into(&inserted); err != nil {
+		return nil, fmt.Errorf("failed to read awarded XP: %w", err)
+	}
+
+	results := make([]types.XpEventResult, len(inserted))
+	for i, row := range inserted {
+		results[i] = types.XpEventResult{
+			Source:    string(row.Source),
+			Ref:       string(row.Ref),
+			Quantity:  int(row.Quantity),
+			Amount:    int(row.Amount),
+			CreatedAt: row.CreatedAt.Time.Format(time.RFC3339),
+		}
+	}
+	return results, nil
+}
+
+// Xp returns the user's level and a page of their XP history, newest first
+func (s *XpService) Xp(ctx context.Context, clerkUserID string, limit, offset int) (*types.XpResponse, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.client.User.FindUnique(
+		db.User.ID.Equals(clerkUserID),
+	).Exec(ctx)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	events, err := s.client.XpEvent.FindMany(
+		db.XpEvent.UserID.Equals(clerkUserID),
+	).OrderBy(
+		db.XpEvent.CreatedAt.Order(db.DESC),
+	).Skip(offset).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load XP history: %w", err)
+	}
+
+	response := &types.XpResponse{
+		Enabled: enabled,
+		Level:   s.policy.levelResult(user.Xp),
+		History: make([]types.XpEventResult, len(events)),
+	}
+	for i, event := range events {
+		response.History[i] = types.XpEventResult{
+			Source:    event.Source,
+			Ref:       event.Ref,
+			Quantity:  event.Quantity,
+			Amount:    event.Amount,
+			CreatedAt: event.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return response, nil
+}
+
+// Level returns the level of a user as shown to others, nil when they turned off in-app rewards
+func (s *XpService) Level(ctx context.Context, user *db.UserModel) (*types.LevelResult, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, user.ID)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	level := s.policy.levelResult(user.Xp)
+	return &level, nil
+}
+
+// OwnLevel returns the level of a user as shown to themselves, along with whether they allow in-app
+// rewards; users who turned them off still see the level they reached
+func (s *XpService) OwnLevel(ctx context.Context, user *db.UserModel) (types.LevelResult, bool, error) {
+	enabled, err := rewardsEnabled(ctx, s.client, user.ID)
+	if err != nil {
+		return types.LevelResult{}, false, err
+	}
+	return s.policy.levelResult(user.Xp), enabled, nil
+}
+
+// NameColor returns the color to show a user's name in for the viewer: their level tier's, unless
+// the viewer turned role colors off
+func (s *XpService) NameColor(ctx context.Context, viewerID string, level *types.LevelResult) (*string, error) {
+	if level == nil {
+		return nil, nil
+	}
+	settings, err := s.client.Settings.FindUnique(
+		db.Settings.UserID.Equals(viewerID),
+	).Exec(ctx)
+	if err != nil && err != db.ErrNotFound {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	if settings != nil && settings.ShowRoleColors == db.RoleColorsDontshow {
+		return nil, nil
+	}
+	color := level.Color
+	return &color, nil
+}
+
+// totals measures the user on every cumulative XP source, from visits that are not flagged
+func (s *XpService) totals(ctx context.Context, clerkUserID string) (map[string]int, error) {
+	var rows []struct {
+		Streets    db.RawInt   `json:"streets"`
+		Kilometers db.RawFloat `json:"kilometers"`
+	}
+	err := s.client.Prisma.QueryRaw(`
+		SELECT
+			(SELECT COUNT(DISTINCT street_id) FROM visited_streets WHERE user_id = $1 AND NOT flagged)::int AS streets,
+			(SELECT COALESCE(SUM("totalKilometers"), 0) FROM city_stats WHERE "userId" = $1)::float8 AS kilometers`,
+		clerkUserID,
+	).Exec(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure XP: %w", err)
+	}
+
+	loc, err := userLocation(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := activeDays(ctx, s.client, clerkUserID, loc, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	frozen, err := frozenDays(ctx, s.client, clerkUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]int{
+		types.XpSourceStreak: utils.Streaks(days, frozen, utils.LocalDay(time.Now(), loc)).ContinuedDays,
+	}
+	if len(rows) > 0 {
+		totals[types.XpSourceStreets] = int(rows[0].Streets)
+		totals[types.XpSourceDistance] = int(math.Floor(float64(rows[0].Kilometers)))
+	}
+	return totals, nil
+}
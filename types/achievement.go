@@ -0,0 +1,35 @@
+package types
+
+// Metrics achievement rules are measured on
+const (
+	// AchievementMetricStreets counts distinct streets walked
+	AchievementMetricStreets = "streets"
+	// AchievementMetricKilometers is the distance walked over all cities
+	AchievementMetricKilometers = "kilometers"
+	// AchievementMetricStreakDays is the longest run of consecutive days with a walk
+	AchievementMetricStreakDays = "streakDays"
+	// AchievementMetricDistricts counts districts with at least one walked street
+	AchievementMetricDistricts = "districts"
+	// AchievementMetricCities counts cities walked in
+	AchievementMetricCities = "cities"
+	// AchievementMetricWalks counts walk sessions
+	AchievementMetricWalks = "walks"
+)
+
+type AchievementResult struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Metric      string `json:"metric"`
+	Threshold   int    `json:"threshold"`
+	// Progress is the user's current value of the metric, only given for their own achievements
+	Progress *int `json:"progress,omitempty"`
+	// AwardedAt is null until the badge is earned
+	AwardedAt *string `json:"awardedAt"`
+}
+
+type AchievementsResponse struct {
+	// Enabled is false when the user turned off in-app rewards; no badges are awarded or shown then
+	Enabled      bool                `json:"enabled"`
+	Achievements []AchievementResult `json:"achievements"`
+}
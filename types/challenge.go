@@ -0,0 +1,66 @@
+package types
+
+// Where a challenge's window stands
+const (
+	ChallengeStateUpcoming = "upcoming"
+	ChallengeStateActive   = "active"
+	// ChallengeStateEnded challenges are past their window; results are frozen once finalizedAt is set
+	ChallengeStateEnded = "ended"
+)
+
+type CreateChallengeRequest struct {
+	Title  string `json:"title"`
+	Metric string `json:"metric"`
+	// Target is the value to reach, such as 50 for "walk 50 km"; null just ranks the participants
+	Target *float64 `json:"target"`
+	CityID *string  `json:"cityId"`
+	// StartsAt and EndsAt are RFC3339 times
+	StartsAt string `json:"startsAt"`
+	EndsAt   string `json:"endsAt"`
+	// InviteUserIDs are friends of the creator to invite
+	InviteUserIDs []string `json:"inviteUserIds"`
+}
+
+type InviteToChallengeRequest struct {
+	UserIDs []string `json:"userIds"`
+}
+
+type ChallengeParticipantResult struct {
+	UserID    string  `json:"userId"`
+	UserName  *string `json:"userName"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	ImageURL  string  `json:"imageUrl"`
+	// Status is INVITED, JOINED or DECLINED
+	Status string `json:"status"`
+	// Rank and Value are set for joined participants; they are frozen once the challenge ended
+	Rank          *int     `json:"rank"`
+	Value         *float64 `json:"value"`
+	ReachedTarget bool     `json:"reachedTarget"`
+	IsCaller      bool     `json:"isCaller"`
+}
+
+type ChallengeResponse struct {
+	ID          string   `json:"id"`
+	CreatorID   string   `json:"creatorId"`
+	Title       string   `json:"title"`
+	Metric      string   `json:"metric"`
+	Target      *float64 `json:"target"`
+	CityID      *string  `json:"cityId"`
+	StartsAt    string   `json:"startsAt"`
+	EndsAt      string   `json:"endsAt"`
+	State       string   `json:"state"`
+	FinalizedAt *string  `json:"finalizedAt"`
+	// CallerStatus is the caller's own participant status
+	CallerStatus string `json:"callerStatus"`
+	// Participants are ranked by value, and left out of challenge lists
+	Participants []ChallengeParticipantResult `json:"participants,omitempty"`
+}
+
+type ChallengesResponse struct {
+	Challenges []ChallengeResponse `json:"challenges"`
+}
+
+type ChallengeFinalizeResult struct {
+	Finalized int `json:"finalized"`
+}
@@ -0,0 +1,34 @@
+package types
+
+// Purposes the user's data may be used for, each backed by a Settings flag
+const (
+	// ConsentLocationTracking (enabledLocationTracking) covers storing visited streets and tracks
+	ConsentLocationTracking = "locationTracking"
+	// ConsentCityStatDataUsage (allowCityStatDataUsage) covers aggregating visits into stats,
+	// coverage and activity, and counting the user in aggregates across users
+	ConsentCityStatDataUsage = "cityStatDataUsage"
+	// ConsentPersonalization (allowDataPersonalizationUsage) covers suggestions derived from the
+	// user's history, such as the nearest incomplete district
+	ConsentPersonalization = "personalization"
+	// ConsentAnalytics (allowDataAnaliticsAndPerformance) covers device details kept for
+	// diagnostics and performance
+	ConsentAnalytics = "analytics"
+)
+
+type ConsentsResponse struct {
+	LocationTracking  bool `json:"locationTracking"`
+	CityStatDataUsage bool `json:"cityStatDataUsage"`
+	Personalization   bool `json:"personalization"`
+	Analytics         bool `json:"analytics"`
+}
+
+type ConsentEventResult struct {
+	Purpose    string `json:"purpose"`
+	Granted    bool   `json:"granted"`
+	Source     string `json:"source"`
+	RecordedAt string `json:"recordedAt"`
+}
+
+type ConsentLedgerResponse struct {
+	Events []ConsentEventResult `json:"events"`
+}
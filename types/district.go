@@ -0,0 +1,27 @@
+package types
+
+// Levels of imported district boundaries
+const (
+	DistrictLevelDistrict     = "district"
+	DistrictLevelNeighborhood = "neighborhood"
+)
+
+type DistrictCoverage struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Level            string  `json:"level"`
+	StreetCount      int     `json:"streetCount"`
+	StreetsWalked    int     `json:"streetsWalked"`
+	TotalKilometers  float64 `json:"totalKilometers"`
+	WalkedKilometers float64 `json:"walkedKilometers"`
+	CoveragePct      float64 `json:"coveragePct"`
+	// DistanceMeters is how far the reference point is from the district, 0 when inside it
+	DistanceMeters *float64 `json:"distanceMeters,omitempty"`
+}
+
+type DistrictCoverageResponse struct {
+	CityID    string             `json:"cityId"`
+	Districts []DistrictCoverage `json:"districts"`
+	// NearestIncomplete is the closest district not fully walked; nil without a reference point
+	NearestIncomplete *DistrictCoverage `json:"nearestIncomplete"`
+}
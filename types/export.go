@@ -0,0 +1,34 @@
+package types
+
+import "encoding/json"
+
+// ExportFilter narrows an export; zero values mean no filter. From and To are unix milliseconds
+// compared against a visit's entry timestamp, To being exclusive.
+type ExportFilter struct {
+	From      *int64
+	To        *int64
+	SessionID string
+	CityID    string
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties interface{}     `json:"properties"`
+}
+
+type WalkedStreetProperties struct {
+	StreetID     string  `json:"streetId"`
+	StreetName   string  `json:"streetName"`
+	CityID       *string `json:"cityId"`
+	FirstVisit   string  `json:"firstVisit"`
+	LastVisit    string  `json:"lastVisit"`
+	VisitCount   int     `json:"visitCount"`
+	TotalSeconds int     `json:"totalSeconds"`
+}
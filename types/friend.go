@@ -47,4 +47,10 @@ type GetFriendProfileResponse struct {
 	ImageURL  *string `json:"imageUrl"`
 	CreatedAt string  `json:"createdAt"`
 	UpdatedAt string  `json:"updatedAt"`
+	// Achievements are the badges the friend earned, empty when they turned off in-app rewards
+	Achievements []AchievementResult `json:"achievements"`
+	// Level is null when the friend turned off in-app rewards
+	Level *LevelResult `json:"level"`
+	// NameColor is the friend's level tier color, null when the caller turned role colors off
+	NameColor *string `json:"nameColor"`
 }
\ No newline at end of file
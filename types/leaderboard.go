@@ -0,0 +1,71 @@
+package types
+
+// What a leaderboard ranks by
+const (
+	// LeaderboardMetricStreets counts distinct streets walked in the period
+	LeaderboardMetricStreets = "streets"
+	// LeaderboardMetricKilometers is the distance walked in the period
+	LeaderboardMetricKilometers = "kilometers"
+	// LeaderboardMetricCoverage is the percentage of a city's street length first walked in the period
+	LeaderboardMetricCoverage = "coverage"
+	// LeaderboardMetricNewStreets counts streets walked for the first time in the period
+	LeaderboardMetricNewStreets = "newStreets"
+)
+
+// Periods a leaderboard covers. Weeks and months are calendar ones in the caller's time zone on
+// friend leaderboards, and in UTC on city and global ones.
+const (
+	LeaderboardPeriodWeek  = "week"
+	LeaderboardPeriodMonth = "month"
+	LeaderboardPeriodAll   = "all"
+)
+
+// LeaderboardScopeGlobal is the scope of the leaderboard over all users and cities; city
+// leaderboards are scoped by their city id
+const LeaderboardScopeGlobal = "global"
+
+type LeaderboardEntry struct {
+	// Rank is shared by equal values, so two users tied for first are followed by third
+	Rank      int     `json:"rank"`
+	UserID    string  `json:"userId"`
+	UserName  *string `json:"userName"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	ImageURL  string  `json:"imageUrl"`
+	Value     float64 `json:"value"`
+	IsCaller  bool    `json:"isCaller"`
+}
+
+type LeaderboardResponse struct {
+	Metric string  `json:"metric"`
+	Period string  `json:"period"`
+	CityID *string `json:"cityId"`
+	// Since is when the period started, null for all time
+	Since   *string            `json:"since"`
+	Entries []LeaderboardEntry `json:"entries"`
+	// CallerRank is null when the caller is left out because they turned off city stat data usage
+	CallerRank *int `json:"callerRank"`
+}
+
+// RankedLeaderboardResponse is a page of a city or global leaderboard
+type RankedLeaderboardResponse struct {
+	Metric string `json:"metric"`
+	Period string `json:"period"`
+	// CityID is null on the global leaderboard
+	CityID *string `json:"cityId"`
+	// Since is when the period started, null for all time
+	Since *string `json:"since"`
+	// ComputedAt is when the leaderboard was last ranked, null while it is empty
+	ComputedAt *string            `json:"computedAt"`
+	Total      int                `json:"total"`
+	Limit      int                `json:"limit"`
+	Offset     int                `json:"offset"`
+	Entries    []LeaderboardEntry `json:"entries"`
+	// Caller is the caller's own entry, null when they are not on the leaderboard
+	Caller *LeaderboardEntry `json:"caller"`
+}
+
+type LeaderboardRankingResult struct {
+	Boards  int `json:"boards"`
+	Entries int `json:"entries"`
+}
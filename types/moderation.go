@@ -0,0 +1,34 @@
+package types
+
+// Moderator decisions on a movement flag
+const (
+	FlagActionConfirm = "confirm"
+	FlagActionDismiss = "dismiss"
+)
+
+type ResolveFlagRequest struct {
+	Action string  `json:"action"`
+	Note   *string `json:"note,omitempty"`
+}
+
+type MovementFlagResult struct {
+	ID              string   `json:"id"`
+	UserID          string   `json:"userId"`
+	SessionID       string   `json:"sessionId"`
+	VisitedStreetID string   `json:"visitedStreetId"`
+	StreetID        string   `json:"streetId"`
+	StreetName      string   `json:"streetName"`
+	EntryTimestamp  int64    `json:"entryTimestamp"`
+	Reason          string   `json:"reason"`
+	SpeedMps        *float64 `json:"speedMps"`
+	DistanceMeters  *float64 `json:"distanceMeters"`
+	Status          string   `json:"status"`
+	CreatedAt       string   `json:"createdAt"`
+	ReviewedBy      *string  `json:"reviewedBy"`
+	ReviewedAt      *string  `json:"reviewedAt"`
+	ReviewNote      *string  `json:"reviewNote"`
+}
+
+type MovementFlagsListResponse struct {
+	Flags []MovementFlagResult `json:"flags"`
+}
@@ -0,0 +1,62 @@
+package types
+
+import "encoding/json"
+
+// Shapes of a privacy zone
+const (
+	PrivacyZoneCircle  = "circle"
+	PrivacyZonePolygon = "polygon"
+)
+
+// CreatePrivacyZoneRequest describes a circle with Latitude, Longitude and RadiusMeters, or a
+// polygon with a GeoJSON Polygon or MultiPolygon geometry
+type CreatePrivacyZoneRequest struct {
+	Name         string          `json:"name"`
+	Latitude     *float64        `json:"latitude,omitempty"`
+	Longitude    *float64        `json:"longitude,omitempty"`
+	RadiusMeters *float64        `json:"radiusMeters,omitempty"`
+	Polygon      json.RawMessage `json:"polygon,omitempty"`
+}
+
+type PrivacyZoneResult struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Shape        string          `json:"shape"`
+	Latitude     *float64        `json:"latitude,omitempty"`
+	Longitude    *float64        `json:"longitude,omitempty"`
+	RadiusMeters *float64        `json:"radiusMeters,omitempty"`
+	Polygon      json.RawMessage `json:"polygon,omitempty"`
+	CreatedAt    string          `json:"createdAt"`
+}
+
+type CreatePrivacyZoneResponse struct {
+	Zone PrivacyZoneResult `json:"zone"`
+	// RedactedVisits counts already stored visits that were coarsened by the new zone
+	RedactedVisits int `json:"redactedVisits"`
+}
+
+type PrivacyZonesListResponse struct {
+	Zones []PrivacyZoneResult `json:"zones"`
+}
+
+// RetentionRequest sets how many days precise coordinates are kept. Null goes back to the
+// server default; a value can only shorten it.
+type RetentionRequest struct {
+	RetentionDays *int `json:"retentionDays"`
+}
+
+type RetentionResponse struct {
+	// RetentionDays is the user's own choice, null when the server default applies
+	RetentionDays *int `json:"retentionDays"`
+	// CoarsenAfterDays and PurgeAfterDays are what applies to this user: entry points are coarsened
+	// after the first and removed after the second, while street-level stats are kept
+	CoarsenAfterDays int `json:"coarsenAfterDays"`
+	PurgeAfterDays   int `json:"purgeAfterDays"`
+	// DefaultPurgeDays is the longest retention a user can choose
+	DefaultPurgeDays int `json:"defaultPurgeDays"`
+}
+
+type RetentionRunResult struct {
+	CoarsenedVisits int `json:"coarsenedVisits"`
+	PurgedVisits    int `json:"purgedVisits"`
+}
@@ -0,0 +1,30 @@
+package types
+
+type StartSessionRequest struct {
+	// SessionID lets offline clients pick the id up front; generated when omitted
+	SessionID *string `json:"sessionId,omitempty"`
+	Device    *string `json:"device,omitempty"`
+	// StartedAt is a unix timestamp in milliseconds; defaults to now
+	StartedAt *int64 `json:"startedAt,omitempty"`
+}
+
+type StopSessionRequest struct {
+	// EndedAt is a unix timestamp in milliseconds; defaults to now
+	EndedAt *int64 `json:"endedAt,omitempty"`
+}
+
+type WalkSessionResult struct {
+	ID              string  `json:"id"`
+	Status          string  `json:"status"`
+	Device          *string `json:"device"`
+	StartedAt       string  `json:"startedAt"`
+	EndedAt         *string `json:"endedAt"`
+	DurationSeconds int     `json:"durationSeconds"`
+	DistanceKm      float64 `json:"distanceKm"`
+	StreetsVisited  int     `json:"streetsVisited"`
+	NewStreets      int     `json:"newStreets"`
+}
+
+type WalkSessionsListResponse struct {
+	Sessions []WalkSessionResult `json:"sessions"`
+}
@@ -0,0 +1,41 @@
+package types
+
+type TimeseriesBucket struct {
+	// Start is the first local day of the bucket, YYYY-MM-DD
+	Start string `json:"start"`
+	// NewStreets counts streets walked for the first time ever in this bucket
+	NewStreets int `json:"newStreets"`
+	// StreetsWalked counts distinct streets walked in this bucket
+	StreetsWalked int `json:"streetsWalked"`
+	// TotalStreets is the running total of distinct streets walked up to the end of this bucket
+	TotalStreets  int     `json:"totalStreets"`
+	DistanceKm    float64 `json:"distanceKm"`
+	ActiveMinutes int     `json:"activeMinutes"`
+}
+
+type TimeseriesResponse struct {
+	Interval string             `json:"interval"`
+	TimeZone string             `json:"timeZone"`
+	Buckets  []TimeseriesBucket `json:"buckets"`
+}
+
+// StreakStateResponse reports the user's streaks in their profile time zone. A day counts once it
+// has a visit that is not flagged; frozen days keep a streak going without adding to it.
+type StreakStateResponse struct {
+	TimeZone string `json:"timeZone"`
+	// Today is the current day in TimeZone, YYYY-MM-DD
+	Today             string `json:"today"`
+	CurrentStreakDays int    `json:"currentStreakDays"`
+	LongestStreakDays int    `json:"longestStreakDays"`
+	DaysActive        int    `json:"daysActive"`
+	// TodayActive is true once today's activity counts, and so is part of CurrentStreakDays
+	TodayActive      bool     `json:"todayActive"`
+	FreezesEarned    int      `json:"freezesEarned"`
+	FreezesAvailable int      `json:"freezesAvailable"`
+	FrozenDays       []string `json:"frozenDays"`
+}
+
+// SpendStreakFreezeRequest freezes Day, YYYY-MM-DD in the user's time zone, or yesterday when empty
+type SpendStreakFreezeRequest struct {
+	Day string `json:"day,omitempty"`
+}
@@ -0,0 +1,76 @@
+package types
+
+// Entities carried by the sync change log
+const (
+	SyncEntityVisit    = "visit"
+	SyncEntitySession  = "session"
+	SyncEntityStats    = "stats"
+	SyncEntitySettings = "settings"
+	SyncEntityFriend   = "friend"
+)
+
+type SyncPushRequest struct {
+	// IdempotencyKey identifies the batch; a retry with the same key returns the first outcome
+	IdempotencyKey string              `json:"idempotencyKey"`
+	DeviceID       *string             `json:"deviceId,omitempty"`
+	Sessions       []SyncSessionUpload `json:"sessions"`
+}
+
+// SyncSessionUpload is one walk recorded offline. The session is created from StartedAt when
+// the server has not seen it yet and stopped when EndedAt is set.
+type SyncSessionUpload struct {
+	SessionID      string                 `json:"sessionId"`
+	StartedAt      *int64                 `json:"startedAt,omitempty"`
+	EndedAt        *int64                 `json:"endedAt,omitempty"`
+	VisitedStreets []VisitedStreetRequest `json:"visitedStreets"`
+}
+
+type SyncSessionResult struct {
+	SessionID string                     `json:"sessionId"`
+	Streets   SaveVisitedStreetsResponse `json:"streets"`
+	Session   *WalkSessionResult         `json:"session"`
+}
+
+type SyncPushResponse struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+	// Replayed is true when the batch was already applied and this is the stored outcome
+	Replayed bool                `json:"replayed"`
+	Sessions []SyncSessionResult `json:"sessions"`
+}
+
+type SyncVisit struct {
+	ID              string `json:"id"`
+	SessionID       string `json:"sessionId"`
+	StreetID        string `json:"streetId"`
+	StreetName      string `json:"streetName"`
+	EntryTimestamp  int64  `json:"entryTimestamp"`
+	ExitTimestamp   *int64 `json:"exitTimestamp"`
+	DurationSeconds *int   `json:"durationSeconds"`
+	// EntryLatitude and EntryLongitude are null once the retention period is over
+	EntryLatitude  *float64 `json:"entryLatitude"`
+	EntryLongitude *float64 `json:"entryLongitude"`
+	Flagged        bool     `json:"flagged"`
+	// Redacted entry points were inside a privacy zone or are past the retention period, and are coarsened
+	Redacted bool    `json:"redacted"`
+	CityID   *string `json:"cityId"`
+}
+
+type SyncDeletion struct {
+	Entity string `json:"entity"`
+	ID     string `json:"id"`
+}
+
+// SyncPullResponse holds the current state of everything that changed after the request cursor.
+// Stats and Settings have the same shape as GET /api/stats and GET /api/settings; CityStats lists
+// the changed city stats, each shaped like Stats. They are only present when they changed.
+type SyncPullResponse struct {
+	Cursor    string              `json:"cursor"`
+	HasMore   bool                `json:"hasMore"`
+	Sessions  []WalkSessionResult `json:"sessions"`
+	Visits    []SyncVisit         `json:"visits"`
+	Friends   []FriendResult      `json:"friends"`
+	Stats     interface{}         `json:"stats,omitempty"`
+	CityStats interface{}         `json:"cityStats,omitempty"`
+	Settings  interface{}         `json:"settings,omitempty"`
+	Deleted   []SyncDeletion      `json:"deleted"`
+}
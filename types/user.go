@@ -7,6 +7,8 @@ type UserUpdateRequest struct {
 	UserName *string `json:"userName,omitempty"`
 	ImageURL  *string `json:"imageUrl,omitempty"`
 	CompletedTutorial *bool   `json:"completedTutorial,omitempty"`
+	// TimeZone is an IANA name such as Europe/Sofia; streaks and days are counted in it
+	TimeZone *string `json:"timeZone,omitempty"`
 }
 
 
@@ -22,4 +22,63 @@ type VisitedStreetRequest struct {
     DurationSeconds *int     `json:"durationSeconds,omitempty"`
     EntryLatitude   float64  `json:"entryLatitude"`
     EntryLongitude  float64  `json:"entryLongitude"`
-}
\ No newline at end of file
+}
+
+// Per-item outcome of a visited streets ingest
+const (
+	VisitStatusInserted  = "inserted"
+	VisitStatusDuplicate = "duplicate"
+	VisitStatusRejected  = "rejected"
+	// stored, but left out of stats until a moderator reviews it
+	VisitStatusFlagged = "flagged"
+)
+
+type VisitedStreetResult struct {
+	Index          int    `json:"index"`
+	StreetID       string `json:"streetId"`
+	EntryTimestamp int64  `json:"entryTimestamp"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type SaveVisitedStreetsResponse struct {
+	SessionID  string                `json:"sessionId"`
+	Inserted   int                   `json:"inserted"`
+	Duplicates int                   `json:"duplicates"`
+	Rejected   int                   `json:"rejected"`
+	Flagged    int                   `json:"flagged"`
+	Results    []VisitedStreetResult `json:"results"`
+}
+
+type TrackPointRequest struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+	// Timestamp is a unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// Accuracy is the horizontal accuracy radius in meters
+	Accuracy *float64 `json:"accuracy,omitempty"`
+	// Speed is in meters per second
+	Speed *float64 `json:"speed,omitempty"`
+}
+
+type SaveTrackRequest struct {
+	SessionID string              `json:"sessionId"`
+	Points    []TrackPointRequest `json:"points"`
+}
+
+type SaveTrackResponse struct {
+	PointsReceived int                        `json:"pointsReceived"`
+	StreetsMatched int                        `json:"streetsMatched"`
+	Streets        SaveVisitedStreetsResponse `json:"streets"`
+}
+
+type ImportTrackResponse struct {
+	SessionID      string `json:"sessionId"`
+	Format         string `json:"format"`
+	PointsRead     int    `json:"pointsRead"`
+	StreetsMatched int    `json:"streetsMatched"`
+	// AlreadyRecorded counts matched streets that overlap visits the user already has in another session
+	AlreadyRecorded int                        `json:"alreadyRecorded"`
+	Streets         SaveVisitedStreetsResponse `json:"streets"`
+	Session         *WalkSessionResult         `json:"session"`
+}
@@ -0,0 +1,37 @@
+package types
+
+// What XP is awarded for
+const (
+	XpSourceStreets     = "streets"
+	XpSourceDistance    = "distance"
+	XpSourceStreak      = "streak"
+	XpSourceAchievement = "achievement"
+)
+
+type LevelResult struct {
+	Level int `json:"level"`
+	Xp    int `json:"xp"`
+	// LevelXp is the XP the level starts at, NextLevelXp the XP the next one does; null at the top level
+	LevelXp     int  `json:"levelXp"`
+	NextLevelXp *int `json:"nextLevelXp"`
+	// Title and Color are the level's tier, shown with the user's name like a role
+	Title string `json:"title"`
+	Color string `json:"color"`
+}
+
+type XpEventResult struct {
+	Source string `json:"source"`
+	// Ref is the running total reached, or the achievement code
+	Ref       string `json:"ref"`
+	Quantity  int    `json:"quantity"`
+	Amount    int    `json:"amount"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type XpResponse struct {
+	// Enabled is false when the user turned off in-app rewards; no XP is awarded then
+	Enabled bool        `json:"enabled"`
+	Level   LevelResult `json:"level"`
+	// History lists XP events newest first
+	History []XpEventResult `json:"history"`
+}
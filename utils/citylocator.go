@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// boundaryStepMeters is the longest piece of a street attributed to a single area by LengthWithin
+const boundaryStepMeters = 20
+
+// Polygon is an outer ring followed by its holes
+type Polygon [][]LatLng
+
+// CityBoundary is the area of one city, made of one or more polygons
+type CityBoundary struct {
+	CityID   string
+	Polygons []Polygon
+}
+
+type locatedCity struct {
+	id                             string
+	polygons                       []Polygon
+	minLat, minLng, maxLat, maxLng float64
+	area                           float64
+}
+
+// CityLocator finds the city a coordinate lies in
+type CityLocator struct {
+	cities []locatedCity
+}
+
+func NewCityLocator(boundaries []CityBoundary) *CityLocator {
+	locator := &CityLocator{cities: make([]locatedCity, 0, len(boundaries))}
+	for _, boundary := range boundaries {
+		city := locatedCity{
+			id:       boundary.CityID,
+			polygons: boundary.Polygons,
+			minLat:   math.Inf(1),
+			minLng:   math.Inf(1),
+			maxLat:   math.Inf(-1),
+			maxLng:   math.Inf(-1),
+		}
+		for _, polygon := range boundary.Polygons {
+			if len(polygon) == 0 {
+				continue
+			}
+			for _, p := range polygon[0] {
+				city.minLat = math.Min(city.minLat, p.Lat)
+				city.minLng = math.Min(city.minLng, p.Lng)
+				city.maxLat = math.Max(city.maxLat, p.Lat)
+				city.maxLng = math.Max(city.maxLng, p.Lng)
+			}
+			city.area += math.Abs(ringArea(polygon[0]))
+		}
+		if city.area > 0 {
+			locator.cities = append(locator.cities, city)
+		}
+	}
+	return locator
+}
+
+// Len returns the number of cities with a usable boundary
+func (l *CityLocator) Len() int {
+	return len(l.cities)
+}
+
+// Locate returns the city containing p. Where boundaries overlap, such as a district imported as
+// its own city, the smallest one wins.
+func (l *CityLocator) Locate(p LatLng) (string, bool) {
+	best := -1
+	for i, city := range l.cities {
+		if p.Lat < city.minLat || p.Lat > city.maxLat || p.Lng < city.minLng || p.Lng > city.maxLng {
+			continue
+		}
+		if best >= 0 && city.area >= l.cities[best].area {
+			continue
+		}
+		for _, polygon := range city.polygons {
+			if polygonContains(polygon, p) {
+				best = i
+				break
+			}
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return l.cities[best].id, true
+}
+
+// LengthWithin splits a line into short pieces and returns how many meters of it lie in each
+// area, keyed by id. Pieces outside every area are dropped.
+func (l *CityLocator) LengthWithin(line []LatLng) map[string]float64 {
+	lengths := make(map[string]float64)
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		meters := HaversineMeters(a, b)
+		steps := max(1, int(math.Ceil(meters/boundaryStepMeters)))
+		for step := 0; step < steps; step++ {
+			t := (float64(step) + 0.5) / float64(steps)
+			mid := LatLng{Lat: a.Lat + t*(b.Lat-a.Lat), Lng: a.Lng + t*(b.Lng-a.Lng)}
+			if id, ok := l.Locate(mid); ok {
+				lengths[id] += meters / float64(steps)
+			}
+		}
+	}
+	return lengths
+}
+
+// PolygonsDistanceMeters returns how far p is from the nearest of the polygons, 0 when inside one
+func PolygonsDistanceMeters(polygons []Polygon, p LatLng) float64 {
+	best := math.Inf(1)
+	for _, polygon := range polygons {
+		if polygonContains(polygon, p) {
+			return 0
+		}
+		for _, ring := range polygon {
+			for i := 1; i < len(ring); i++ {
+				if _, d := projectOnSegment(p, ring[i-1], ring[i]); d < best {
+					best = d
+				}
+			}
+		}
+	}
+	return best
+}
+
+func polygonContains(polygon Polygon, p LatLng) bool {
+	if len(polygon) == 0 || !ringContains(polygon[0], p) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if ringContains(hole, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains is the even-odd ray casting test
+func ringContains(ring []LatLng, p LatLng) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ringArea is the shoelace area in square degrees, only used to rank overlapping boundaries
+func ringArea(ring []LatLng) float64 {
+	area := 0.0
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		area += (ring[j].Lng - ring[i].Lng) * (ring[j].Lat + ring[i].Lat)
+	}
+	return area / 2
+}
+
+// ParseBoundaryGeoJSON reads city polygons from a GeoJSON Polygon or MultiPolygon geometry, a
+// Feature holding one, or a FeatureCollection whose polygon features are combined
+func ParseBoundaryGeoJSON(raw []byte) ([]Polygon, error) {
+	var object struct {
+		Type        string            `json:"type"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometry    json.RawMessage   `json:"geometry"`
+		Features    []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+
+	switch object.Type {
+	case "Feature":
+		if len(object.Geometry) == 0 || string(object.Geometry) == "null" {
+			return nil, fmt.Errorf("feature has no geometry")
+		}
+		return ParseBoundaryGeoJSON(object.Geometry)
+	case "FeatureCollection":
+		var polygons []Polygon
+		for _, feature := range object.Features {
+			parsed, err := ParseBoundaryGeoJSON(feature)
+			if err != nil {
+				continue
+			}
+			polygons = append(polygons, parsed...)
+		}
+		if len(polygons) == 0 {
+			return nil, fmt.Errorf("no Polygon or MultiPolygon features found")
+		}
+		return polygons, nil
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(object.Coordinates, &rings); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		return []Polygon{toPolygon(rings)}, nil
+	case "MultiPolygon":
+		var multi [][][][]float64
+		if err := json.Unmarshal(object.Coordinates, &multi); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		polygons := make([]Polygon, 0, len(multi))
+		for _, rings := range multi {
+			polygons = append(polygons, toPolygon(rings))
+		}
+		return polygons, nil
+	}
+	return nil, fmt.Errorf("expected a Polygon or MultiPolygon, got %q", object.Type)
+}
+
+func toPolygon(rings [][][]float64) Polygon {
+	polygon := make(Polygon, 0, len(rings))
+	for _, ring := range rings {
+		polygon = append(polygon, toLatLngs(ring))
+	}
+	return polygon
+}
+
+// BoundaryGeometry encodes polygons as a GeoJSON MultiPolygon geometry
+func BoundaryGeometry(polygons []Polygon) ([]byte, error) {
+	coordinates := make([][][][2]float64, len(polygons))
+	for i, polygon := range polygons {
+		coordinates[i] = make([][][2]float64, len(polygon))
+		for j, ring := range polygon {
+			coordinates[i][j] = make([][2]float64, len(ring))
+			for k, p := range ring {
+				coordinates[i][j][k] = [2]float64{p.Lng, p.Lat}
+			}
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":        "MultiPolygon",
+		"coordinates": coordinates,
+	})
+}
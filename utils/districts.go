@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NamedBoundary is the area of a district or neighborhood inside a city
+type NamedBoundary struct {
+	Name     string
+	Polygons []Polygon
+}
+
+// ParseDistrictsGeoJSON reads a FeatureCollection of Polygon and MultiPolygon features named by
+// their "name" property. Features sharing a name are merged; unnamed and non-polygon features are
+// skipped.
+func ParseDistrictsGeoJSON(raw []byte) ([]NamedBoundary, error) {
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry   json.RawMessage        `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(raw, &collection); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got %q", collection.Type)
+	}
+
+	byName := make(map[string]int)
+	var districts []NamedBoundary
+	for _, feature := range collection.Features {
+		name, _ := feature.Properties["name"].(string)
+		name = strings.TrimSpace(name)
+		if name == "" || len(feature.Geometry) == 0 || string(feature.Geometry) == "null" {
+			continue
+		}
+		polygons, err := ParseBoundaryGeoJSON(feature.Geometry)
+		if err != nil {
+			continue
+		}
+
+		if i, ok := byName[name]; ok {
+			districts[i].Polygons = append(districts[i].Polygons, polygons...)
+			continue
+		}
+		byName[name] = len(districts)
+		districts = append(districts, NamedBoundary{Name: name, Polygons: polygons})
+	}
+	if len(districts) == 0 {
+		return nil, fmt.Errorf("no named Polygon or MultiPolygon features found")
+	}
+
+	return districts, nil
+}
@@ -0,0 +1,40 @@
+package utils
+
+import "math"
+
+const earthRadiusMeters = 6371008.8
+
+// LatLng is a WGS84 coordinate in degrees
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// HaversineMeters returns the great-circle distance between two coordinates
+func HaversineMeters(a, b LatLng) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// PathLengthMeters sums the distances between successive points of a path
+func PathLengthMeters(points []LatLng) float64 {
+	total := 0.0
+	for i := 1; i < len(points); i++ {
+		total += HaversineMeters(points[i-1], points[i])
+	}
+	return total
+}
+
+// CoveragePercent returns walked as a percentage of total, rounded to two decimals and capped at 100
+func CoveragePercent(walked, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(math.Min(walked/total, 1)*10000) / 100
+}
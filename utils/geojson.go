@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	ID         json.RawMessage        `json:"id"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// streetIDProperties are checked in order for a feature's street id when it has no top-level id
+var streetIDProperties = []string{"streetId", "id", "@id", "osm_id"}
+
+// ParseStreetsGeoJSON reads a FeatureCollection of LineString and MultiLineString features into streets.
+// Features of other geometry types or without an id are skipped.
+func ParseStreetsGeoJSON(r io.Reader) ([]Street, error) {
+	var collection geoJSONFeatureCollection
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got %q", collection.Type)
+	}
+
+	streets := make([]Street, 0, len(collection.Features))
+	for _, feature := range collection.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+
+		var lines [][]LatLng
+		switch feature.Geometry.Type {
+		case "LineString":
+			var coords [][]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &coords); err != nil {
+				return nil, fmt.Errorf("invalid LineString coordinates: %w", err)
+			}
+			lines = append(lines, toLatLngs(coords))
+		case "MultiLineString":
+			var coords [][][]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &coords); err != nil {
+				return nil, fmt.Errorf("invalid MultiLineString coordinates: %w", err)
+			}
+			for _, line := range coords {
+				lines = append(lines, toLatLngs(line))
+			}
+		default:
+			continue
+		}
+
+		id := featureID(feature)
+		if id == "" {
+			continue
+		}
+		name, _ := feature.Properties["name"].(string)
+
+		streets = append(streets, Street{ID: id, Name: name, Lines: lines})
+	}
+
+	return streets, nil
+}
+
+func featureID(feature geoJSONFeature) string {
+	if len(feature.ID) > 0 && string(feature.ID) != "null" {
+		return strings.Trim(string(feature.ID), `"`)
+	}
+	for _, key := range streetIDProperties {
+		switch value := feature.Properties[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return fmt.Sprintf("%.0f", value)
+		}
+	}
+	return ""
+}
+
+// ParseLineStringGeometry reads the points of a GeoJSON LineString geometry
+func ParseLineStringGeometry(raw []byte) ([]LatLng, error) {
+	var geometry struct {
+		Type        string      `json:"type"`
+		Coordinates [][]float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &geometry); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON geometry: %w", err)
+	}
+	if geometry.Type != "LineString" {
+		return nil, fmt.Errorf("expected a LineString, got %q", geometry.Type)
+	}
+	return toLatLngs(geometry.Coordinates), nil
+}
+
+// ParseLinesGeometry reads the lines of a GeoJSON LineString or MultiLineString geometry, one per part
+func ParseLinesGeometry(raw []byte) ([][]LatLng, error) {
+	var geometry geoJSONGeometry
+	if err := json.Unmarshal(raw, &geometry); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON geometry: %w", err)
+	}
+	switch geometry.Type {
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("invalid LineString coordinates: %w", err)
+		}
+		return [][]LatLng{toLatLngs(coords)}, nil
+	case "MultiLineString":
+		var coords [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("invalid MultiLineString coordinates: %w", err)
+		}
+		lines := make([][]LatLng, 0, len(coords))
+		for _, line := range coords {
+			lines = append(lines, toLatLngs(line))
+		}
+		return lines, nil
+	}
+	return nil, fmt.Errorf("expected a LineString or MultiLineString, got %q", geometry.Type)
+}
+
+// toLatLngs converts GeoJSON [lng, lat] positions
+func toLatLngs(coords [][]float64) []LatLng {
+	points := make([]LatLng, 0, len(coords))
+	for _, c := range coords {
+		if len(c) < 2 {
+			continue
+		}
+		points = append(points, LatLng{Lat: c[1], Lng: c[0]})
+	}
+	return points
+}
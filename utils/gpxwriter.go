@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// GPXPoint is a track point written to a GPX file
+type GPXPoint struct {
+	Lat  float64
+	Lng  float64
+	Time time.Time
+	Name string
+}
+
+type gpxDocument struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Track    gpxTrack    `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Time string `xml:"time"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Type     string       `xml:"type"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxTrackPoint `xml:"trkpt"`
+}
+
+type gpxTrackPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Time string `xml:"time"`
+	Name string `xml:"name,omitempty"`
+}
+
+// WriteGPX writes a GPX 1.1 document with one walking track made of the given segments
+func WriteGPX(w io.Writer, name string, started time.Time, segments [][]GPXPoint) error {
+	doc := gpxDocument{
+		Version:  "1.1",
+		Creator:  "CityStat",
+		Xmlns:    "http://www.topografix.com/GPX/1/1",
+		Metadata: gpxMetadata{Name: name, Time: started.UTC().Format(time.RFC3339)},
+		Track:    gpxTrack{Name: name, Type: "walking"},
+	}
+	for _, segment := range segments {
+		if len(segment) == 0 {
+			continue
+		}
+		points := make([]gpxTrackPoint, len(segment))
+		for i, p := range segment {
+			points[i] = gpxTrackPoint{
+				Lat:  fmt.Sprintf("%.7f", p.Lat),
+				Lon:  fmt.Sprintf("%.7f", p.Lng),
+				Time: p.Time.UTC().Format(time.RFC3339),
+				Name: p.Name,
+			}
+		}
+		doc.Track.Segments = append(doc.Track.Segments, gpxSegment{Points: points})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode GPX: %w", err)
+	}
+	return encoder.Close()
+}
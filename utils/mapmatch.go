@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// points reported less accurately than this are dropped before matching
+	maxMatchAccuracyMeters = 50.0
+	// search radius used when a point has no accuracy, and the floor for ones that do
+	minMatchRadiusMeters = 25.0
+	// faster than this (m/s) the user is not walking
+	maxMatchSpeedMps = 7.0
+	// a gap this long (ms) between points starts an independent stretch of track
+	maxMatchGapMillis  = 5 * 60 * 1000
+	maxMatchCandidates = 5
+
+	// GPS noise, in meters, assumed by the emission cost
+	matchSigmaMeters = 10.0
+	// how much a jump off the road network is penalised, in meters, by the transition cost
+	matchBetaMeters = 5.0
+	// extra cost of changing streets, which keeps crossings from producing one-point visits
+	matchStreetChangeCost = 2.0
+)
+
+// TrackPoint is one raw GPS fix. Timestamp is a unix timestamp in milliseconds.
+type TrackPoint struct {
+	Lat       float64
+	Lng       float64
+	Timestamp int64
+	Accuracy  *float64
+	Speed     *float64
+}
+
+// MatchedVisit is an uninterrupted stretch of a track on one street
+type MatchedVisit struct {
+	StreetID       string
+	StreetName     string
+	EntryTimestamp int64
+	ExitTimestamp  int64
+	Entry          LatLng
+	Points         int
+}
+
+type matchLayer struct {
+	point      TrackPoint
+	candidates []StreetCandidate
+}
+
+// MatchTrack snaps a GPS track onto the street graph and returns the visited streets in order.
+// Each stretch of the track is decoded with a Viterbi search over nearby streets, where a
+// transition is cheap when the snapped distance agrees with the raw distance between fixes.
+func MatchTrack(graph *StreetGraph, points []TrackPoint) []MatchedVisit {
+	sorted := append([]TrackPoint(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	var visits []MatchedVisit
+	var stretch []matchLayer
+	flush := func() {
+		visits = appendVisits(visits, stretch, decodeStretch(stretch))
+		stretch = stretch[:0]
+	}
+
+	for i, point := range sorted {
+		if i > 0 && point.Timestamp == sorted[i-1].Timestamp {
+			continue
+		}
+		if !usableTrackPoint(point) {
+			continue
+		}
+
+		radius := minMatchRadiusMeters
+		if point.Accuracy != nil {
+			radius = math.Max(radius, *point.Accuracy)
+		}
+		candidates := graph.Nearby(LatLng{Lat: point.Lat, Lng: point.Lng}, radius, maxMatchCandidates)
+		if len(candidates) == 0 {
+			flush()
+			continue
+		}
+
+		if len(stretch) > 0 && point.Timestamp-stretch[len(stretch)-1].point.Timestamp > maxMatchGapMillis {
+			flush()
+		}
+		stretch = append(stretch, matchLayer{point: point, candidates: candidates})
+	}
+	flush()
+
+	return visits
+}
+
+func usableTrackPoint(point TrackPoint) bool {
+	switch {
+	case point.Timestamp <= 0:
+		return false
+	case point.Lat < -90 || point.Lat > 90 || point.Lng < -180 || point.Lng > 180:
+		return false
+	case point.Accuracy != nil && *point.Accuracy > maxMatchAccuracyMeters:
+		return false
+	case point.Speed != nil && *point.Speed > maxMatchSpeedMps:
+		return false
+	}
+	return true
+}
+
+// decodeStretch returns the index of the most likely candidate for every layer of a stretch
+func decodeStretch(layers []matchLayer) []int {
+	if len(layers) == 0 {
+		return nil
+	}
+
+	costs := make([][]float64, len(layers))
+	back := make([][]int, len(layers))
+
+	costs[0] = make([]float64, len(layers[0].candidates))
+	for j, candidate := range layers[0].candidates {
+		costs[0][j] = emissionCost(candidate)
+	}
+
+	for i := 1; i < len(layers); i++ {
+		prev, cur := layers[i-1], layers[i]
+		raw := HaversineMeters(LatLng{Lat: prev.point.Lat, Lng: prev.point.Lng}, LatLng{Lat: cur.point.Lat, Lng: cur.point.Lng})
+
+		costs[i] = make([]float64, len(cur.candidates))
+		back[i] = make([]int, len(cur.candidates))
+		for j, to := range cur.candidates {
+			best, bestFrom := math.Inf(1), 0
+			for k, from := range prev.candidates {
+				cost := costs[i-1][k] + math.Abs(HaversineMeters(from.Point, to.Point)-raw)/matchBetaMeters
+				if from.Street != to.Street {
+					cost += matchStreetChangeCost
+				}
+				if cost < best {
+					best, bestFrom = cost, k
+				}
+			}
+			costs[i][j] = best + emissionCost(to)
+			back[i][j] = bestFrom
+		}
+	}
+
+	last := len(layers) - 1
+	choice := make([]int, len(layers))
+	for j := range costs[last] {
+		if costs[last][j] < costs[last][choice[last]] {
+			choice[last] = j
+		}
+	}
+	for i := last; i > 0; i-- {
+		choice[i-1] = back[i][choice[i]]
+	}
+	return choice
+}
+
+func emissionCost(candidate StreetCandidate) float64 {
+	z := candidate.Distance / matchSigmaMeters
+	return z * z / 2
+}
+
+// appendVisits collapses consecutive fixes of a decoded stretch on the same street into visits
+func appendVisits(visits []MatchedVisit, layers []matchLayer, choice []int) []MatchedVisit {
+	for i, layer := range layers {
+		candidate := layer.candidates[choice[i]]
+		timestamp := layer.point.Timestamp
+
+		// the previous stretch may have ended on this street, e.g. after a point with no street nearby
+		if n := len(visits); n > 0 && visits[n-1].StreetID == candidate.Street.ID && timestamp-visits[n-1].ExitTimestamp <= maxMatchGapMillis {
+			visits[n-1].ExitTimestamp = timestamp
+			visits[n-1].Points++
+			continue
+		}
+		visits = append(visits, MatchedVisit{
+			StreetID:       candidate.Street.ID,
+			StreetName:     candidate.Street.Name,
+			EntryTimestamp: timestamp,
+			ExitTimestamp:  timestamp,
+			Entry:          candidate.Point,
+			Points:         1,
+		})
+	}
+	return visits
+}
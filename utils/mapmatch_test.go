@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testOrigin anchors the fixtures; offset moves east and north of it in meters
+var testOrigin = LatLng{Lat: 42.6977, Lng: 23.3219}
+
+func offset(east, north float64) LatLng {
+	return LatLng{
+		Lat: testOrigin.Lat + north/metersPerDegree,
+		Lng: testOrigin.Lng + east/(metersPerDegree*math.Cos(testOrigin.Lat*math.Pi/180)),
+	}
+}
+
+// walk returns fixes 10 seconds apart at the given offsets
+func walk(start int64, offsets ...[2]float64) []TrackPoint {
+	points := make([]TrackPoint, len(offsets))
+	for i, o := range offsets {
+		p := offset(o[0], o[1])
+		points[i] = TrackPoint{Lat: p.Lat, Lng: p.Lng, Timestamp: start + int64(i)*10_000}
+	}
+	return points
+}
+
+func TestMatchTrack(t *testing.T) {
+	// two parallel streets 30m apart, both inside the search radius of a fix between them, joined
+	// by a cross street at 200m
+	graph := NewStreetGraph([]Street{
+		{ID: "main", Name: "Main", Lines: [][]LatLng{{offset(0, 0), offset(400, 0)}}},
+		{ID: "side", Name: "Side", Lines: [][]LatLng{{offset(0, 30), offset(400, 30)}}},
+		{ID: "cross", Name: "Cross", Lines: [][]LatLng{{offset(200, 0), offset(200, 30)}}},
+	})
+	inaccurate := 80.0
+
+	tests := []struct {
+		name    string
+		points  []TrackPoint
+		want    []string
+		dropped int
+	}{
+		{
+			"on one street",
+			walk(1_000, [2]float64{20, 2}, [2]float64{40, -1}, [2]float64{60, 3}, [2]float64{80, 0}),
+			[]string{"main"},
+			0,
+		},
+		{
+			// one fix drifts closer to the parallel street, which is not worth two street changes
+			"noisy fix near the parallel street",
+			walk(1_000, [2]float64{20, 2}, [2]float64{40, 1}, [2]float64{60, 18}, [2]float64{80, 2}, [2]float64{100, 1}),
+			[]string{"main"},
+			0,
+		},
+		{
+			"detour over the parallel street",
+			walk(1_000,
+				[2]float64{140, 1}, [2]float64{170, -1}, [2]float64{199, 2},
+				[2]float64{201, 15},
+				[2]float64{205, 29}, [2]float64{240, 31}, [2]float64{280, 29}, [2]float64{320, 30},
+			),
+			[]string{"main", "cross", "side"},
+			0,
+		},
+		{
+			"inaccurate fix on the parallel street is dropped",
+			func() []TrackPoint {
+				points := walk(1_000, [2]float64{20, 0}, [2]float64{40, 28}, [2]float64{60, 0})
+				points[1].Accuracy = &inaccurate
+				return points
+			}(),
+			[]string{"main"},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visits := MatchTrack(graph, tt.points)
+			var got []string
+			points := 0
+			for _, v := range visits {
+				got = append(got, v.StreetID)
+				points += v.Points
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("matched %v, want %v", got, tt.want)
+			}
+			for i := 1; i < len(visits); i++ {
+				if visits[i].EntryTimestamp <= visits[i-1].ExitTimestamp {
+					t.Errorf("visit %d enters at %d, before the previous one exits at %d", i, visits[i].EntryTimestamp, visits[i-1].ExitTimestamp)
+				}
+			}
+			if first, last := visits[0], visits[len(visits)-1]; first.EntryTimestamp != 1_000 || last.ExitTimestamp != tt.points[len(tt.points)-1].Timestamp {
+				t.Errorf("visits span %d..%d", first.EntryTimestamp, last.ExitTimestamp)
+			}
+			if points != len(tt.points)-tt.dropped {
+				t.Errorf("visits hold %d points, want %d", points, len(tt.points)-tt.dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+const (
+	// MVTExtent is the coordinate range inside a vector tile
+	MVTExtent = 4096
+	// MaxTileZoom is the deepest zoom level tiles are served for
+	MaxTileZoom = 22
+	// mvtBuffer lets geometry run past the tile edge so lines join up across tiles
+	mvtBuffer = 64
+)
+
+// TileID addresses a web mercator tile
+type TileID struct {
+	Z, X, Y int
+}
+
+// Valid reports whether the tile exists at its zoom level
+func (t TileID) Valid() bool {
+	if t.Z < 0 || t.Z > MaxTileZoom {
+		return false
+	}
+	n := 1 << t.Z
+	return t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Bounds returns the tile's area including the geometry buffer
+func (t TileID) Bounds() (minLat, minLng, maxLat, maxLng float64) {
+	n := float64(int(1) << t.Z)
+	pad := float64(mvtBuffer) / MVTExtent
+	minLng = (float64(t.X)-pad)/n*360 - 180
+	maxLng = (float64(t.X)+1+pad)/n*360 - 180
+	maxLat = tileYToLat((float64(t.Y) - pad) / n)
+	minLat = tileYToLat((float64(t.Y) + 1 + pad) / n)
+	return minLat, minLng, maxLat, maxLng
+}
+
+func tileYToLat(y float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y))) * 180 / math.Pi
+}
+
+// Project converts a coordinate to tile-local units, 0..MVTExtent inside the tile
+func (t TileID) Project(p LatLng) (float64, float64) {
+	n := float64(int(1) << t.Z)
+	lat := math.Max(math.Min(p.Lat, 85.0511), -85.0511) * math.Pi / 180
+	x := (p.Lng + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return (x - float64(t.X)) * MVTExtent, (y - float64(t.Y)) * MVTExtent
+}
+
+// InTile reports whether tile-local coordinates fall inside the tile and its buffer
+func InTile(x, y float64) bool {
+	return x >= -mvtBuffer && x <= MVTExtent+mvtBuffer && y >= -mvtBuffer && y <= MVTExtent+mvtBuffer
+}
+
+// ClipLine cuts a line in tile-local coordinates to the tile and its buffer. A line that leaves
+// and re-enters the tile comes back as several parts.
+func ClipLine(points [][2]float64) [][][2]float64 {
+	const lo, hi = -mvtBuffer, MVTExtent + mvtBuffer
+	var parts [][][2]float64
+	var current [][2]float64
+	for i := 1; i < len(points); i++ {
+		a, b, ok := clipSegment(points[i-1], points[i], lo, hi)
+		if !ok {
+			if len(current) > 1 {
+				parts = append(parts, current)
+			}
+			current = nil
+			continue
+		}
+		if len(current) == 0 || current[len(current)-1] != a {
+			if len(current) > 1 {
+				parts = append(parts, current)
+			}
+			current = [][2]float64{a}
+		}
+		current = append(current, b)
+		// the segment left the tile, so the next one starts a new part
+		if b != points[i] {
+			parts = append(parts, current)
+			current = nil
+		}
+	}
+	if len(current) > 1 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// clipSegment is Liang-Barsky clipping of a segment to the square [lo, hi]
+func clipSegment(a, b [2]float64, lo, hi float64) ([2]float64, [2]float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, edge := range [4][2]float64{
+		{-dx, a[0] - lo},
+		{dx, hi - a[0]},
+		{-dy, a[1] - lo},
+		{dy, hi - a[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return a, b, false
+			}
+			t0 = math.Max(t0, r)
+		} else {
+			if r < t0 {
+				return a, b, false
+			}
+			t1 = math.Min(t1, r)
+		}
+	}
+
+	clippedA, clippedB := a, b
+	if t0 > 0 {
+		clippedA = [2]float64{a[0] + t0*dx, a[1] + t0*dy}
+	}
+	if t1 < 1 {
+		clippedB = [2]float64{a[0] + t1*dx, a[1] + t1*dy}
+	}
+	return clippedA, clippedB, true
+}
+
+const (
+	mvtPoint      = 1
+	mvtLineString = 2
+
+	mvtMoveTo = 1
+	mvtLineTo = 2
+)
+
+type mvtFeature struct {
+	geomType int
+	tags     []uint32
+	geometry []uint32
+}
+
+// MVTLayer collects features for one layer of a Mapbox Vector Tile. Property values may be
+// strings, ints or float64s.
+type MVTLayer struct {
+	name     string
+	features []mvtFeature
+	keys     []string
+	keyIndex map[string]uint32
+	values   []interface{}
+	valIndex map[interface{}]uint32
+}
+
+func NewMVTLayer(name string) *MVTLayer {
+	return &MVTLayer{
+		name:     name,
+		keyIndex: make(map[string]uint32),
+		valIndex: make(map[interface{}]uint32),
+	}
+}
+
+// Len returns the number of features in the layer
+func (l *MVTLayer) Len() int {
+	return len(l.features)
+}
+
+// AddPoint adds a point at tile-local coordinates
+func (l *MVTLayer) AddPoint(x, y float64, props map[string]interface{}) {
+	px, py := int32(math.Round(x)), int32(math.Round(y))
+	l.features = append(l.features, mvtFeature{
+		geomType: mvtPoint,
+		tags:     l.tags(props),
+		geometry: []uint32{command(mvtMoveTo, 1), zigzagEncode(px), zigzagEncode(py)},
+	})
+}
+
+// AddLine adds a multi-part line in tile-local coordinates. Points collapsing onto the same tile
+// unit are merged, and parts shorter than one unit are dropped.
+func (l *MVTLayer) AddLine(parts [][][2]float64, props map[string]interface{}) {
+	var geometry []uint32
+	var cx, cy int32
+	for _, part := range parts {
+		snapped := make([][2]int32, 0, len(part))
+		for _, p := range part {
+			q := [2]int32{int32(math.Round(p[0])), int32(math.Round(p[1]))}
+			if len(snapped) > 0 && snapped[len(snapped)-1] == q {
+				continue
+			}
+			snapped = append(snapped, q)
+		}
+		if len(snapped) < 2 {
+			continue
+		}
+
+		geometry = append(geometry, command(mvtMoveTo, 1),
+			zigzagEncode(snapped[0][0]-cx), zigzagEncode(snapped[0][1]-cy))
+		cx, cy = snapped[0][0], snapped[0][1]
+		geometry = append(geometry, command(mvtLineTo, len(snapped)-1))
+		for _, q := range snapped[1:] {
+			geometry = append(geometry, zigzagEncode(q[0]-cx), zigzagEncode(q[1]-cy))
+			cx, cy = q[0], q[1]
+		}
+	}
+	if len(geometry) == 0 {
+		return
+	}
+
+	l.features = append(l.features, mvtFeature{
+		geomType: mvtLineString,
+		tags:     l.tags(props),
+		geometry: geometry,
+	})
+}
+
+func (l *MVTLayer) tags(props map[string]interface{}) []uint32 {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]uint32, 0, 2*len(keys))
+	for _, k := range keys {
+		ki, ok := l.keyIndex[k]
+		if !ok {
+			ki = uint32(len(l.keys))
+			l.keyIndex[k] = ki
+			l.keys = append(l.keys, k)
+		}
+		v := props[k]
+		vi, ok := l.valIndex[v]
+		if !ok {
+			vi = uint32(len(l.values))
+			l.valIndex[v] = vi
+			l.values = append(l.values, v)
+		}
+		tags = append(tags, ki, vi)
+	}
+	return tags
+}
+
+func command(id, count int) uint32 {
+	return uint32(id&0x7) | uint32(count)<<3
+}
+
+func zigzagEncode(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+// EncodeMVT serialises layers as a Mapbox Vector Tile (spec version 2). Empty layers are left out.
+func EncodeMVT(layers ...*MVTLayer) []byte {
+	var tile protoWriter
+	for _, layer := range layers {
+		if layer.Len() == 0 {
+			continue
+		}
+		tile.message(3, layer.encode())
+	}
+	return tile.buf
+}
+
+func (l *MVTLayer) encode() []byte {
+	var w protoWriter
+	w.uvarintField(15, 2)
+	w.bytesField(1, []byte(l.name))
+	for _, f := range l.features {
+		var fw protoWriter
+		fw.packedField(2, f.tags)
+		fw.uvarintField(3, uint64(f.geomType))
+		fw.packedField(4, f.geometry)
+		w.message(2, fw.buf)
+	}
+	for _, k := range l.keys {
+		w.bytesField(3, []byte(k))
+	}
+	for _, v := range l.values {
+		var vw protoWriter
+		switch value := v.(type) {
+		case string:
+			vw.bytesField(1, []byte(value))
+		case float64:
+			vw.key(3, 1)
+			vw.buf = binary.LittleEndian.AppendUint64(vw.buf, math.Float64bits(value))
+		case int:
+			vw.key(6, 0)
+			vw.buf = binary.AppendUvarint(vw.buf, uint64((int64(value)<<1)^(int64(value)>>63)))
+		}
+		w.message(4, vw.buf)
+	}
+	w.uvarintField(5, MVTExtent)
+	return w.buf
+}
+
+// protoWriter is a minimal protocol buffers wire format writer, the counterpart of protoBuffer
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) key(field, wire int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field<<3|wire))
+}
+
+func (w *protoWriter) uvarintField(field int, v uint64) {
+	w.key(field, 0)
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *protoWriter) bytesField(field int, b []byte) {
+	w.key(field, 2)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *protoWriter) message(field int, b []byte) {
+	w.bytesField(field, b)
+}
+
+func (w *protoWriter) packedField(field int, values []uint32) {
+	if len(values) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	w.bytesField(field, packed)
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type decodedFeature struct {
+	geomType int
+	props    map[string]interface{}
+	parts    [][][2]int32
+}
+
+type decodedLayer struct {
+	name     string
+	version  uint64
+	extent   uint64
+	features []decodedFeature
+}
+
+// decodeMVT reads a tile back with the PBF reader, resolving tags and making geometry absolute
+func decodeMVT(t *testing.T, tile []byte) []decodedLayer {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("decode tile: %v", err)
+		}
+	}
+
+	var layers []decodedLayer
+	p := protoBuffer{data: tile}
+	for !p.done() {
+		field, wire, err := p.key()
+		must(err)
+		if field != 3 {
+			t.Fatalf("unexpected tile field %d", field)
+		}
+		data, err := p.bytes()
+		must(err)
+
+		var layer decodedLayer
+		var keys []string
+		var values []interface{}
+		var rawFeatures [][]byte
+		lp := protoBuffer{data: data}
+		for !lp.done() {
+			field, wire, err = lp.key()
+			must(err)
+			switch field {
+			case 1:
+				b, err := lp.bytes()
+				must(err)
+				layer.name = string(b)
+			case 2:
+				b, err := lp.bytes()
+				must(err)
+				rawFeatures = append(rawFeatures, b)
+			case 3:
+				b, err := lp.bytes()
+				must(err)
+				keys = append(keys, string(b))
+			case 4:
+				b, err := lp.bytes()
+				must(err)
+				vp := protoBuffer{data: b}
+				vfield, _, err := vp.key()
+				must(err)
+				switch vfield {
+				case 1:
+					s, err := vp.bytes()
+					must(err)
+					values = append(values, string(s))
+				case 3:
+					values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(vp.data[vp.pos:])))
+				case 6:
+					v, err := vp.varint()
+					must(err)
+					values = append(values, int(zigzag(v)))
+				}
+			case 5:
+				layer.extent, err = lp.varint()
+				must(err)
+			case 15:
+				layer.version, err = lp.varint()
+				must(err)
+			default:
+				must(lp.skip(wire))
+			}
+		}
+
+		for _, raw := range rawFeatures {
+			f := decodedFeature{props: map[string]interface{}{}}
+			var tags, geometry []uint64
+			fp := protoBuffer{data: raw}
+			for !fp.done() {
+				field, wire, err = fp.key()
+				must(err)
+				switch field {
+				case 2:
+					tags, err = fp.packed()
+				case 3:
+					var v uint64
+					v, err = fp.varint()
+					f.geomType = int(v)
+				case 4:
+					geometry, err = fp.packed()
+				default:
+					err = fp.skip(wire)
+				}
+				must(err)
+			}
+			for i := 0; i+1 < len(tags); i += 2 {
+				f.props[keys[tags[i]]] = values[tags[i+1]]
+			}
+			var x, y int32
+			for i := 0; i < len(geometry); {
+				id, count := geometry[i]&0x7, int(geometry[i]>>3)
+				i++
+				if id == mvtMoveTo {
+					f.parts = append(f.parts, nil)
+				}
+				for ; count > 0; count-- {
+					x += int32(zigzag(geometry[i]))
+					y += int32(zigzag(geometry[i+1]))
+					i += 2
+					last := len(f.parts) - 1
+					f.parts[last] = append(f.parts[last], [2]int32{x, y})
+				}
+			}
+			layer.features = append(layer.features, f)
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+func TestEncodeMVT(t *testing.T) {
+	streets := NewMVTLayer("streets")
+	streets.AddLine([][][2]float64{
+		{{10, 10}, {10.2, 10.3}, {100, 10}, {100, 200}},
+		// collapses onto a single tile unit and is dropped
+		{{50.1, 50.1}, {49.9, 50.2}},
+		{{-20, 4000}, {300, 4100}},
+	}, map[string]interface{}{"id": "s1", "coverage": 0.5})
+	streets.AddLine([][][2]float64{{{1, 1}, {1.2, 1.1}}}, map[string]interface{}{"id": "dropped"})
+	heat := NewMVTLayer("heat")
+	heat.AddPoint(2048.4, 1023.6, map[string]interface{}{"count": 3, "id": "s1"})
+	empty := NewMVTLayer("empty")
+
+	layers := decodeMVT(t, EncodeMVT(streets, empty, heat))
+
+	want := []decodedLayer{
+		{name: "streets", version: 2, extent: MVTExtent, features: []decodedFeature{{
+			geomType: mvtLineString,
+			props:    map[string]interface{}{"id": "s1", "coverage": 0.5},
+			parts: [][][2]int32{
+				{{10, 10}, {100, 10}, {100, 200}},
+				{{-20, 4000}, {300, 4100}},
+			},
+		}}},
+		{name: "heat", version: 2, extent: MVTExtent, features: []decodedFeature{{
+			geomType: mvtPoint,
+			props:    map[string]interface{}{"count": 3, "id": "s1"},
+			parts:    [][][2]int32{{{2048, 1024}}},
+		}}},
+	}
+	if !reflect.DeepEqual(layers, want) {
+		t.Fatalf("decoded tile\n got %+v\nwant %+v", layers, want)
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	const lo, hi = -mvtBuffer, MVTExtent + mvtBuffer
+
+	tests := []struct {
+		name   string
+		points [][2]float64
+		want   [][][2]float64
+	}{
+		{"single point", [][2]float64{{10, 10}}, nil},
+		{"inside", [][2]float64{{10, 10}, {20, 20}, {30, 10}}, [][][2]float64{{{10, 10}, {20, 20}, {30, 10}}}},
+		{"outside", [][2]float64{{-500, -500}, {-500, 5000}}, nil},
+		{"along the buffer edge", [][2]float64{{lo, 0}, {lo, 100}}, [][][2]float64{{{lo, 0}, {lo, 100}}}},
+		{"leaves the tile", [][2]float64{{100, 100}, {5000, 100}, {5000, 200}}, [][][2]float64{{{100, 100}, {hi, 100}}}},
+		{"enters the tile", [][2]float64{{-1000, 100}, {100, 100}, {100, 200}}, [][][2]float64{{{lo, 100}, {100, 100}, {100, 200}}}},
+		{"crosses the tile", [][2]float64{{-1000, 100}, {5000, 100}}, [][][2]float64{{{lo, 100}, {hi, 100}}}},
+		{
+			"leaves and re-enters",
+			[][2]float64{{100, 100}, {100, -1000}, {200, -1000}, {200, 100}},
+			[][][2]float64{{{100, 100}, {100, lo}}, {{200, lo}, {200, 100}}},
+		},
+		{"cuts a corner", [][2]float64{{-100, 0}, {0, -100}}, [][][2]float64{{{lo, -36}, {-36, lo}}}},
+		{"misses a corner", [][2]float64{{-200, -100}, {-100, -200}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClipLine(tt.points)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d parts %v, want %v", len(got), got, tt.want)
+			}
+			for i := range tt.want {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("part %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+				for j := range tt.want[i] {
+					if math.Abs(got[i][j][0]-tt.want[i][j][0]) > 1e-9 || math.Abs(got[i][j][1]-tt.want[i][j][1]) > 1e-9 {
+						t.Errorf("part %d point %d: got %v, want %v", i, j, got[i][j], tt.want[i][j])
+					}
+				}
+			}
+		})
+	}
+}
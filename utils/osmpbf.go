@@ -0,0 +1,537 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits from the OSM PBF format specification
+const (
+	maxPBFBlobHeaderSize = 64 * 1024
+	maxPBFBlobSize       = 32 * 1024 * 1024
+)
+
+// OSMWay is a way from an OSM extract with its node coordinates resolved
+type OSMWay struct {
+	ID     int64
+	Tags   map[string]string
+	Points []LatLng
+}
+
+// ReadOSMWays reads the ways accepted by keep from an OSM PBF extract. The file is read twice:
+// once for the ways and once for the coordinates of just the nodes they reference, so memory
+// stays proportional to the selected ways rather than the whole extract.
+// Only raw and zlib-compressed blobs are supported, which covers what common tools produce.
+func ReadOSMWays(r io.ReadSeeker, keep func(tags map[string]string) bool) ([]OSMWay, error) {
+	type pendingWay struct {
+		id   int64
+		tags map[string]string
+		refs []int64
+	}
+
+	var pending []pendingWay
+	needed := make(map[int64]LatLng)
+	err := eachPrimitiveGroup(r, func(block *pbfBlock, field int, group []byte) error {
+		if field != 3 {
+			return nil
+		}
+		id, tags, refs, err := decodeWay(block, group)
+		if err != nil {
+			return err
+		}
+		if len(refs) < 2 || !keep(tags) {
+			return nil
+		}
+		for _, ref := range refs {
+			needed[ref] = LatLng{}
+		}
+		pending = append(pending, pendingWay{id: id, tags: tags, refs: refs})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind extract: %w", err)
+	}
+	found := make(map[int64]bool, len(needed))
+	err = eachPrimitiveGroup(r, func(block *pbfBlock, field int, group []byte) error {
+		visit := func(id int64, lat, lon int64) {
+			if _, ok := needed[id]; ok {
+				needed[id] = block.latLng(lat, lon)
+				found[id] = true
+			}
+		}
+		switch field {
+		case 1:
+			return decodeNode(group, visit)
+		case 2:
+			return decodeDenseNodes(group, visit)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ways := make([]OSMWay, 0, len(pending))
+	for _, way := range pending {
+		// extracts clipped at a boundary can reference nodes they do not contain
+		points := make([]LatLng, 0, len(way.refs))
+		for _, ref := range way.refs {
+			if found[ref] {
+				points = append(points, needed[ref])
+			}
+		}
+		if len(points) < 2 {
+			continue
+		}
+		ways = append(ways, OSMWay{ID: way.id, Tags: way.tags, Points: points})
+	}
+
+	return ways, nil
+}
+
+type pbfBlock struct {
+	strings     []string
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *pbfBlock) latLng(lat, lon int64) LatLng {
+	return LatLng{
+		Lat: float64(b.latOffset+b.granularity*lat) / 1e9,
+		Lng: float64(b.lonOffset+b.granularity*lon) / 1e9,
+	}
+}
+
+// eachPrimitiveGroup calls fn with every element list (field 1 nodes, 2 dense nodes, 3 ways, ...)
+// of every primitive group in the file
+func eachPrimitiveGroup(r io.Reader, fn func(block *pbfBlock, field int, data []byte) error) error {
+	for {
+		blobType, data, err := readPBFBlob(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if blobType != "OSMData" {
+			continue
+		}
+
+		block := &pbfBlock{granularity: 100}
+		var groups [][]byte
+		p := protoBuffer{data: data}
+		for !p.done() {
+			field, wire, err := p.key()
+			if err != nil {
+				return err
+			}
+			switch {
+			case field == 1 && wire == 2:
+				table, err := p.bytes()
+				if err != nil {
+					return err
+				}
+				if block.strings, err = decodeStringTable(table); err != nil {
+					return err
+				}
+			case field == 2 && wire == 2:
+				group, err := p.bytes()
+				if err != nil {
+					return err
+				}
+				groups = append(groups, group)
+			case field == 17 && wire == 0:
+				v, err := p.varint()
+				if err != nil {
+					return err
+				}
+				block.granularity = int64(v)
+			case field == 19 && wire == 0:
+				v, err := p.varint()
+				if err != nil {
+					return err
+				}
+				block.latOffset = int64(v)
+			case field == 20 && wire == 0:
+				v, err := p.varint()
+				if err != nil {
+					return err
+				}
+				block.lonOffset = int64(v)
+			default:
+				if err := p.skip(wire); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, group := range groups {
+			g := protoBuffer{data: group}
+			for !g.done() {
+				field, wire, err := g.key()
+				if err != nil {
+					return err
+				}
+				if wire != 2 {
+					if err := g.skip(wire); err != nil {
+						return err
+					}
+					continue
+				}
+				element, err := g.bytes()
+				if err != nil {
+					return err
+				}
+				if err := fn(block, field, element); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// readPBFBlob reads the next blob of the file and returns its type and uncompressed contents
+func readPBFBlob(r io.Reader) (string, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		if err == io.EOF {
+			return "", nil, io.EOF
+		}
+		return "", nil, fmt.Errorf("failed to read blob header size: %w", err)
+	}
+	headerSize := binary.BigEndian.Uint32(size[:])
+	if headerSize > maxPBFBlobHeaderSize {
+		return "", nil, fmt.Errorf("blob header of %d bytes exceeds the format limit", headerSize)
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, fmt.Errorf("failed to read blob header: %w", err)
+	}
+
+	var blobType string
+	var dataSize uint64
+	p := protoBuffer{data: header}
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return "", nil, err
+		}
+		switch {
+		case field == 1 && wire == 2:
+			b, err := p.bytes()
+			if err != nil {
+				return "", nil, err
+			}
+			blobType = string(b)
+		case field == 3 && wire == 0:
+			if dataSize, err = p.varint(); err != nil {
+				return "", nil, err
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if dataSize > maxPBFBlobSize {
+		return "", nil, fmt.Errorf("blob of %d bytes exceeds the format limit", dataSize)
+	}
+
+	blob := make([]byte, dataSize)
+	if _, err := io.ReadFull(r, blob); err != nil {
+		return "", nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	p = protoBuffer{data: blob}
+	var rawSize uint64
+	var compressed []byte
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return "", nil, err
+		}
+		switch {
+		case field == 1 && wire == 2:
+			raw, err := p.bytes()
+			return blobType, raw, err
+		case field == 2 && wire == 0:
+			if rawSize, err = p.varint(); err != nil {
+				return "", nil, err
+			}
+		case field == 3 && wire == 2:
+			if compressed, err = p.bytes(); err != nil {
+				return "", nil, err
+			}
+		case wire == 2 && field >= 4:
+			return "", nil, fmt.Errorf("unsupported blob compression (field %d); re-encode the extract with zlib", field)
+		default:
+			if err := p.skip(wire); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if compressed == nil {
+		return blobType, nil, nil
+	}
+	if rawSize > maxPBFBlobSize {
+		return "", nil, fmt.Errorf("blob of %d bytes exceeds the format limit", rawSize)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to inflate blob: %w", err)
+	}
+	defer zr.Close()
+
+	data := bytes.NewBuffer(make([]byte, 0, rawSize))
+	if _, err := io.Copy(data, io.LimitReader(zr, maxPBFBlobSize+1)); err != nil {
+		return "", nil, fmt.Errorf("failed to inflate blob: %w", err)
+	}
+	if data.Len() > maxPBFBlobSize {
+		return "", nil, fmt.Errorf("blob exceeds the format limit once inflated")
+	}
+	return blobType, data.Bytes(), nil
+}
+
+func decodeStringTable(data []byte) ([]string, error) {
+	var table []string
+	p := protoBuffer{data: data}
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != 2 {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, string(s))
+	}
+	return table, nil
+}
+
+func decodeWay(block *pbfBlock, data []byte) (int64, map[string]string, []int64, error) {
+	var id int64
+	var keys, vals, refs []uint64
+	p := protoBuffer{data: data}
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		switch {
+		case field == 1 && wire == 0:
+			v, err := p.varint()
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			id = int64(v)
+		case field == 2 && wire == 2:
+			if keys, err = p.packed(); err != nil {
+				return 0, nil, nil, err
+			}
+		case field == 3 && wire == 2:
+			if vals, err = p.packed(); err != nil {
+				return 0, nil, nil, err
+			}
+		case field == 8 && wire == 2:
+			if refs, err = p.packed(); err != nil {
+				return 0, nil, nil, err
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return 0, nil, nil, err
+			}
+		}
+	}
+	if len(keys) != len(vals) {
+		return 0, nil, nil, fmt.Errorf("way %d has mismatched tag keys and values", id)
+	}
+
+	tags := make(map[string]string, len(keys))
+	for i := range keys {
+		if keys[i] >= uint64(len(block.strings)) || vals[i] >= uint64(len(block.strings)) {
+			return 0, nil, nil, fmt.Errorf("way %d references a missing string", id)
+		}
+		tags[block.strings[keys[i]]] = block.strings[vals[i]]
+	}
+
+	nodeIDs := make([]int64, len(refs))
+	var last int64
+	for i, ref := range refs {
+		last += zigzag(ref)
+		nodeIDs[i] = last
+	}
+
+	return id, tags, nodeIDs, nil
+}
+
+func decodeNode(data []byte, visit func(id, lat, lon int64)) error {
+	var id, lat, lon int64
+	p := protoBuffer{data: data}
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		if wire != 0 || (field != 1 && field != 8 && field != 9) {
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := p.varint()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			id = zigzag(v)
+		case 8:
+			lat = zigzag(v)
+		case 9:
+			lon = zigzag(v)
+		}
+	}
+	visit(id, lat, lon)
+	return nil
+}
+
+func decodeDenseNodes(data []byte, visit func(id, lat, lon int64)) error {
+	var ids, lats, lons []uint64
+	p := protoBuffer{data: data}
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == 2:
+			ids, err = p.packed()
+		case field == 8 && wire == 2:
+			lats, err = p.packed()
+		case field == 9 && wire == 2:
+			lons, err = p.packed()
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) != len(lats) || len(ids) != len(lons) {
+		return errors.New("dense nodes have mismatched id and coordinate counts")
+	}
+
+	var id, lat, lon int64
+	for i := range ids {
+		id += zigzag(ids[i])
+		lat += zigzag(lats[i])
+		lon += zigzag(lons[i])
+		visit(id, lat, lon)
+	}
+	return nil
+}
+
+// protoBuffer is a minimal protocol buffers wire format reader, enough for the OSM PBF messages
+type protoBuffer struct {
+	data []byte
+	pos  int
+}
+
+var errTruncatedProto = errors.New("truncated protobuf message")
+
+func (p *protoBuffer) done() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *protoBuffer) varint() (uint64, error) {
+	v, n := binary.Uvarint(p.data[p.pos:])
+	if n <= 0 {
+		return 0, errTruncatedProto
+	}
+	p.pos += n
+	return v, nil
+}
+
+func (p *protoBuffer) key() (int, int, error) {
+	v, err := p.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+func (p *protoBuffer) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(p.data)-p.pos) {
+		return nil, errTruncatedProto
+	}
+	b := p.data[p.pos : p.pos+int(n)]
+	p.pos += int(n)
+	return b, nil
+}
+
+func (p *protoBuffer) packed() ([]uint64, error) {
+	b, err := p.bytes()
+	if err != nil {
+		return nil, err
+	}
+	var values []uint64
+	inner := protoBuffer{data: b}
+	for !inner.done() {
+		v, err := inner.varint()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (p *protoBuffer) skip(wire int) error {
+	switch wire {
+	case 0:
+		_, err := p.varint()
+		return err
+	case 1:
+		p.pos += 8
+	case 2:
+		_, err := p.bytes()
+		return err
+	case 5:
+		p.pos += 4
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wire)
+	}
+	if p.pos > len(p.data) {
+		return errTruncatedProto
+	}
+	return nil
+}
+
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
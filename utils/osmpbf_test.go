@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// the helpers below write the protocol buffers messages of the OSM PBF format, so fixtures are
+// built in the test instead of checked in as binary files
+
+func pbVarint(v uint64) []byte {
+	return binary.AppendUvarint(nil, v)
+}
+
+func pbUint(field int, v uint64) []byte {
+	return append(pbVarint(uint64(field<<3)), pbVarint(v)...)
+}
+
+func pbBytes(field int, b []byte) []byte {
+	out := pbVarint(uint64(field<<3 | 2))
+	out = append(out, pbVarint(uint64(len(b)))...)
+	return append(out, b...)
+}
+
+func pbZigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// pbDeltas packs values delta and zigzag encoded, as dense node ids, coordinates and way refs are
+func pbDeltas(field int, values []int64) []byte {
+	var packed []byte
+	var last int64
+	for _, v := range values {
+		packed = append(packed, pbVarint(pbZigzag(v-last))...)
+		last = v
+	}
+	return pbBytes(field, packed)
+}
+
+func pbPacked(field int, values []uint64) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = append(packed, pbVarint(v)...)
+	}
+	return pbBytes(field, packed)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type testNode struct {
+	id       int64
+	lat, lng float64
+}
+
+type testWay struct {
+	id   int64
+	tags [][2]string
+	refs []int64
+}
+
+// testPrimitiveBlock encodes a block with granularity 100 and no offsets. Dense nodes go in one
+// group, plain nodes and ways in another.
+func testPrimitiveBlock(dense, plain []testNode, ways []testWay) []byte {
+	table := []string{""}
+	index := func(s string) uint64 {
+		for i, t := range table {
+			if t == s {
+				return uint64(i)
+			}
+		}
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+	coord := func(degrees float64) int64 { return int64(math.Round(degrees * 1e7)) }
+
+	var denseGroup []byte
+	if len(dense) > 0 {
+		var ids, lats, lngs []int64
+		for _, n := range dense {
+			ids = append(ids, n.id)
+			lats = append(lats, coord(n.lat))
+			lngs = append(lngs, coord(n.lng))
+		}
+		denseGroup = pbBytes(2, concat(pbDeltas(1, ids), pbDeltas(8, lats), pbDeltas(9, lngs)))
+	}
+	var group []byte
+	for _, n := range plain {
+		group = append(group, pbBytes(1, concat(
+			pbUint(1, pbZigzag(n.id)), pbUint(8, pbZigzag(coord(n.lat))), pbUint(9, pbZigzag(coord(n.lng))),
+		))...)
+	}
+	for _, w := range ways {
+		var keys, vals []uint64
+		for _, tag := range w.tags {
+			keys = append(keys, index(tag[0]))
+			vals = append(vals, index(tag[1]))
+		}
+		group = append(group, pbBytes(3, concat(
+			pbUint(1, uint64(w.id)), pbPacked(2, keys), pbPacked(3, vals), pbDeltas(8, w.refs),
+		))...)
+	}
+
+	var stringTable []byte
+	for _, s := range table {
+		stringTable = append(stringTable, pbBytes(1, []byte(s))...)
+	}
+	block := pbBytes(1, stringTable)
+	if denseGroup != nil {
+		block = append(block, pbBytes(2, denseGroup)...)
+	}
+	if group != nil {
+		block = append(block, pbBytes(2, group)...)
+	}
+	return append(block, pbUint(17, 100)...)
+}
+
+// testBlob frames data as a file block, zlib compressed or raw
+func testBlob(blobType string, data []byte, compress bool) []byte {
+	var blob []byte
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		blob = concat(pbUint(2, uint64(len(data))), pbBytes(3, buf.Bytes()))
+	} else {
+		blob = pbBytes(1, data)
+	}
+	header := concat(pbBytes(1, []byte(blobType)), pbUint(3, uint64(len(blob))))
+	size := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	return concat(size, header, blob)
+}
+
+func TestReadOSMWays(t *testing.T) {
+	highways := func(tags map[string]string) bool { return tags["highway"] != "" }
+
+	extract := concat(
+		testBlob("OSMHeader", pbBytes(4, []byte("DenseNodes")), false),
+		testBlob("OSMData", testPrimitiveBlock(
+			[]testNode{{1, 42.6977, 23.3219}, {2, 42.6980, 23.3225}, {3, 42.6985, 23.3231}},
+			[]testNode{{4, 42.6990, 23.3240}},
+			[]testWay{
+				{id: 10, tags: [][2]string{{"highway", "residential"}, {"name", "Vitosha"}}, refs: []int64{1, 2, 3}},
+				{id: 11, tags: [][2]string{{"building", "yes"}}, refs: []int64{1, 2}},
+			},
+		), true),
+		testBlob("OSMData", testPrimitiveBlock(
+			nil,
+			[]testNode{{5, 42.7000, 23.3300}},
+			[]testWay{
+				// node 99 is outside the extract and is dropped
+				{id: 12, tags: [][2]string{{"highway", "footway"}}, refs: []int64{4, 99, 5}},
+				// a single node is left once the missing one is dropped
+				{id: 13, tags: [][2]string{{"highway", "path"}}, refs: []int64{5, 99}},
+			},
+		), false),
+	)
+
+	ways, err := ReadOSMWays(bytes.NewReader(extract), highways)
+	if err != nil {
+		t.Fatalf("ReadOSMWays: %v", err)
+	}
+
+	want := []OSMWay{
+		{ID: 10, Tags: map[string]string{"highway": "residential", "name": "Vitosha"}, Points: []LatLng{
+			{Lat: 42.6977, Lng: 23.3219}, {Lat: 42.6980, Lng: 23.3225}, {Lat: 42.6985, Lng: 23.3231},
+		}},
+		{ID: 12, Tags: map[string]string{"highway": "footway"}, Points: []LatLng{
+			{Lat: 42.6990, Lng: 23.3240}, {Lat: 42.7000, Lng: 23.3300},
+		}},
+	}
+	if len(ways) != len(want) {
+		t.Fatalf("got %d ways, want %d: %+v", len(ways), len(want), ways)
+	}
+	for i, w := range want {
+		got := ways[i]
+		if got.ID != w.ID {
+			t.Errorf("way %d: id %d, want %d", i, got.ID, w.ID)
+		}
+		for k, v := range w.Tags {
+			if got.Tags[k] != v {
+				t.Errorf("way %d: tag %s = %q, want %q", w.ID, k, got.Tags[k], v)
+			}
+		}
+		if len(got.Points) != len(w.Points) {
+			t.Fatalf("way %d: %d points, want %d", w.ID, len(got.Points), len(w.Points))
+		}
+		for j, p := range w.Points {
+			if math.Abs(got.Points[j].Lat-p.Lat) > 1e-9 || math.Abs(got.Points[j].Lng-p.Lng) > 1e-9 {
+				t.Errorf("way %d point %d: %v, want %v", w.ID, j, got.Points[j], p)
+			}
+		}
+	}
+}
+
+func TestReadOSMWaysErrors(t *testing.T) {
+	block := testPrimitiveBlock(nil, nil, []testWay{{id: 1, tags: [][2]string{{"highway", "residential"}}, refs: []int64{1, 2}}})
+	valid := testBlob("OSMData", block, false)
+
+	lzma := concat(pbUint(2, 10), pbBytes(4, []byte("not really lzma")))
+	lzmaHeader := concat(pbBytes(1, []byte("OSMData")), pbUint(3, uint64(len(lzma))))
+
+	tests := []struct {
+		name    string
+		extract []byte
+		want    string
+	}{
+		{"truncated blob", valid[:len(valid)-3], "failed to read blob"},
+		{"truncated header size", []byte{0, 0}, "failed to read blob header size"},
+		{"oversized header", []byte{0xff, 0xff, 0xff, 0xff}, "exceeds the format limit"},
+		{"unsupported compression", concat(binary.BigEndian.AppendUint32(nil, uint32(len(lzmaHeader))), lzmaHeader, lzma), "unsupported blob compression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadOSMWays(bytes.NewReader(tt.extract), func(map[string]string) bool { return true })
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import "sort"
+
+// Reasons a visit is flagged as implausible
+const (
+	MovementImpossibleSpeed = "impossible_speed"
+	MovementTeleport        = "teleport"
+	MovementOverlapping     = "overlapping_timestamps"
+)
+
+const (
+	// faster than a sprinting human, sustained between two street entries
+	maxPlausibleSpeedMps = 12.0
+	// an implausible jump longer than this is a teleport rather than a fast stretch
+	teleportMeters = 2000.0
+	// jumps shorter than this are GPS noise, whatever the implied speed
+	minCheckedMeters = 50.0
+)
+
+// MovementSample is one visit's entry point and time span. Timestamps are unix milliseconds;
+// Exit is 0 when unknown.
+type MovementSample struct {
+	Lat   float64
+	Lng   float64
+	Entry int64
+	Exit  int64
+}
+
+// MovementFlag explains why the sample at Index is implausible
+type MovementFlag struct {
+	Index          int
+	Reason         string
+	SpeedMps       float64
+	DistanceMeters float64
+}
+
+// CheckMovement flags samples that cannot follow the previous plausible one: moving faster than
+// a person can, jumping kilometres, or starting before the previous visit ended. Samples are
+// checked in entry order; prev is the last stored visit before them, if any. Flagged samples are
+// not used as the reference for later ones, so one bad fix does not also flag the return jump.
+func CheckMovement(prev *MovementSample, samples []MovementSample) []MovementFlag {
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return samples[order[a]].Entry < samples[order[b]].Entry
+	})
+
+	var flags []MovementFlag
+	reference := prev
+	for _, idx := range order {
+		sample := samples[idx]
+		if reference == nil {
+			reference = &samples[idx]
+			continue
+		}
+
+		distance := HaversineMeters(
+			LatLng{Lat: reference.Lat, Lng: reference.Lng},
+			LatLng{Lat: sample.Lat, Lng: sample.Lng},
+		)
+		if reference.Exit > sample.Entry {
+			flags = append(flags, MovementFlag{Index: idx, Reason: MovementOverlapping, DistanceMeters: distance})
+			continue
+		}
+
+		if distance >= minCheckedMeters {
+			seconds := float64(sample.Entry-reference.Entry) / 1000
+			if seconds <= 0 {
+				flags = append(flags, MovementFlag{Index: idx, Reason: MovementOverlapping, DistanceMeters: distance})
+				continue
+			}
+			if speed := distance / seconds; speed > maxPlausibleSpeedMps {
+				reason := MovementImpossibleSpeed
+				if distance >= teleportMeters {
+					reason = MovementTeleport
+				}
+				flags = append(flags, MovementFlag{Index: idx, Reason: reason, SpeedMps: speed, DistanceMeters: distance})
+				continue
+			}
+		}
+
+		reference = &samples[idx]
+	}
+
+	return flags
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sampleAt places a visit the given meters north of the test origin
+func sampleAt(north float64, entry, exit int64) MovementSample {
+	p := offset(0, north)
+	return MovementSample{Lat: p.Lat, Lng: p.Lng, Entry: entry, Exit: exit}
+}
+
+func TestCheckMovement(t *testing.T) {
+	start := sampleAt(0, 0, 0)
+
+	tests := []struct {
+		name    string
+		prev    *MovementSample
+		samples []MovementSample
+		want    []string
+		indexes []int
+	}{
+		{"first visit", nil, []MovementSample{sampleAt(5000, 1_000, 0)}, nil, nil},
+		{"jump under the noise floor", &start, []MovementSample{sampleAt(49, 100, 0)}, nil, nil},
+		{"jump over the noise floor", &start, []MovementSample{sampleAt(51, 1_000, 0)}, []string{MovementImpossibleSpeed}, []int{0}},
+		{"just under walking limit", &start, []MovementSample{sampleAt(119, 10_000, 0)}, nil, nil},
+		{"just over walking limit", &start, []MovementSample{sampleAt(121, 10_000, 0)}, []string{MovementImpossibleSpeed}, []int{0}},
+		{"fast but not a teleport", &start, []MovementSample{sampleAt(1990, 60_000, 0)}, []string{MovementImpossibleSpeed}, []int{0}},
+		{"teleport", &start, []MovementSample{sampleAt(2010, 60_000, 0)}, []string{MovementTeleport}, []int{0}},
+		{"long jump over enough time", &start, []MovementSample{sampleAt(2010, 300_000, 0)}, nil, nil},
+		{"same entry time", &start, []MovementSample{sampleAt(100, 0, 0)}, []string{MovementOverlapping}, []int{0}},
+		{
+			"starts before the previous visit ends",
+			nil,
+			[]MovementSample{sampleAt(0, 0, 20_000), sampleAt(10, 15_000, 30_000)},
+			[]string{MovementOverlapping},
+			[]int{1},
+		},
+		{
+			"starts as the previous visit ends",
+			nil,
+			[]MovementSample{sampleAt(0, 0, 20_000), sampleAt(10, 20_000, 30_000)},
+			nil,
+			nil,
+		},
+		{
+			// the return from a bad fix is measured against the last good one
+			"flagged sample is not the reference",
+			&start,
+			[]MovementSample{sampleAt(5000, 10_000, 0), sampleAt(100, 20_000, 0)},
+			[]string{MovementTeleport},
+			[]int{0},
+		},
+		{
+			"checked in entry order",
+			&start,
+			[]MovementSample{sampleAt(200, 30_000, 0), sampleAt(3000, 10_000, 0), sampleAt(100, 20_000, 0)},
+			[]string{MovementTeleport},
+			[]int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reasons []string
+			var indexes []int
+			for _, flag := range CheckMovement(tt.prev, tt.samples) {
+				reasons = append(reasons, flag.Reason)
+				indexes = append(indexes, flag.Index)
+			}
+			if !reflect.DeepEqual(reasons, tt.want) || !reflect.DeepEqual(indexes, tt.indexes) {
+				t.Fatalf("flagged %v at %v, want %v at %v", reasons, indexes, tt.want, tt.indexes)
+			}
+		})
+	}
+}
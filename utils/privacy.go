@@ -0,0 +1,104 @@
+package utils
+
+import "math"
+
+// privacyGridDegrees is the cell size coordinates inside a privacy zone are coarsened to, about
+// 1.1 km of latitude
+const privacyGridDegrees = 0.01
+
+// privacyClipStepMeters is how finely lines are sampled when cut at privacy zone edges
+const privacyClipStepMeters = 10.0
+
+// PrivacyZone is an area, usually around a home or workplace, whose coordinates are never stored
+// precisely. It is a circle when RadiusMeters is set and a polygon otherwise.
+type PrivacyZone struct {
+	Center       LatLng
+	RadiusMeters float64
+	Polygons     []Polygon
+}
+
+func (z PrivacyZone) Contains(p LatLng) bool {
+	if z.RadiusMeters > 0 {
+		return HaversineMeters(z.Center, p) <= z.RadiusMeters
+	}
+	for _, polygon := range z.Polygons {
+		if polygonContains(polygon, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// InPrivacyZone reports whether p lies in any of the zones
+func InPrivacyZone(zones []PrivacyZone, p LatLng) bool {
+	for _, zone := range zones {
+		if zone.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// CoarsenLatLng snaps p to the center of its cell in a fixed grid. The grid does not depend on
+// the zone, so coarsened points do not give away the zone's center.
+func CoarsenLatLng(p LatLng) LatLng {
+	snap := func(v float64) float64 {
+		return math.Round((math.Floor(v/privacyGridDegrees)+0.5)*privacyGridDegrees*1e6) / 1e6
+	}
+	return LatLng{Lat: snap(p.Lat), Lng: snap(p.Lng)}
+}
+
+// ClipPrivacyZones removes the stretches of lines inside any of the zones, splitting a line where it
+// passes through one. Segments are sampled every privacyClipStepMeters, so a cut lands within that
+// distance outside the zone edge. The second result reports whether anything was removed.
+func ClipPrivacyZones(zones []PrivacyZone, lines [][]LatLng) ([][]LatLng, bool) {
+	if len(zones) == 0 {
+		return lines, false
+	}
+
+	var parts [][]LatLng
+	clipped := false
+	for _, line := range lines {
+		var current []LatLng
+		var lastOutside LatLng
+		visit := func(p LatLng, vertex bool) {
+			if InPrivacyZone(zones, p) {
+				clipped = true
+				// end the part at the last sample before the zone
+				if len(current) > 0 && current[len(current)-1] != lastOutside {
+					current = append(current, lastOutside)
+				}
+				if len(current) > 1 {
+					parts = append(parts, current)
+				}
+				current = nil
+				return
+			}
+			lastOutside = p
+			if len(current) == 0 || vertex {
+				current = append(current, p)
+			}
+		}
+
+		for i, p := range line {
+			if i == 0 {
+				visit(p, true)
+				continue
+			}
+			a := line[i-1]
+			steps := max(1, int(math.Ceil(HaversineMeters(a, p)/privacyClipStepMeters)))
+			for k := 1; k < steps; k++ {
+				t := float64(k) / float64(steps)
+				visit(LatLng{Lat: a.Lat + t*(p.Lat-a.Lat), Lng: a.Lng + t*(p.Lng-a.Lng)}, false)
+			}
+			visit(p, true)
+		}
+		if len(current) > 1 {
+			parts = append(parts, current)
+		}
+	}
+	if !clipped {
+		return lines, false
+	}
+	return parts, true
+}
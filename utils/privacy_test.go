@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClipPrivacyZones(t *testing.T) {
+	// a 200m circle around a point 500m east of the origin
+	zones := []PrivacyZone{{Center: offset(500, 0), RadiusMeters: 200}}
+
+	tests := []struct {
+		name    string
+		line    []LatLng
+		clipped bool
+		// east offsets of the ends of each part left, to within the sampling step
+		parts [][2]float64
+	}{
+		{"away from the zone", []LatLng{offset(0, 300), offset(1000, 300)}, false, [][2]float64{{0, 1000}}},
+		{"through the zone", []LatLng{offset(0, 0), offset(1000, 0)}, true, [][2]float64{{0, 300}, {700, 1000}}},
+		{"into the zone", []LatLng{offset(0, 0), offset(400, 0), offset(500, 0)}, true, [][2]float64{{0, 300}}},
+		{"inside the zone", []LatLng{offset(400, 0), offset(600, 0)}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, clipped := ClipPrivacyZones(zones, [][]LatLng{tt.line})
+			if clipped != tt.clipped {
+				t.Fatalf("clipped = %v, want %v", clipped, tt.clipped)
+			}
+			if len(parts) != len(tt.parts) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.parts))
+			}
+			for i, part := range parts {
+				for _, p := range part {
+					if InPrivacyZone(zones, p) {
+						t.Errorf("part %d keeps %v inside the zone", i, p)
+					}
+				}
+				for j, p := range []LatLng{part[0], part[len(part)-1]} {
+					east := (p.Lng - testOrigin.Lng) * metersPerDegree * math.Cos(testOrigin.Lat*math.Pi/180)
+					if math.Abs(east-tt.parts[i][j]) > privacyClipStepMeters+0.5 {
+						t.Errorf("part %d ends at %.1fm east, want %.0fm", i, east, tt.parts[i][j])
+					}
+				}
+			}
+		})
+	}
+
+	if parts, clipped := ClipPrivacyZones(nil, [][]LatLng{{offset(400, 0), offset(600, 0)}}); clipped || len(parts) != 1 {
+		t.Errorf("without zones got %v, clipped %v", parts, clipped)
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"sort"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// ParseDays parses YYYY-MM-DD strings into dates, skipping anything malformed,
+// and returns them sorted and de-duplicated.
+func ParseDays(values []string) []time.Time {
+	seen := make(map[string]bool, len(values))
+	days := make([]time.Time, 0, len(values))
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		day, err := time.Parse(dayLayout, v)
+		if err != nil {
+			continue
+		}
+		seen[v] = true
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// StreakFreezeEvery is how many active days in a row earn a streak freeze
+const StreakFreezeEvery = 7
+
+// StreakSummary describes a user's streaks as of one day
+type StreakSummary struct {
+	// Current counts the active days of the streak still alive today, which is the one running
+	// through today or, while today has no activity yet, through yesterday
+	Current int
+	Longest int
+	// FreezesEarned counts every StreakFreezeEvery active days reached within a streak
+	FreezesEarned int
+	// ContinuedDays counts the active days that carried on a streak from the day before
+	ContinuedDays int
+	TodayActive   bool
+}
+
+// Streaks summarizes runs of consecutive days in sorted, de-duplicated active days. Frozen days
+// bridge a gap in a run without adding to its length. today is the current day in the same
+// calendar as the other days.
+func Streaks(active, frozen []time.Time, today time.Time) StreakSummary {
+	isActive := make(map[time.Time]bool, len(active))
+	for _, day := range active {
+		isActive[day] = true
+	}
+	days := append([]time.Time{}, active...)
+	for _, day := range frozen {
+		if !isActive[day] {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	var summary StreakSummary
+	run := 0
+	for i, day := range days {
+		if i > 0 && day.Sub(days[i-1]) != 24*time.Hour {
+			run = 0
+		}
+		if !isActive[day] {
+			continue
+		}
+		run++
+		if run > 1 {
+			summary.ContinuedDays++
+		}
+		if run%StreakFreezeEvery == 0 {
+			summary.FreezesEarned++
+		}
+		if run > summary.Longest {
+			summary.Longest = run
+		}
+	}
+
+	summary.TodayActive = isActive[today]
+	if len(days) > 0 {
+		last := days[len(days)-1]
+		if last.Equal(today) || last.Equal(today.AddDate(0, 0, -1)) {
+			summary.Current = run
+		}
+	}
+	return summary
+}
+
+// LocalDay returns the calendar day t falls on in loc, as midnight UTC like the days ParseDays returns
+func LocalDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// FormatDay formats a day as YYYY-MM-DD
+func FormatDay(day time.Time) string {
+	return day.Format(dayLayout)
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestStreaks(t *testing.T) {
+	week := []string{"2026-10-01", "2026-10-02", "2026-10-03", "2026-10-04", "2026-10-05", "2026-10-06", "2026-10-07"}
+
+	tests := []struct {
+		name   string
+		active []string
+		frozen []string
+		today  string
+		want   StreakSummary
+	}{
+		{"no activity", nil, nil, "2026-10-17", StreakSummary{}},
+		{
+			"running through today",
+			[]string{"2026-10-15", "2026-10-16", "2026-10-17"}, nil, "2026-10-17",
+			StreakSummary{Current: 3, Longest: 3, ContinuedDays: 2, TodayActive: true},
+		},
+		{
+			"alive until today ends",
+			[]string{"2026-10-15", "2026-10-16"}, nil, "2026-10-17",
+			StreakSummary{Current: 2, Longest: 2, ContinuedDays: 1},
+		},
+		{
+			"broken",
+			[]string{"2026-10-14", "2026-10-15"}, nil, "2026-10-17",
+			StreakSummary{Longest: 2, ContinuedDays: 1},
+		},
+		{
+			"frozen day bridges a gap",
+			[]string{"2026-10-14", "2026-10-15", "2026-10-17"}, []string{"2026-10-16"}, "2026-10-17",
+			StreakSummary{Current: 3, Longest: 3, ContinuedDays: 2, TodayActive: true},
+		},
+		{
+			"consecutive frozen days",
+			[]string{"2026-10-14", "2026-10-17"}, []string{"2026-10-15", "2026-10-16"}, "2026-10-17",
+			StreakSummary{Current: 2, Longest: 2, ContinuedDays: 1, TodayActive: true},
+		},
+		{
+			"one frozen day does not bridge two",
+			[]string{"2026-10-14", "2026-10-17"}, []string{"2026-10-15"}, "2026-10-17",
+			StreakSummary{Current: 1, Longest: 1, TodayActive: true},
+		},
+		{
+			"frozen yesterday keeps the streak alive",
+			[]string{"2026-10-14", "2026-10-15"}, []string{"2026-10-16"}, "2026-10-17",
+			StreakSummary{Current: 2, Longest: 2, ContinuedDays: 1},
+		},
+		{
+			"active frozen day counts once",
+			[]string{"2026-10-15", "2026-10-16"}, []string{"2026-10-16"}, "2026-10-16",
+			StreakSummary{Current: 2, Longest: 2, ContinuedDays: 1, TodayActive: true},
+		},
+		{
+			"a week earns a freeze",
+			week, nil, "2026-10-08",
+			StreakSummary{Current: 7, Longest: 7, FreezesEarned: 1, ContinuedDays: 6},
+		},
+		{
+			"frozen days do not count towards a freeze",
+			[]string{"2026-10-01", "2026-10-02", "2026-10-03", "2026-10-05", "2026-10-06", "2026-10-07"},
+			[]string{"2026-10-04"}, "2026-10-07",
+			StreakSummary{Current: 6, Longest: 6, ContinuedDays: 5, TodayActive: true},
+		},
+		{
+			"freezes are earned per streak",
+			append(append([]string{}, week...), "2026-10-09", "2026-10-10", "2026-10-11", "2026-10-12", "2026-10-13", "2026-10-14"),
+			nil, "2026-10-17",
+			StreakSummary{Longest: 7, FreezesEarned: 1, ContinuedDays: 11},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			today, err := time.Parse(dayLayout, tt.today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Streaks(ParseDays(tt.active), ParseDays(tt.frozen), today)
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreaksAcrossTimeZones(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		loc     *time.Location
+		walks   []string
+		today   string
+		current int
+	}{
+		// 23:30 and 00:30 UTC are two days in UTC, but one day in Sofia and one in Los Angeles
+		{"utc midnight in utc", time.UTC, []string{"2026-10-16T23:30:00Z", "2026-10-17T00:30:00Z"}, "2026-10-17", 2},
+		{"utc midnight in sofia", sofia, []string{"2026-10-16T23:30:00Z", "2026-10-17T00:30:00Z"}, "2026-10-17", 1},
+		{"utc midnight in los angeles", losAngeles, []string{"2026-10-16T23:30:00Z", "2026-10-17T00:30:00Z"}, "2026-10-16", 1},
+		// half past midnight in Sofia is 21:30 UTC before the clocks go back and 22:30 after
+		{
+			"across the dst change",
+			sofia,
+			[]string{"2026-10-24T21:30:00Z", "2026-10-25T22:30:00Z", "2026-10-26T22:30:00Z"},
+			"2026-10-27",
+			3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var days []string
+			for _, walk := range tt.walks {
+				at, err := time.Parse(time.RFC3339, walk)
+				if err != nil {
+					t.Fatal(err)
+				}
+				days = append(days, FormatDay(LocalDay(at, tt.loc)))
+			}
+			today, err := time.Parse(dayLayout, tt.today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Streaks(ParseDays(days), nil, today).Current; got != tt.current {
+				t.Fatalf("days %v: current streak %d, want %d", days, got, tt.current)
+			}
+		})
+	}
+}
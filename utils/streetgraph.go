@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"math"
+	"sort"
+)
+
+// gridCellDegrees is the size of a spatial index cell, roughly 220m of latitude
+const gridCellDegrees = 0.002
+
+const metersPerDegree = earthRadiusMeters * math.Pi / 180
+
+// Street is a named street made of one or more polylines
+type Street struct {
+	ID    string
+	Name  string
+	Lines [][]LatLng
+}
+
+// StreetCandidate is the closest point of a street to a queried location
+type StreetCandidate struct {
+	Street   *Street
+	Point    LatLng
+	Distance float64
+}
+
+type segmentRef struct {
+	street int
+	line   int
+	index  int
+}
+
+type gridCell struct {
+	x, y int
+}
+
+// StreetGraph holds a street network with a grid index over its segments for nearest-street lookups
+type StreetGraph struct {
+	streets []Street
+	byID    map[string]int
+	grid    map[gridCell][]segmentRef
+}
+
+// NewStreetGraph indexes streets for lookups. Streets sharing an ID are merged into one.
+func NewStreetGraph(streets []Street) *StreetGraph {
+	g := &StreetGraph{
+		streets: MergeStreets(streets),
+		grid:    make(map[gridCell][]segmentRef),
+	}
+	g.byID = make(map[string]int, len(g.streets))
+	for i, street := range g.streets {
+		g.byID[street.ID] = i
+	}
+
+	for si, street := range g.streets {
+		for li, line := range street.Lines {
+			for i := 1; i < len(line); i++ {
+				ref := segmentRef{street: si, line: li, index: i - 1}
+				for _, cell := range cellsCovering(line[i-1], line[i]) {
+					g.grid[cell] = append(g.grid[cell], ref)
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+// MergeStreets combines streets sharing an ID, keeping the first non-empty name, and drops
+// lines too short to have a segment
+func MergeStreets(streets []Street) []Street {
+	merged := make([]Street, 0, len(streets))
+	byID := make(map[string]int, len(streets))
+	for _, street := range streets {
+		idx, ok := byID[street.ID]
+		if !ok {
+			idx = len(merged)
+			byID[street.ID] = idx
+			merged = append(merged, Street{ID: street.ID, Name: street.Name})
+		}
+		if merged[idx].Name == "" {
+			merged[idx].Name = street.Name
+		}
+		for _, line := range street.Lines {
+			if len(line) >= 2 {
+				merged[idx].Lines = append(merged[idx].Lines, line)
+			}
+		}
+	}
+	return merged
+}
+
+// Len returns the number of distinct streets in the graph
+func (g *StreetGraph) Len() int {
+	return len(g.streets)
+}
+
+// Street looks up a street by ID
+func (g *StreetGraph) Street(id string) (*Street, bool) {
+	idx, ok := g.byID[id]
+	if !ok {
+		return nil, false
+	}
+	return &g.streets[idx], true
+}
+
+// Nearby returns up to limit streets within radius meters of p, closest first, one candidate per street
+func (g *StreetGraph) Nearby(p LatLng, radius float64, limit int) []StreetCandidate {
+	latSpan := radius / metersPerDegree
+	lngSpan := radius / (metersPerDegree * math.Max(math.Cos(p.Lat*math.Pi/180), 0.01))
+	lo := cellOf(LatLng{Lat: p.Lat - latSpan, Lng: p.Lng - lngSpan})
+	hi := cellOf(LatLng{Lat: p.Lat + latSpan, Lng: p.Lng + lngSpan})
+
+	best := make(map[int]StreetCandidate)
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			for _, ref := range g.grid[gridCell{x, y}] {
+				line := g.streets[ref.street].Lines[ref.line]
+				snapped, distance := projectOnSegment(p, line[ref.index], line[ref.index+1])
+				if distance > radius {
+					continue
+				}
+				if current, ok := best[ref.street]; ok && current.Distance <= distance {
+					continue
+				}
+				best[ref.street] = StreetCandidate{Street: &g.streets[ref.street], Point: snapped, Distance: distance}
+			}
+		}
+	}
+
+	candidates := make([]StreetCandidate, 0, len(best))
+	for _, candidate := range best {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Distance != candidates[j].Distance {
+			return candidates[i].Distance < candidates[j].Distance
+		}
+		return candidates[i].Street.ID < candidates[j].Street.ID
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// StreetLengthMeters returns the total length of all of a street's lines
+func StreetLengthMeters(street *Street) float64 {
+	total := 0.0
+	for _, line := range street.Lines {
+		total += PathLengthMeters(line)
+	}
+	return total
+}
+
+func cellOf(p LatLng) gridCell {
+	return gridCell{
+		x: int(math.Floor(p.Lng / gridCellDegrees)),
+		y: int(math.Floor(p.Lat / gridCellDegrees)),
+	}
+}
+
+// cellsCovering returns the cells of the bounding box of a segment
+func cellsCovering(a, b LatLng) []gridCell {
+	ca, cb := cellOf(a), cellOf(b)
+	minX, maxX := ca.x, cb.x
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	minY, maxY := ca.y, cb.y
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+
+	cells := make([]gridCell, 0, (maxX-minX+1)*(maxY-minY+1))
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			cells = append(cells, gridCell{x, y})
+		}
+	}
+	return cells
+}
+
+// projectOnSegment snaps p onto segment ab using a local flat projection, which is accurate
+// enough at street scale, and returns the snapped point and its distance from p in meters
+func projectOnSegment(p, a, b LatLng) (LatLng, float64) {
+	cosLat := math.Cos(p.Lat * math.Pi / 180)
+	ax, ay := (a.Lng-p.Lng)*cosLat*metersPerDegree, (a.Lat-p.Lat)*metersPerDegree
+	bx, by := (b.Lng-p.Lng)*cosLat*metersPerDegree, (b.Lat-p.Lat)*metersPerDegree
+
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSq))
+	}
+
+	snapped := LatLng{
+		Lat: a.Lat + t*(b.Lat-a.Lat),
+		Lng: a.Lng + t*(b.Lng-a.Lng),
+	}
+	return snapped, math.Hypot(ax+t*dx, ay+t*dy)
+}
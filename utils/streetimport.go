@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// walkableHighways are the OSM highway values imported as streets. Paths and footways only count
+// when they are named, otherwise every park trail would dilute city coverage.
+var walkableHighways = map[string]bool{
+	"primary":        true,
+	"primary_link":   true,
+	"secondary":      true,
+	"secondary_link": true,
+	"tertiary":       true,
+	"tertiary_link":  true,
+	"residential":    true,
+	"unclassified":   true,
+	"living_street":  true,
+	"pedestrian":     true,
+	"footway":        false,
+	"path":           false,
+	"steps":          false,
+}
+
+// IsWalkableStreet reports whether an OSM way with these tags should be imported as a street
+func IsWalkableStreet(tags map[string]string) bool {
+	unnamedOK, ok := walkableHighways[tags["highway"]]
+	if !ok {
+		return false
+	}
+	if tags["foot"] == "no" || tags["access"] == "private" || tags["area"] == "yes" {
+		return false
+	}
+	return unnamedOK || tags["name"] != ""
+}
+
+// StreetsFromOSMWays groups ways into streets. Ways sharing a name become one street with the id
+// "<prefix>:<slug of the name>", so ids survive re-imports of a newer extract; unnamed ways keep
+// their own id "<prefix>:way/<osm id>".
+func StreetsFromOSMWays(ways []OSMWay, prefix string) []Street {
+	byID := make(map[string]*Street)
+	var order []string
+	for _, way := range ways {
+		name := strings.TrimSpace(way.Tags["name"])
+		id := fmt.Sprintf("%s:way/%d", prefix, way.ID)
+		if name != "" {
+			id = fmt.Sprintf("%s:%s", prefix, Slugify(name))
+		}
+
+		street, ok := byID[id]
+		if !ok {
+			street = &Street{ID: id, Name: name}
+			byID[id] = street
+			order = append(order, id)
+		}
+		street.Lines = append(street.Lines, way.Points)
+	}
+
+	sort.Strings(order)
+	streets := make([]Street, len(order))
+	for i, id := range order {
+		streets[i] = *byID[id]
+	}
+	return streets
+}
+
+// Slugify lowercases s and joins its letters and digits with dashes
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// Time series bucket sizes. Buckets are computed in Go in the user's time zone.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// ValidInterval reports whether interval is a supported bucket size
+func ValidInterval(interval string) bool {
+	return interval == IntervalDay || interval == IntervalWeek || interval == IntervalMonth
+}
+
+// BucketStart returns the local midnight in loc that opens the bucket containing t. Weeks start on
+// Monday (ISO weeks).
+func BucketStart(t time.Time, interval string, loc *time.Location) time.Time {
+	local := t.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	switch interval {
+	case IntervalWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case IntervalMonth:
+		return time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return day
+}
+
+// NextBucket returns the start of the bucket after the one starting at start
+func NextBucket(start time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// BucketStarts lists the starts of every bucket overlapping [from, to], failing when there are
+// more than limit of them
+func BucketStarts(from, to time.Time, interval string, loc *time.Location, limit int) ([]time.Time, error) {
+	var starts []time.Time
+	for start := BucketStart(from, interval, loc); !start.After(to); start = NextBucket(start, interval) {
+		if len(starts) == limit {
+			return nil, fmt.Errorf("range spans more than %d %ss", limit, interval)
+		}
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// BucketKey formats a bucket start the way it is keyed in query results
+func BucketKey(start time.Time) string {
+	return start.Format(dayLayout)
+}
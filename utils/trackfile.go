@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoTimestamps is returned for track files whose points carry no times, which cannot become visits
+var ErrNoTimestamps = errors.New("track has no timestamps")
+
+// ErrInvalidTrackFile is wrapped by every error about a malformed GPX or KML file
+var ErrInvalidTrackFile = errors.New("invalid track file")
+
+// ParseGPX reads the timed track points of every track segment in a GPX 1.0 or 1.1 file.
+// Route and waypoint elements are ignored since they describe plans rather than walks.
+func ParseGPX(r io.Reader) ([]TrackPoint, error) {
+	decoder := xml.NewDecoder(r)
+	var points []TrackPoint
+	var current *TrackPoint
+	var inTime bool
+	untimed := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: GPX: %w", ErrInvalidTrackFile, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "trkpt":
+				point, err := gpxPoint(t)
+				if err != nil {
+					return nil, err
+				}
+				current = &point
+			case "time":
+				inTime = current != nil
+			}
+		case xml.CharData:
+			if inTime {
+				timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(string(t)))
+				if err != nil {
+					return nil, fmt.Errorf("%w: GPX time %q", ErrInvalidTrackFile, strings.TrimSpace(string(t)))
+				}
+				current.Timestamp = timestamp.UnixMilli()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "time":
+				inTime = false
+			case "trkpt":
+				if current.Timestamp == 0 {
+					untimed++
+				} else {
+					points = append(points, *current)
+				}
+				current = nil
+			}
+		}
+	}
+
+	if len(points) == 0 && untimed > 0 {
+		return nil, ErrNoTimestamps
+	}
+	return points, nil
+}
+
+func gpxPoint(element xml.StartElement) (TrackPoint, error) {
+	var point TrackPoint
+	var hasLat, hasLon bool
+	for _, attr := range element.Attr {
+		value, err := strconv.ParseFloat(attr.Value, 64)
+		switch attr.Name.Local {
+		case "lat":
+			if err != nil {
+				return point, fmt.Errorf("%w: GPX latitude %q", ErrInvalidTrackFile, attr.Value)
+			}
+			point.Lat, hasLat = value, true
+		case "lon":
+			if err != nil {
+				return point, fmt.Errorf("%w: GPX longitude %q", ErrInvalidTrackFile, attr.Value)
+			}
+			point.Lng, hasLon = value, true
+		}
+	}
+	if !hasLat || !hasLon {
+		return point, fmt.Errorf("%w: GPX track point without lat and lon", ErrInvalidTrackFile)
+	}
+	return point, nil
+}
+
+// ParseKML reads the points of every gx:Track in a KML file, pairing each <when> with the
+// <gx:coord> at the same position. Plain LineStrings carry no times and are not supported.
+func ParseKML(r io.Reader) ([]TrackPoint, error) {
+	decoder := xml.NewDecoder(r)
+	var points []TrackPoint
+	var whens []int64
+	var coords []LatLng
+	var field string
+	var text strings.Builder
+	inTrack, sawLineString := false, false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: KML: %w", ErrInvalidTrackFile, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "Track":
+				inTrack = true
+				whens, coords = whens[:0], coords[:0]
+			case "LineString":
+				sawLineString = true
+			case "when", "coord":
+				if inTrack {
+					field = t.Name.Local
+					text.Reset()
+				}
+			}
+		case xml.CharData:
+			if field != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "when":
+				if field == "when" {
+					timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(text.String()))
+					if err != nil {
+						return nil, fmt.Errorf("%w: KML time %q", ErrInvalidTrackFile, strings.TrimSpace(text.String()))
+					}
+					whens = append(whens, timestamp.UnixMilli())
+				}
+				field = ""
+			case "coord":
+				if field == "coord" {
+					// gx:coord is "lng lat [alt]"
+					parts := strings.Fields(text.String())
+					if len(parts) < 2 {
+						return nil, fmt.Errorf("%w: KML coordinate %q", ErrInvalidTrackFile, text.String())
+					}
+					lng, errLng := strconv.ParseFloat(parts[0], 64)
+					lat, errLat := strconv.ParseFloat(parts[1], 64)
+					if errLng != nil || errLat != nil {
+						return nil, fmt.Errorf("%w: KML coordinate %q", ErrInvalidTrackFile, text.String())
+					}
+					coords = append(coords, LatLng{Lat: lat, Lng: lng})
+				}
+				field = ""
+			case "Track":
+				if len(whens) != len(coords) {
+					return nil, fmt.Errorf("%w: KML track has %d times but %d coordinates", ErrInvalidTrackFile, len(whens), len(coords))
+				}
+				for i := range whens {
+					points = append(points, TrackPoint{Lat: coords[i].Lat, Lng: coords[i].Lng, Timestamp: whens[i]})
+				}
+				inTrack = false
+			}
+		}
+	}
+
+	if len(points) == 0 && sawLineString {
+		return nil, ErrNoTimestamps
+	}
+	return points, nil
+}